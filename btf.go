@@ -0,0 +1,552 @@
+package binparsergen
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+/* The BPF Type Format (BTF) is a compact type description shipped by
+   modern Linux kernels in /sys/kernel/btf/vmlinux and by eBPF objects
+   in their .BTF section. The file starts with a header followed by a
+   sequence of type records (numbered from 1, 0 is void) and a string
+   table.
+
+   We accept either a raw BTF blob or an ELF file with a .BTF section
+   and convert all named structs and unions into vtypes. Like for
+   DWARF, anonymous structs are named after their typedef or the
+   parent struct and field, anonymous members are flattened into
+   their parent and arrays of char become Strings. Types which refer
+   to themselves (other than through a struct) are errors.
+*/
+
+const (
+	BTF_MAGIC = 0xeb9f
+
+	BTF_KIND_INT        = 1
+	BTF_KIND_PTR        = 2
+	BTF_KIND_ARRAY      = 3
+	BTF_KIND_STRUCT     = 4
+	BTF_KIND_UNION      = 5
+	BTF_KIND_ENUM       = 6
+	BTF_KIND_FWD        = 7
+	BTF_KIND_TYPEDEF    = 8
+	BTF_KIND_VOLATILE   = 9
+	BTF_KIND_CONST      = 10
+	BTF_KIND_RESTRICT   = 11
+	BTF_KIND_FUNC       = 12
+	BTF_KIND_FUNC_PROTO = 13
+	BTF_KIND_VAR        = 14
+	BTF_KIND_DATASEC    = 15
+	BTF_KIND_FLOAT      = 16
+	BTF_KIND_DECL_TAG   = 17
+	BTF_KIND_TYPE_TAG   = 18
+	BTF_KIND_ENUM64     = 19
+
+	BTF_INT_SIGNED = 1
+	BTF_INT_CHAR   = 2
+
+	// The deepest nesting of pointers, arrays and anonymous members
+	// which is converted.
+	BTF_MAX_DEPTH = 100
+)
+
+type btfMember struct {
+	Name string
+	Type uint32
+
+	// Offset in bits and the bitfield size (0 for regular members).
+	BitOffset int64
+	BitSize   int64
+}
+
+type btfEnumerate struct {
+	Name  string
+	Value int64
+}
+
+type btfType struct {
+	Kind     uint32
+	Name     string
+	KindFlag bool
+
+	// The size for ints, structs, unions and enums, otherwise the
+	// referenced type.
+	Size int64
+	Type uint32
+
+	// BTF_KIND_INT
+	Encoding  uint32
+	IntOffset int64
+	IntBits   int64
+
+	// BTF_KIND_ARRAY
+	Count int64
+
+	Members    []btfMember
+	Enumerates []btfEnumerate
+}
+
+type btfConverter struct {
+	types      []*btfType
+	big_endian bool
+
+	// Names assigned to each struct and union.
+	names map[uint32]string
+
+	// Structs still to be converted, in order.
+	pending []uint32
+
+	// The types being converted and the anonymous members being
+	// flattened, to detect cycles.
+	converting map[uint32]bool
+	flattening map[uint32]bool
+}
+
+// Find the BTF data in an ELF file.
+func btfFromELF(data []byte) ([]byte, int, error) {
+	elf_file, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	section := elf_file.Section(".BTF")
+	if section == nil {
+		return nil, 0, errors.New("ELF file has no .BTF section")
+	}
+
+	pointer_size := 8
+	if elf_file.Class == elf.ELFCLASS32 {
+		pointer_size = 4
+	}
+
+	btf, err := section.Data()
+	return btf, pointer_size, err
+}
+
+func (self *btfConverter) parse(data []byte) error {
+	if len(data) < 24 {
+		return errors.New("BTF data truncated")
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	switch {
+	case binary.LittleEndian.Uint16(data) == BTF_MAGIC:
+	case binary.BigEndian.Uint16(data) == BTF_MAGIC:
+		order = binary.BigEndian
+		self.big_endian = true
+	default:
+		return errors.New("Not a BTF file")
+	}
+
+	header_len := int64(order.Uint32(data[4:]))
+	type_off := header_len + int64(order.Uint32(data[8:]))
+	type_len := int64(order.Uint32(data[12:]))
+	str_off := header_len + int64(order.Uint32(data[16:]))
+	str_len := int64(order.Uint32(data[20:]))
+	if type_off+type_len > int64(len(data)) || str_off+str_len > int64(len(data)) {
+		return errors.New("BTF data truncated")
+	}
+
+	string_table := data[str_off : str_off+str_len]
+	name := func(offset uint32) string {
+		if int64(offset) >= str_len {
+			return ""
+		}
+		end := bytes.IndexByte(string_table[offset:], 0)
+		if end < 0 {
+			return string(string_table[offset:])
+		}
+		return string(string_table[offset : int64(offset)+int64(end)])
+	}
+
+	types := data[type_off : type_off+type_len]
+	pos := 0
+	u32 := func() uint32 {
+		if pos+4 > len(types) {
+			pos = len(types) + 1
+			return 0
+		}
+		pos += 4
+		return order.Uint32(types[pos-4:])
+	}
+
+	// Type 0 is void.
+	self.types = []*btfType{{}}
+	for pos < len(types) {
+		name_off := u32()
+		info := u32()
+		size_or_type := u32()
+
+		btf_type := &btfType{
+			Name:     name(name_off),
+			Kind:     (info >> 24) & 0x1f,
+			KindFlag: info&(1<<31) != 0,
+			Size:     int64(size_or_type),
+			Type:     size_or_type,
+		}
+		vlen := int(info & 0xffff)
+
+		switch btf_type.Kind {
+		case BTF_KIND_INT:
+			encoding := u32()
+			btf_type.Encoding = encoding >> 24
+			btf_type.IntOffset = int64(encoding>>16) & 0xff
+			btf_type.IntBits = int64(encoding & 0xff)
+
+		case BTF_KIND_ARRAY:
+			btf_type.Type = u32()
+			u32() // Index type
+			btf_type.Count = int64(u32())
+
+		case BTF_KIND_STRUCT, BTF_KIND_UNION:
+			for i := 0; i < vlen; i++ {
+				member := btfMember{Name: name(u32()), Type: u32()}
+				offset := u32()
+				member.BitOffset = int64(offset)
+				if btf_type.KindFlag {
+					member.BitOffset = int64(offset & 0xffffff)
+					member.BitSize = int64(offset >> 24)
+				}
+				btf_type.Members = append(btf_type.Members, member)
+			}
+
+		case BTF_KIND_ENUM:
+			for i := 0; i < vlen; i++ {
+				enumerate := btfEnumerate{Name: name(u32())}
+				value := u32()
+				enumerate.Value = int64(value)
+				if btf_type.KindFlag {
+					// Signed enum.
+					enumerate.Value = int64(int32(value))
+				}
+				btf_type.Enumerates = append(btf_type.Enumerates, enumerate)
+			}
+
+		case BTF_KIND_ENUM64:
+			for i := 0; i < vlen; i++ {
+				enumerate := btfEnumerate{Name: name(u32())}
+				low := u32()
+				enumerate.Value = int64(uint64(u32())<<32 | uint64(low))
+				btf_type.Enumerates = append(btf_type.Enumerates, enumerate)
+			}
+
+		case BTF_KIND_FUNC_PROTO:
+			pos += vlen * 8
+
+		case BTF_KIND_VAR, BTF_KIND_DECL_TAG:
+			pos += 4
+
+		case BTF_KIND_DATASEC:
+			pos += vlen * 12
+
+		case BTF_KIND_PTR, BTF_KIND_FWD, BTF_KIND_TYPEDEF, BTF_KIND_VOLATILE,
+			BTF_KIND_CONST, BTF_KIND_RESTRICT, BTF_KIND_FUNC, BTF_KIND_FLOAT,
+			BTF_KIND_TYPE_TAG:
+
+		default:
+			return fmt.Errorf("Unsupported BTF kind %d for type %d",
+				btf_type.Kind, len(self.types))
+		}
+
+		if pos > len(types) {
+			return errors.New("BTF type data truncated")
+		}
+		self.types = append(self.types, btf_type)
+	}
+
+	return nil
+}
+
+func (self *btfConverter) get(id uint32) *btfType {
+	if int(id) >= len(self.types) {
+		return self.types[0]
+	}
+	return self.types[id]
+}
+
+func (self *btfType) isRecord() bool {
+	return self.Kind == BTF_KIND_STRUCT || self.Kind == BTF_KIND_UNION
+}
+
+// Strip typedefs and qualifiers.
+func (self *btfConverter) resolve(id uint32) (uint32, error) {
+	start := id
+	for i := 0; i <= len(self.types); i++ {
+		switch self.get(id).Kind {
+		case BTF_KIND_TYPEDEF, BTF_KIND_VOLATILE, BTF_KIND_CONST,
+			BTF_KIND_RESTRICT, BTF_KIND_TYPE_TAG:
+			id = self.get(id).Type
+		default:
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("BTF type %d: typedef cycle", start)
+}
+
+func (self *btfConverter) collect() {
+	seen := make(map[string]bool)
+	for id, btf_type := range self.types {
+		name := btf_type.Name
+		target := uint32(id)
+
+		switch btf_type.Kind {
+		case BTF_KIND_STRUCT, BTF_KIND_UNION:
+		case BTF_KIND_TYPEDEF:
+			// typedef struct {...} name;
+			target = btf_type.Type
+			if !self.get(target).isRecord() || self.get(target).Name != "" {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if name == "" || seen[name] {
+			continue
+		}
+		if _, pres := self.names[target]; pres {
+			continue
+		}
+
+		seen[name] = true
+		self.names[target] = name
+		self.pending = append(self.pending, target)
+	}
+}
+
+// The vtype name of an integer or enum type, or "" for other types.
+func (self *btfConverter) integerName(id uint32) string {
+	id, err := self.resolve(id)
+	if err != nil {
+		return ""
+	}
+	btf_type := self.get(id)
+	switch btf_type.Kind {
+	case BTF_KIND_INT:
+		return vtypeIntegerName(btf_type.Size,
+			btf_type.Encoding&BTF_INT_SIGNED != 0, self.big_endian)
+
+	case BTF_KIND_ENUM, BTF_KIND_ENUM64:
+		signed := false
+		for _, enumerate := range btf_type.Enumerates {
+			if enumerate.Value < 0 {
+				signed = true
+			}
+		}
+		return vtypeIntegerName(btf_type.Size, signed, self.big_endian)
+	}
+	return ""
+}
+
+func (self *btfConverter) convertFields(name string, btf_type *btfType,
+	base int64, fields *ordereddict.Dict) error {
+	for _, member := range btf_type.Members {
+		bit := base*8 + member.BitOffset
+		resolved, err := self.resolve(member.Type)
+		if err != nil {
+			return fmt.Errorf("%v.%v: %w", name, member.Name, err)
+		}
+		member_type := self.get(resolved)
+
+		// Anonymous struct or union members are flattened.
+		if member.Name == "" {
+			if member_type.isRecord() {
+				err := self.flatten(name, resolved, bit/8, fields)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// Older BTF encodes bitfields in the int type.
+		bit_size := member.BitSize
+		if bit_size == 0 && member_type.Kind == BTF_KIND_INT &&
+			member_type.IntBits != member_type.Size*8 {
+			bit += member_type.IntOffset
+			bit_size = member_type.IntBits
+		}
+
+		if bit_size > 0 {
+			target := self.integerName(member.Type)
+			if target == "" {
+				return fmt.Errorf("%v.%v: Unsupported bitfield type",
+					name, member.Name)
+			}
+			fields.Set(member.Name, vtypeBitField(
+				bit, bit_size, member_type.Size, target))
+			continue
+		}
+
+		// Name anonymous structs after the field they define.
+		if member_type.isRecord() && member_type.Name == "" {
+			if _, pres := self.names[resolved]; !pres {
+				self.names[resolved] = name + "_" + member.Name
+				self.pending = append(self.pending, resolved)
+			}
+		}
+
+		definition, err := self.convertType(member.Type)
+		if err != nil {
+			return fmt.Errorf("%v.%v: %w", name, member.Name, err)
+		}
+
+		// Unsupported types (e.g. floats) are skipped.
+		if definition == nil {
+			continue
+		}
+		fields.Set(member.Name, vtypeField(bit/8, definition))
+	}
+
+	return nil
+}
+
+// Flatten the fields of an anonymous member into its parent.
+func (self *btfConverter) flatten(name string, id uint32,
+	base int64, fields *ordereddict.Dict) error {
+	if self.flattening[id] {
+		return fmt.Errorf("%v: BTF type %d contains itself", name, id)
+	}
+	if len(self.flattening) >= BTF_MAX_DEPTH {
+		return fmt.Errorf("%v: BTF type %d is nested too deeply", name, id)
+	}
+
+	self.flattening[id] = true
+	defer delete(self.flattening, id)
+
+	return self.convertFields(name, self.get(id), base, fields)
+}
+
+// The vtype definition of a type or nil if it is not supported.
+func (self *btfConverter) convertType(id uint32) ([]interface{}, error) {
+	id, err := self.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	if self.converting[id] {
+		return nil, fmt.Errorf("BTF type %d refers to itself", id)
+	}
+	if len(self.converting) >= BTF_MAX_DEPTH {
+		return nil, fmt.Errorf("BTF type %d is nested too deeply", id)
+	}
+
+	self.converting[id] = true
+	defer delete(self.converting, id)
+
+	btf_type := self.get(id)
+
+	switch btf_type.Kind {
+	case BTF_KIND_INT:
+		name := self.integerName(id)
+		if name == "" {
+			return nil, nil
+		}
+		return vtypeType(name, nil), nil
+
+	case BTF_KIND_ENUM, BTF_KIND_ENUM64:
+		choices := make(map[string]interface{})
+		for _, enumerate := range btf_type.Enumerates {
+			choices[fmt.Sprintf("%d", enumerate.Value)] = enumerate.Name
+		}
+		return vtypeType("Enumeration", map[string]interface{}{
+			"target":  self.integerName(id),
+			"choices": choices,
+		}), nil
+
+	case BTF_KIND_STRUCT, BTF_KIND_UNION, BTF_KIND_FWD:
+		name, pres := self.names[id]
+		if !pres {
+			name = btf_type.Name
+		}
+		if name == "" {
+			return nil, nil
+		}
+		return vtypeType(name, nil), nil
+
+	case BTF_KIND_PTR:
+		target, err := self.convertType(btf_type.Type)
+		if err != nil {
+			return nil, err
+		}
+		if target == nil {
+			target = vtypeType("Void", nil)
+		}
+		return vtypeType("Pointer", vtypeTarget(
+			make(map[string]interface{}), target)), nil
+
+	case BTF_KIND_ARRAY:
+		element_id, err := self.resolve(btf_type.Type)
+		if err != nil {
+			return nil, err
+		}
+		element := self.get(element_id)
+		// Only (signed) char arrays are strings, not unsigned char.
+		if element.Kind == BTF_KIND_INT && element.Size == 1 &&
+			element.Encoding == BTF_INT_SIGNED|BTF_INT_CHAR {
+			return vtypeType("String", map[string]interface{}{
+				"length": btf_type.Count,
+			}), nil
+		}
+
+		target, err := self.convertType(btf_type.Type)
+		if err != nil || target == nil {
+			return nil, err
+		}
+		return vtypeType("Array", vtypeTarget(map[string]interface{}{
+			"count": btf_type.Count,
+		}, target)), nil
+	}
+
+	// Floats, functions and void have no vtype equivalent.
+	return nil, nil
+}
+
+// Convert BTF data (raw or in an ELF .BTF section) into vtype
+// definitions.
+func ConvertBTF(data []byte, spec *ConversionSpec) (*ordereddict.Dict, *ProfileInfo, error) {
+	// Raw BTF does not specify the pointer size.
+	info := &ProfileInfo{}
+	if bytes.HasPrefix(data, []byte(elf.ELFMAG)) {
+		btf, pointer_size, err := btfFromELF(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		info.PointerSize = pointer_size
+		data = btf
+	}
+
+	self := &btfConverter{
+		names:      make(map[uint32]string),
+		converting: make(map[uint32]bool),
+		flattening: make(map[uint32]bool),
+	}
+	err := self.parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	info.PointerBigEndian = self.big_endian
+
+	self.collect()
+
+	result := ordereddict.NewDict()
+	for len(self.pending) > 0 {
+		id := self.pending[0]
+		self.pending = self.pending[1:]
+
+		name := self.names[id]
+		btf_type := self.get(id)
+		fields := ordereddict.NewDict()
+		err := self.convertFields(name, btf_type, 0, fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		result.Set(name, vtypeStruct(btf_type.Size, fields))
+	}
+
+	return result, info, nil
+}
@@ -0,0 +1,104 @@
+package binparsergen
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestConvertBTF(t *testing.T) {
+	spec := &ConversionSpec{
+		Profile:  "TestProfile",
+		Filename: "testdata/btf.o",
+		Format:   FORMAT_BTF,
+		Structs:  []string{"list_head", "task", "task_stats", "version_t"},
+	}
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+	definitions, err := LoadDefinitions(spec)
+	assert.NilError(t, err)
+	assert.Equal(t, definitions.PointerSize, 8)
+
+	task := profile["task"]
+	assert.Equal(t, task.Size, uint32(104))
+	assert.DeepEqual(t, task.fields, []string{
+		"pid", "state", "flags", "prio", "tasks", "comm", "version",
+		"value", "bytes", "stats", "parent", "handler", "payload"})
+
+	assert.Equal(t, task.Fields["state"].Enumeration.Choices[2], "STATE_RUNNING")
+	assert.Equal(t, task.Fields["prio"].Offset, int64(8))
+	assert.Equal(t, task.Fields["prio"].BitField.StartBit, uint64(3))
+	assert.Equal(t, task.Fields["prio"].BitField.EndBit, uint64(10))
+	assert.Equal(t, task.Fields["comm"].StringParser.Length, uint64(16))
+	assert.Equal(t, task.Fields["bytes"].ArrayParser.Count, 8)
+	assert.Equal(t, task.Fields["bytes"].Offset, int64(56))
+	assert.Equal(t, task.Fields["version"].StructParser.Target, "Version_t")
+	assert.Equal(t, task.Fields["stats"].StructParser.Target, "Task_stats")
+	assert.Equal(t, task.Fields["parent"].Pointer.Target.StructParser.Target, "Task")
+	assert.Assert(t, task.Fields["load"] == nil)
+}
+
+func TestConvertRawBTF(t *testing.T) {
+	elf_file, err := elf.Open("testdata/btf.o")
+	assert.NilError(t, err)
+	defer elf_file.Close()
+
+	data, err := elf_file.Section(".BTF").Data()
+	assert.NilError(t, err)
+
+	vtypes, info, err := ConvertBTF(data, &ConversionSpec{})
+	assert.NilError(t, err)
+
+	// Raw BTF does not specify the pointer size.
+	assert.Equal(t, info.PointerSize, 0)
+
+	value, pres := vtypes.Get("list_head")
+	assert.Assert(t, pres)
+	assert.Equal(t, value.([]interface{})[0], int64(16))
+}
+
+// Raw BTF data with the types (as u32 words) and the strings "s" and
+// "f" at offsets 1 and 3.
+func btfData(types ...uint32) []byte {
+	strings := []byte("\x00s\x00f\x00")
+	data := make([]byte, 24+4*len(types))
+	binary.LittleEndian.PutUint16(data, BTF_MAGIC)
+	binary.LittleEndian.PutUint32(data[4:], 24)
+	binary.LittleEndian.PutUint32(data[12:], uint32(4*len(types)))
+	binary.LittleEndian.PutUint32(data[16:], uint32(4*len(types)))
+	binary.LittleEndian.PutUint32(data[20:], uint32(len(strings)))
+	for idx, word := range types {
+		binary.LittleEndian.PutUint32(data[24+4*idx:], word)
+	}
+	return append(data, strings...)
+}
+
+func TestConvertBTFCycles(t *testing.T) {
+	// struct s { f; } where f is of type 2.
+	record := []uint32{1, BTF_KIND_STRUCT<<24 | 1, 8, 3, 2, 0}
+
+	// A pointer to itself.
+	_, _, err := ConvertBTF(btfData(append(record,
+		0, BTF_KIND_PTR<<24, 2)...), &ConversionSpec{})
+	assert.ErrorContains(t, err, "s.f: BTF type 2 refers to itself")
+
+	// A typedef of a const of the typedef.
+	_, _, err = ConvertBTF(btfData(append(record,
+		0, BTF_KIND_TYPEDEF<<24, 3,
+		0, BTF_KIND_CONST<<24, 2)...), &ConversionSpec{})
+	assert.ErrorContains(t, err, "s.f: BTF type 2: typedef cycle")
+
+	// An array of pointers to the array.
+	_, _, err = ConvertBTF(btfData(append(record,
+		0, BTF_KIND_ARRAY<<24, 0, 3, 0, 4,
+		0, BTF_KIND_PTR<<24, 2)...), &ConversionSpec{})
+	assert.ErrorContains(t, err, "s.f: BTF type 2 refers to itself")
+
+	// An anonymous member of the struct's own type.
+	_, _, err = ConvertBTF(btfData(
+		1, BTF_KIND_STRUCT<<24|1, 8, 0, 1, 0), &ConversionSpec{})
+	assert.ErrorContains(t, err, "s: BTF type 1 contains itself")
+}
@@ -0,0 +1,353 @@
+package binparsergen
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* Builders are the inverse of the generated parsers: they assemble
+   a struct in memory from field values so tests can construct
+   fixtures that always match the current profile layout. For
+   example:
+
+   data := profile.NewGUIDBuilder().
+        SetData1(1).
+        SetData4([]byte{1, 2, 3}).Bytes()
+
+   The builder allocates exactly Size() bytes and writes each field
+   at its profile offset. Nested structs are set using their own
+   builders.
+*/
+
+func GenerateBuilderPrototypes() string {
+	return `
+func WriteBytes(buf []byte, offset int64, data []byte) {
+    if offset < 0 || offset >= int64(len(buf)) {
+       return
+    }
+    copy(buf[offset:], data)
+}
+
+func WriteUint64(buf []byte, offset int64, value uint64) {
+    var data [8]byte
+    binary.LittleEndian.PutUint64(data[:], value)
+    WriteBytes(buf, offset, data[:])
+}
+
+func WriteUint32(buf []byte, offset int64, value uint32) {
+    var data [4]byte
+    binary.LittleEndian.PutUint32(data[:], value)
+    WriteBytes(buf, offset, data[:])
+}
+
+func WriteUint16(buf []byte, offset int64, value uint16) {
+    var data [2]byte
+    binary.LittleEndian.PutUint16(data[:], value)
+    WriteBytes(buf, offset, data[:])
+}
+
+func WriteUint64BE(buf []byte, offset int64, value uint64) {
+    var data [8]byte
+    binary.BigEndian.PutUint64(data[:], value)
+    WriteBytes(buf, offset, data[:])
+}
+
+func WriteUint32BE(buf []byte, offset int64, value uint32) {
+    var data [4]byte
+    binary.BigEndian.PutUint32(data[:], value)
+    WriteBytes(buf, offset, data[:])
+}
+
+func WriteUint16BE(buf []byte, offset int64, value uint16) {
+    var data [2]byte
+    binary.BigEndian.PutUint16(data[:], value)
+    WriteBytes(buf, offset, data[:])
+}
+
+func WriteUint8(buf []byte, offset int64, value uint8) {
+    WriteBytes(buf, offset, []byte{value})
+}
+
+// Update the bits between start_bit and end_bit of a size byte
+// integer.
+func WriteBits(buf []byte, offset int64, size int, start_bit, end_bit uint64,
+    value uint64, order binary.ByteOrder) {
+    if offset < 0 || offset + int64(size) > int64(len(buf)) {
+       return
+    }
+    data := buf[offset:offset + int64(size)]
+
+    var current uint64
+    switch size {
+    case 8:
+       current = order.Uint64(data)
+    case 4:
+       current = uint64(order.Uint32(data))
+    case 2:
+       current = uint64(order.Uint16(data))
+    default:
+       current = uint64(data[0])
+    }
+
+    mask := ((uint64(1) << end_bit) - 1) &^ ((uint64(1) << start_bit) - 1)
+    current = (current &^ mask) | ((value << start_bit) & mask)
+
+    switch size {
+    case 8:
+       order.PutUint64(data, current)
+    case 4:
+       order.PutUint32(data, uint32(current))
+    case 2:
+       order.PutUint16(data, uint16(current))
+    default:
+       data[0] = byte(current)
+    }
+}
+
+func WriteString(buf []byte, offset int64, value string, length int64) {
+    data := []byte(value)
+    if length == 0 {
+       data = append(data, 0)
+    } else if length > 0 && int64(len(data)) > length {
+       data = data[:length]
+    }
+    WriteBytes(buf, offset, data)
+}
+
+func WriteUTF16String(buf []byte, offset int64, value string, length int64) {
+    encoded := utf16.Encode([]rune(value))
+    if length == 0 {
+       encoded = append(encoded, 0)
+    }
+    data := make([]byte, 2 * len(encoded))
+    for i, c := range encoded {
+       binary.LittleEndian.PutUint16(data[2*i:], c)
+    }
+    if length > 0 && int64(len(data)) > length {
+       data = data[:length]
+    }
+    WriteBytes(buf, offset, data)
+}
+`
+}
+
+// Returns the writer function and its argument type used to
+// serialize a primitive parser, or "" if the parser has no direct
+// binary representation.
+func builderWriter(parser Parser) (string, string) {
+	switch t := parser.(type) {
+	case *Uint64Parser:
+		return endianWriter("WriteUint64", t.BigEndian), "uint64"
+	case *Int64Parser:
+		return endianWriter("WriteUint64", t.BigEndian), "uint64"
+	case *Uint32Parser:
+		return endianWriter("WriteUint32", t.BigEndian), "uint32"
+	case *Int32Parser:
+		return endianWriter("WriteUint32", t.BigEndian), "uint32"
+	case *Uint16Parser:
+		return endianWriter("WriteUint16", t.BigEndian), "uint16"
+	case *Int16Parser:
+		return endianWriter("WriteUint16", t.BigEndian), "uint16"
+	case *Uint8Parser, *Int8Parser:
+		return "WriteUint8", "uint8"
+	}
+	return "", ""
+}
+
+func endianWriter(writer string, big_endian bool) string {
+	if big_endian {
+		return writer + "BE"
+	}
+	return writer
+}
+
+// The width in bytes of the integer written by a primitive writer.
+func builderWidth(writer string) int {
+	switch strings.TrimSuffix(writer, "BE") {
+	case "WriteUint64":
+		return 8
+	case "WriteUint32":
+		return 4
+	case "WriteUint16":
+		return 2
+	}
+	return 1
+}
+
+func GenerateBuilder(name string, profile_name string, definition *StructDefinition) string {
+	signatures := ""
+	setters := ""
+
+	for _, field_name := range definition.fields {
+		field_def := definition.Fields[field_name]
+		if field_def == nil {
+			continue
+		}
+
+		offset := fmt.Sprintf("self.Profile.Off_%s_%s", name, field_name)
+		guard := ""
+		if field_def.optional {
+			guard = fmt.Sprintf("    if %s < 0 {\n        return self\n    }\n", offset)
+		}
+		setter := fmt.Sprintf(`
+func (self *%[1]sBuilder) Set%[2]s(value %%s) *%[1]sBuilder {
+%[3]s    %%s
+    return self
+}
+`, name, field_name, guard)
+
+		parser := field_def.GetParser()
+		if writer, go_type := builderWriter(parser); writer != "" {
+			setters += fmt.Sprintf(setter, parser.GoType(), fmt.Sprintf(
+				"%s(self.buf, %s, %s(value))", writer, offset, go_type))
+			continue
+		}
+
+		switch t := parser.(type) {
+		case *BitField:
+			writer, _ := builderWriter(t.getParser())
+			order := "binary.LittleEndian"
+			if strings.HasSuffix(writer, "BE") {
+				order = "binary.BigEndian"
+			}
+			setters += fmt.Sprintf(setter, "uint64", fmt.Sprintf(
+				"WriteBits(self.buf, %s, %d, %d, %d, value, %s)",
+				offset, builderWidth(writer), t.StartBit, t.EndBit, order))
+
+		case *Enumeration:
+			writer, go_type := builderWriter(t.getParser())
+			setters += fmt.Sprintf(setter, "uint64", fmt.Sprintf(
+				"%s(self.buf, %s, %s(value))", writer, offset, go_type))
+
+		case *Flags:
+			writer, go_type := builderWriter(t.getParser())
+			setters += fmt.Sprintf(setter, "uint64", fmt.Sprintf(
+				"%s(self.buf, %s, %s(value))", writer, offset, go_type))
+
+		case *WinFileTime:
+			setters += fmt.Sprintf(setter, "time.Time", fmt.Sprintf(
+				"WriteUint64(self.buf, %s, uint64(value.UnixNano() / 100 + 116444736000000000))",
+				offset))
+
+		case *UnixTimeStamp:
+			setters += fmt.Sprintf(setter, "time.Time", fmt.Sprintf(
+				"WriteUint32(self.buf, %s, uint32(value.Unix()))", offset))
+
+		case *Pointer:
+			writer, go_type := builderWriter(t.addressParser())
+			setters += fmt.Sprintf(setter, "uint64", fmt.Sprintf(
+				"%s(self.buf, %s, %s(value))", writer, offset, go_type))
+
+		case *SignatureParser:
+			setters += fmt.Sprintf(setter, "string", fmt.Sprintf(
+				"WriteString(self.buf, %s, value, %d)", offset, len(t.Value)))
+			signature := fmt.Sprintf(
+				"    WriteString(result.buf, result.Profile.Off_%s_%s, %q, %d)\n",
+				name, field_name, t.Value, len(t.Value))
+			if field_def.optional {
+				signature = fmt.Sprintf("    if result.Profile.Off_%s_%s >= 0 {\n%s    }\n",
+					name, field_name, indentCode(signature))
+			}
+			signatures += signature
+
+		case *StringParser:
+			setters += fmt.Sprintf(setter, "string", fmt.Sprintf(
+				"WriteString(self.buf, %s, value, %d)", offset,
+				builderStringLength(t.Length, t.DynamicLength)))
+
+		case *UTF16StringParser:
+			setters += fmt.Sprintf(setter, "string", fmt.Sprintf(
+				"WriteUTF16String(self.buf, %s, value, %d)", offset,
+				builderStringLength(t.Length, t.DynamicLength)))
+
+		case *StructParser:
+			setters += fmt.Sprintf(setter, "*"+t.Target+"Builder", fmt.Sprintf(
+				"WriteBytes(self.buf, %s, value.Bytes())", offset))
+
+		case *ArrayParser:
+			setters += guardMethod(generateArraySetter(name, field_name, offset, t),
+				name+"Builder", "Set"+field_name, func(result_type string) string {
+					return "\n" + strings.TrimSuffix(guard, "\n")
+				})
+		}
+	}
+
+	return fmt.Sprintf(`
+type %[1]sBuilder struct {
+    Profile *%[2]s
+    buf []byte
+}
+
+func (self *%[2]s) New%[1]sBuilder() *%[1]sBuilder {
+    result := &%[1]sBuilder{
+        Profile: self,
+        buf: make([]byte, (&%[1]s{Profile: self}).Size()),
+    }
+%[3]s    return result
+}
+
+func (self *%[1]sBuilder) Size() int {
+    return len(self.buf)
+}
+
+// The serialized struct. This is always exactly Size() bytes long.
+func (self *%[1]sBuilder) Bytes() []byte {
+    return self.buf
+}
+%[4]s`, name, profile_name, signatures, setters)
+}
+
+// Strings with a dynamic length are written without a terminator or
+// truncation (a negative length). Their length field is set
+// separately, like the count of dynamic arrays.
+func builderStringLength(length uint64, dynamic_length string) int64 {
+	if dynamic_length != "" {
+		return -1
+	}
+	return int64(length)
+}
+
+func generateArraySetter(name, field_name, offset string, array *ArrayParser) string {
+	// Fixed size arrays may not spill over into the next field.
+	limit := ""
+	if array.DynamicCount == "" {
+		limit = fmt.Sprintf(`
+    if len(value) > %d {
+       value = value[:%d]
+    }`, array.Count, array.Count)
+	}
+
+	parser := array.Target.GetParser()
+	if struct_parser, ok := parser.(*StructParser); ok {
+		return fmt.Sprintf(`
+func (self *%[1]sBuilder) Set%[2]s(value []*%[3]sBuilder) *%[1]sBuilder {%[4]s
+    offset := %[5]s
+    stride := int64((&%[3]s{Profile: self.Profile}).Size())
+    for _, item := range value {
+       if item != nil {
+          WriteBytes(self.buf, offset, item.Bytes())
+       }
+       offset += stride
+    }
+    return self
+}
+`, name, field_name, struct_parser.Target, limit, offset)
+	}
+
+	writer, go_type := builderWriter(parser)
+	if writer == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(`
+func (self *%[1]sBuilder) Set%[2]s(value []%[3]s) *%[1]sBuilder {%[4]s
+    offset := %[5]s
+    for _, item := range value {
+       %[6]s(self.buf, offset, %[7]s(item))
+       offset += %[8]d
+    }
+    return self
+}
+`, name, field_name, parser.GoType(), limit, offset,
+		writer, go_type, builderWidth(writer))
+}
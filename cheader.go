@@ -0,0 +1,1280 @@
+package binparsergen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+/* Small formats are often easier to describe with a C header than
+   with hand written vtypes:
+
+   #pragma pack(push, 1)
+   typedef struct _HEADER {
+       uint32_t magic;
+       uint16_t version;
+       uint16_t flags : 4;
+       uint16_t type  : 12;
+       char     name[8];
+   } HEADER;
+   #pragma pack(pop)
+
+   The header is parsed and laid out according to the spec's Arch
+   (default amd64) and converted to vtypes. We support struct, union
+   and enum declarations, typedefs, bitfields, #pragma pack, packed
+   attributes, integer #defines and the fixed width stdint.h types.
+   Preprocessor conditionals and includes are ignored.
+
+   Anonymous struct or union members are flattened into their parent
+   like in C. Arrays of char become Strings.
+*/
+
+type cABI struct {
+	PointerSize int64
+	LongSize    int64
+	WCharSize   int64
+
+	// Alignment of 8 byte integers and doubles inside structs.
+	Int64Align int64
+
+	// Microsoft compilers lay out bitfields differently.
+	MSVC bool
+}
+
+var cABIs = map[string]*cABI{
+	"amd64":         {PointerSize: 8, LongSize: 8, WCharSize: 4, Int64Align: 8},
+	"arm64":         {PointerSize: 8, LongSize: 8, WCharSize: 4, Int64Align: 8},
+	"386":           {PointerSize: 4, LongSize: 4, WCharSize: 4, Int64Align: 4},
+	"windows_amd64": {PointerSize: 8, LongSize: 4, WCharSize: 2, Int64Align: 8, MSVC: true},
+	"windows_386":   {PointerSize: 4, LongSize: 4, WCharSize: 2, Int64Align: 8, MSVC: true},
+}
+
+const (
+	cKindInt     = "int"
+	cKindChar    = "char"
+	cKindBool    = "bool"
+	cKindFloat   = "float"
+	cKindVoid    = "void"
+	cKindPointer = "pointer"
+	cKindArray   = "array"
+	cKindRecord  = "record"
+	cKindEnum    = "enum"
+	cKindFunc    = "function"
+)
+
+type cType struct {
+	Kind   string
+	Size   int64
+	Align  int64
+	Signed bool
+
+	// For pointers and arrays.
+	Target *cType
+	Count  int64
+
+	Record *cRecord
+	Enum   *cEnum
+}
+
+type cField struct {
+	Name string
+	Type *cType
+
+	// Bitfield width or -1 for regular fields.
+	Bits int64
+
+	// Filled in by layout. Bitfields are read from an integer of
+	// StorageSize bytes at Offset.
+	Offset      int64
+	StartBit    int64
+	StorageSize int64
+}
+
+type cRecord struct {
+	Name     string
+	Union    bool
+	Packed   bool
+	Pack     int64
+	Fields   []*cField
+	Complete bool
+	Size     int64
+	Align    int64
+}
+
+type cEnum struct {
+	Choices map[string]interface{}
+	Signed  bool
+}
+
+func (self *cType) isRecord() bool {
+	return self.Kind == cKindRecord
+}
+
+// Tokenizer
+
+type cToken struct {
+	Text string
+	Line int
+
+	// Preprocessor directives are returned as a single token.
+	Directive bool
+}
+
+func cTokenize(data string) ([]cToken, error) {
+	result := []cToken{}
+	line := 1
+	line_start := true
+
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '\n':
+			line++
+			line_start = true
+			i++
+			continue
+
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			i++
+			continue
+
+		case strings.HasPrefix(data[i:], "//"):
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			continue
+
+		case strings.HasPrefix(data[i:], "/*"):
+			end := strings.Index(data[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(data[i:i+2+end], "\n")
+			i += end + 4
+			continue
+
+		case c == '#' && line_start:
+			start := i
+			for i < len(data) && data[i] != '\n' {
+				if data[i] == '\\' && i+1 < len(data) && data[i+1] == '\n' {
+					line++
+					i++
+				}
+				i++
+			}
+			directive := strings.Replace(data[start+1:i], "\\\n", " ", -1)
+			result = append(result, cToken{
+				Text: strings.TrimSpace(directive), Line: line, Directive: true})
+			continue
+		}
+
+		line_start = false
+		start := i
+		switch {
+		case c == '_' || unicode.IsLetter(rune(c)):
+			for i < len(data) && (data[i] == '_' ||
+				unicode.IsLetter(rune(data[i])) || unicode.IsDigit(rune(data[i]))) {
+				i++
+			}
+
+		case unicode.IsDigit(rune(c)):
+			for i < len(data) && (data[i] == '.' ||
+				unicode.IsLetter(rune(data[i])) || unicode.IsDigit(rune(data[i]))) {
+				i++
+			}
+
+		case c == '\'':
+			i++
+			for i < len(data) && data[i] != '\'' {
+				if data[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+
+		case c == '"':
+			i++
+			for i < len(data) && data[i] != '"' {
+				if data[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+
+		case strings.HasPrefix(data[i:], "<<") || strings.HasPrefix(data[i:], ">>") ||
+			strings.HasPrefix(data[i:], "..."):
+			if data[i] == '.' {
+				i++
+			}
+			i += 2
+
+		default:
+			i++
+		}
+
+		if i > len(data) {
+			i = len(data)
+		}
+		result = append(result, cToken{Text: data[start:i], Line: line})
+	}
+
+	return result, nil
+}
+
+// Parser
+
+type cParser struct {
+	abi    *cABI
+	tokens []cToken
+	pos    int
+
+	pack       int64
+	pack_stack []int64
+
+	records   map[string]*cRecord
+	typedefs  map[string]*cType
+	constants map[string]int64
+
+	// All named records in declaration order.
+	ordered []*cRecord
+}
+
+func newCParser(abi *cABI, tokens []cToken) *cParser {
+	self := &cParser{
+		abi:       abi,
+		tokens:    tokens,
+		records:   make(map[string]*cRecord),
+		typedefs:  make(map[string]*cType),
+		constants: make(map[string]int64),
+	}
+
+	// Fixed width and common platform types are always available.
+	for name, size := range map[string]int64{
+		"int8_t": 1, "int16_t": 2, "int32_t": 4, "int64_t": 8,
+		"CHAR": 1, "SHORT": 2, "LONG": 4, "LONGLONG": 8,
+		"INT8": 1, "INT16": 2, "INT32": 4, "INT64": 8,
+		"ssize_t": abi.PointerSize, "intptr_t": abi.PointerSize,
+		"ptrdiff_t": abi.PointerSize,
+	} {
+		self.typedefs[name] = self.intType(size, true)
+	}
+
+	for name, size := range map[string]int64{
+		"uint8_t": 1, "uint16_t": 2, "uint32_t": 4, "uint64_t": 8,
+		"BYTE": 1, "UCHAR": 1, "BOOLEAN": 1,
+		"WORD": 2, "USHORT": 2, "WCHAR": 2,
+		"DWORD": 4, "ULONG": 4, "UINT": 4,
+		"QWORD": 8, "ULONGLONG": 8, "DWORD64": 8, "ULONG64": 8,
+		"UINT8": 1, "UINT16": 2, "UINT32": 4, "UINT64": 8,
+		"size_t": abi.PointerSize, "uintptr_t": abi.PointerSize,
+		"wchar_t": abi.WCharSize,
+	} {
+		self.typedefs[name] = self.intType(size, false)
+	}
+
+	return self
+}
+
+func (self *cParser) intType(size int64, signed bool) *cType {
+	align := size
+	if size == 8 {
+		align = self.abi.Int64Align
+	}
+	return &cType{Kind: cKindInt, Size: size, Align: align, Signed: signed}
+}
+
+func (self *cParser) errorf(format string, args ...interface{}) error {
+	line := 0
+	if self.pos < len(self.tokens) {
+		line = self.tokens[self.pos].Line
+	} else if len(self.tokens) > 0 {
+		line = self.tokens[len(self.tokens)-1].Line
+	}
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// Returns the next non directive token without consuming
+// it. Directives are processed as they are encountered.
+func (self *cParser) peek() string {
+	for self.pos < len(self.tokens) && self.tokens[self.pos].Directive {
+		self.directive(self.tokens[self.pos].Text)
+		self.pos++
+	}
+	if self.pos >= len(self.tokens) {
+		return ""
+	}
+	return self.tokens[self.pos].Text
+}
+
+func (self *cParser) peekAt(n int) string {
+	self.peek()
+	for i := self.pos; i < len(self.tokens); i++ {
+		if self.tokens[i].Directive {
+			continue
+		}
+		if n == 0 {
+			return self.tokens[i].Text
+		}
+		n--
+	}
+	return ""
+}
+
+func (self *cParser) next() string {
+	result := self.peek()
+	if self.pos < len(self.tokens) {
+		self.pos++
+	}
+	return result
+}
+
+func (self *cParser) expect(text string) error {
+	token := self.next()
+	if token != text {
+		return self.errorf("expected %q but got %q", text, token)
+	}
+	return nil
+}
+
+func (self *cParser) directive(text string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return
+	}
+
+	switch fields[0] {
+	case "define":
+		if len(fields) < 3 || strings.Contains(fields[1], "(") {
+			return
+		}
+		tokens, err := cTokenize(strings.Join(fields[2:], " "))
+		if err != nil {
+			return
+		}
+		parser := &cParser{abi: self.abi, tokens: tokens, constants: self.constants}
+		value, err := parser.parseExpression()
+		if err == nil && parser.peek() == "" {
+			self.constants[fields[1]] = value
+		}
+
+	case "pragma":
+		directive := strings.Join(fields[1:], "")
+		if !strings.HasPrefix(directive, "pack(") {
+			return
+		}
+		args := strings.Split(strings.TrimSuffix(
+			strings.TrimPrefix(directive, "pack("), ")"), ",")
+
+		switch args[0] {
+		case "push":
+			self.pack_stack = append(self.pack_stack, self.pack)
+			if len(args) > 1 {
+				self.pack, _ = strconv.ParseInt(args[len(args)-1], 0, 64)
+			}
+		case "pop":
+			if len(self.pack_stack) > 0 {
+				self.pack = self.pack_stack[len(self.pack_stack)-1]
+				self.pack_stack = self.pack_stack[:len(self.pack_stack)-1]
+			}
+		case "":
+			self.pack = 0
+		default:
+			self.pack, _ = strconv.ParseInt(args[0], 0, 64)
+		}
+	}
+}
+
+// Skip a balanced group of tokens starting at the current opening
+// token.
+func (self *cParser) skipGroup() {
+	depth := 0
+	for {
+		token := self.next()
+		switch token {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		case "":
+			return
+		}
+		if depth <= 0 {
+			return
+		}
+	}
+}
+
+// Skip __attribute__((...)) and similar annotations. Returns true if
+// the struct should be packed.
+func (self *cParser) skipAttributes() bool {
+	packed := false
+	for {
+		switch self.peek() {
+		case "__attribute__", "__attribute", "__declspec", "__pragma":
+			self.next()
+			start := self.pos
+			self.skipGroup()
+			for _, token := range self.tokens[start:self.pos] {
+				if token.Text == "packed" || token.Text == "__packed__" {
+					packed = true
+				}
+			}
+		case "__packed":
+			self.next()
+			packed = true
+		default:
+			return packed
+		}
+	}
+}
+
+func (self *cParser) parse() error {
+	for self.peek() != "" {
+		err := self.parseDeclaration()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *cParser) parseDeclaration() error {
+	if self.peek() == ";" {
+		self.next()
+		return nil
+	}
+
+	is_typedef := false
+	if self.peek() == "typedef" {
+		self.next()
+		is_typedef = true
+	}
+
+	base, err := self.parseTypeSpec()
+	if err != nil {
+		return err
+	}
+
+	for {
+		if self.peek() == ";" {
+			self.next()
+			return nil
+		}
+
+		name, field_type, err := self.parseDeclarator(base)
+		if err != nil {
+			return err
+		}
+		self.skipAttributes()
+
+		if is_typedef && name != "" {
+			self.typedefs[name] = field_type
+
+			// Anonymous records are named after their typedef.
+			if field_type.isRecord() && field_type.Record.Name == "" {
+				field_type.Record.Name = name
+				self.ordered = append(self.ordered, field_type.Record)
+			}
+		}
+
+		switch self.peek() {
+		case ",":
+			self.next()
+		case "=":
+			// Skip initializers of variables.
+			for self.peek() != ";" && self.peek() != "," && self.peek() != "" {
+				if self.peek() == "{" || self.peek() == "(" {
+					self.skipGroup()
+				} else {
+					self.next()
+				}
+			}
+		case "{":
+			// A function body.
+			self.skipGroup()
+			return nil
+		case ";":
+		default:
+			return self.errorf("unexpected %q", self.peek())
+		}
+	}
+}
+
+func (self *cParser) parseTypeSpec() (*cType, error) {
+	var result *cType
+	signed := true
+	explicit_sign := false
+	longs := 0
+	base := ""
+
+	for {
+		self.skipAttributes()
+		token := self.peek()
+		switch token {
+		case "const", "volatile", "static", "extern", "register", "inline",
+			"__inline", "__inline__", "restrict", "__restrict", "__extension__",
+			"__const", "__volatile__", "_Noreturn":
+			self.next()
+			continue
+
+		case "signed", "__signed__":
+			self.next()
+			signed, explicit_sign = true, true
+			continue
+
+		case "unsigned":
+			self.next()
+			signed, explicit_sign = false, true
+			continue
+
+		case "long":
+			self.next()
+			longs++
+			continue
+
+		case "short", "int", "char", "float", "double", "void", "_Bool", "bool":
+			self.next()
+			if base == "" || base == "int" {
+				base = token
+			}
+			continue
+
+		case "struct", "union":
+			self.next()
+			record, err := self.parseRecord(token == "union")
+			if err != nil {
+				return nil, err
+			}
+			result = &cType{Kind: cKindRecord, Record: record}
+			continue
+
+		case "enum":
+			self.next()
+			enum, err := self.parseEnum()
+			if err != nil {
+				return nil, err
+			}
+			result = enum
+			continue
+		}
+
+		// A typedef name is only a type if we have no type yet.
+		if result == nil && base == "" && longs == 0 && !explicit_sign {
+			if typedef, pres := self.typedefs[token]; pres {
+				self.next()
+				result = typedef
+				continue
+			}
+		}
+		break
+	}
+
+	if result != nil {
+		return result, nil
+	}
+
+	switch base {
+	case "char":
+		if explicit_sign {
+			return self.intType(1, signed), nil
+		}
+		return &cType{Kind: cKindChar, Size: 1, Align: 1, Signed: true}, nil
+	case "short":
+		return self.intType(2, signed), nil
+	case "_Bool", "bool":
+		return &cType{Kind: cKindBool, Size: 1, Align: 1}, nil
+	case "float":
+		return &cType{Kind: cKindFloat, Size: 4, Align: 4}, nil
+	case "double":
+		size := int64(8)
+		if longs > 0 {
+			size = 16
+		}
+		align := size
+		if size == 8 {
+			align = self.abi.Int64Align
+		}
+		return &cType{Kind: cKindFloat, Size: size, Align: align}, nil
+	case "void":
+		return &cType{Kind: cKindVoid, Size: 1, Align: 1}, nil
+	}
+
+	switch {
+	case longs >= 2:
+		return self.intType(8, signed), nil
+	case longs == 1:
+		return self.intType(self.abi.LongSize, signed), nil
+	case base == "int" || explicit_sign:
+		return self.intType(4, signed), nil
+	}
+
+	return nil, self.errorf("unknown type %q", self.peek())
+}
+
+func (self *cParser) parseRecord(union bool) (*cRecord, error) {
+	packed := self.skipAttributes()
+
+	name := ""
+	if self.peek() != "{" {
+		name = self.next()
+	}
+
+	key := "struct " + name
+	if union {
+		key = "union " + name
+	}
+
+	record, pres := self.records[key]
+	if name == "" || !pres {
+		record = &cRecord{Name: name, Union: union}
+		if name != "" {
+			self.records[key] = record
+			self.ordered = append(self.ordered, record)
+		}
+	}
+
+	if self.peek() != "{" {
+		return record, nil
+	}
+	self.next()
+
+	if record.Complete {
+		return nil, self.errorf("redefinition of %v", key)
+	}
+
+	record.Pack = self.pack
+	for self.peek() != "}" {
+		if self.peek() == "" {
+			return nil, self.errorf("unterminated %v", key)
+		}
+		if self.peek() == ";" {
+			self.next()
+			continue
+		}
+
+		base, err := self.parseTypeSpec()
+		if err != nil {
+			return nil, err
+		}
+
+		// Anonymous struct or union member.
+		if self.peek() == ";" {
+			if base.isRecord() {
+				record.Fields = append(record.Fields, &cField{Type: base, Bits: -1})
+			}
+			self.next()
+			continue
+		}
+
+		for {
+			field := &cField{Bits: -1}
+			if self.peek() != ":" {
+				field.Name, field.Type, err = self.parseDeclarator(base)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				field.Type = base
+			}
+
+			if self.peek() == ":" {
+				self.next()
+				field.Bits, err = self.parseExpression()
+				if err != nil {
+					return nil, err
+				}
+			}
+			self.skipAttributes()
+
+			// Name anonymous records after the field they define.
+			if field.Type.isRecord() && field.Type.Record.Name == "" && field.Name != "" {
+				field.Type.Record.Name = record.Name + "_" + field.Name
+				self.ordered = append(self.ordered, field.Type.Record)
+			}
+
+			record.Fields = append(record.Fields, field)
+			if self.peek() != "," {
+				break
+			}
+			self.next()
+		}
+
+		err = self.expect(";")
+		if err != nil {
+			return nil, err
+		}
+	}
+	self.next()
+
+	if self.skipAttributes() {
+		packed = true
+	}
+
+	record.Packed = packed
+	record.Complete = true
+	err := self.layout(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (self *cParser) parseEnum() (*cType, error) {
+	self.skipAttributes()
+	if self.peek() != "{" {
+		self.next()
+	}
+
+	enum := &cEnum{Choices: make(map[string]interface{})}
+	result := &cType{Kind: cKindEnum, Size: 4, Align: 4, Enum: enum}
+	if self.peek() != "{" {
+		return result, nil
+	}
+	self.next()
+
+	value := int64(0)
+	for self.peek() != "}" {
+		name := self.next()
+		if name == "" {
+			return nil, self.errorf("unterminated enum")
+		}
+
+		if self.peek() == "=" {
+			self.next()
+			var err error
+			value, err = self.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		self.constants[name] = value
+		enum.Choices[fmt.Sprintf("%d", value)] = name
+		if value < 0 {
+			enum.Signed = true
+		}
+		if value > 0xffffffff || value < -0x80000000 {
+			result.Size, result.Align = 8, 8
+		}
+		value++
+
+		if self.peek() == "," {
+			self.next()
+		}
+	}
+	self.next()
+
+	return result, nil
+}
+
+// Parse a declarator applied to the base type. The name may be empty
+// for abstract declarators.
+func (self *cParser) parseDeclarator(base *cType) (string, *cType, error) {
+	result := base
+	for self.peek() == "*" || self.peek() == "const" ||
+		self.peek() == "volatile" || self.peek() == "restrict" ||
+		self.peek() == "__restrict" || self.peek() == "__ptr64" {
+		if self.next() == "*" {
+			result = self.pointerTo(result)
+		}
+	}
+	self.skipAttributes()
+
+	name := ""
+	pointers := 0
+	if self.peek() == "(" && (self.peekAt(1) == "*" || self.peekAt(1) == "^") {
+		// Function pointer or pointer to array: (*name)(...)
+		self.next()
+		for self.peek() == "*" || self.peek() == "^" || self.peek() == "const" {
+			if self.next() != "const" {
+				pointers++
+			}
+		}
+		if self.peek() != ")" {
+			name = self.next()
+		}
+		err := self.expect(")")
+		if err != nil {
+			return "", nil, err
+		}
+
+	} else if token := self.peek(); token != "" &&
+		(token[0] == '_' || unicode.IsLetter(rune(token[0]))) {
+		name = self.next()
+	}
+
+	// Array dimensions are applied from the inside out.
+	dimensions := []int64{}
+	for {
+		switch self.peek() {
+		case "[":
+			self.next()
+			count := int64(0)
+			if self.peek() != "]" {
+				var err error
+				count, err = self.parseExpression()
+				if err != nil {
+					return "", nil, err
+				}
+			}
+			err := self.expect("]")
+			if err != nil {
+				return "", nil, err
+			}
+			dimensions = append(dimensions, count)
+			continue
+
+		case "(":
+			self.skipGroup()
+			result = &cType{Kind: cKindFunc, Size: 1, Align: 1}
+			continue
+		}
+		break
+	}
+
+	for i := len(dimensions) - 1; i >= 0; i-- {
+		result = &cType{
+			Kind:   cKindArray,
+			Target: result,
+			Count:  dimensions[i],
+			Size:   result.Size * dimensions[i],
+			Align:  result.Align,
+		}
+	}
+
+	for i := 0; i < pointers; i++ {
+		result = self.pointerTo(result)
+	}
+
+	return name, result, nil
+}
+
+func (self *cParser) pointerTo(target *cType) *cType {
+	return &cType{
+		Kind:   cKindPointer,
+		Target: target,
+		Size:   self.abi.PointerSize,
+		Align:  self.abi.PointerSize,
+	}
+}
+
+// Constant expressions
+
+var cBinaryPrecedence = map[string]int{
+	"|": 1, "^": 2, "&": 3, "<<": 4, ">>": 4,
+	"+": 5, "-": 5, "*": 6, "/": 6, "%": 6,
+}
+
+func (self *cParser) parseExpression() (int64, error) {
+	return self.parseBinary(1)
+}
+
+func (self *cParser) parseBinary(precedence int) (int64, error) {
+	left, err := self.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := self.peek()
+		op_precedence, pres := cBinaryPrecedence[op]
+		if !pres || op_precedence < precedence {
+			return left, nil
+		}
+		self.next()
+
+		right, err := self.parseBinary(op_precedence + 1)
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left <<= uint64(right)
+		case ">>":
+			left >>= uint64(right)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/", "%":
+			if right == 0 {
+				return 0, self.errorf("division by zero")
+			}
+			if op == "/" {
+				left /= right
+			} else {
+				left %= right
+			}
+		}
+	}
+}
+
+func (self *cParser) parseUnary() (int64, error) {
+	token := self.next()
+	switch token {
+	case "-", "~", "+", "!":
+		value, err := self.parseUnary()
+		switch token {
+		case "-":
+			value = -value
+		case "~":
+			value = ^value
+		case "!":
+			if value == 0 {
+				value = 1
+			} else {
+				value = 0
+			}
+		}
+		return value, err
+
+	case "(":
+		// Casts are ignored.
+		if _, pres := self.typedefs[self.peek()]; pres {
+			self.skipGroup()
+			return self.parseUnary()
+		}
+
+		value, err := self.parseExpression()
+		if err != nil {
+			return 0, err
+		}
+		return value, self.expect(")")
+
+	case "sizeof":
+		err := self.expect("(")
+		if err != nil {
+			return 0, err
+		}
+		base, err := self.parseTypeSpec()
+		if err != nil {
+			return 0, err
+		}
+		_, value_type, err := self.parseDeclarator(base)
+		if err != nil {
+			return 0, err
+		}
+		if value_type.isRecord() {
+			return value_type.Record.Size, self.expect(")")
+		}
+		return value_type.Size, self.expect(")")
+
+	case "":
+		return 0, self.errorf("unexpected end of expression")
+	}
+
+	if value, pres := self.constants[token]; pres {
+		return value, nil
+	}
+
+	if token[0] == '\'' {
+		unquoted, err := strconv.Unquote(token)
+		if err != nil || len(unquoted) == 0 {
+			return 0, self.errorf("invalid character %v", token)
+		}
+		return int64(unquoted[0]), nil
+	}
+
+	number := strings.TrimRight(token, "uUlL")
+	value, err := strconv.ParseInt(number, 0, 64)
+	if err != nil {
+		unsigned, err := strconv.ParseUint(number, 0, 64)
+		if err != nil {
+			return 0, self.errorf("invalid constant %v", token)
+		}
+		value = int64(unsigned)
+	}
+	return value, nil
+}
+
+// Layout
+
+func roundUp(value, align int64) int64 {
+	if align <= 1 {
+		return value
+	}
+	return (value + align - 1) / align * align
+}
+
+func (self *cParser) typeSize(field_type *cType) (int64, int64, error) {
+	if field_type.isRecord() {
+		if !field_type.Record.Complete {
+			return 0, 0, fmt.Errorf("incomplete type %v", field_type.Record.Name)
+		}
+		return field_type.Record.Size, field_type.Record.Align, nil
+	}
+
+	if field_type.Kind == cKindArray {
+		size, align, err := self.typeSize(field_type.Target)
+		return size * field_type.Count, align, err
+	}
+
+	return field_type.Size, field_type.Align, nil
+}
+
+func (self *cParser) layout(record *cRecord) error {
+	pack := record.Pack
+	if record.Packed {
+		pack = 1
+	}
+
+	offset := int64(0) // In bits
+	max_align := int64(1)
+	size := int64(0)
+
+	// The current bitfield storage unit (MSVC).
+	unit_size := int64(0)
+	unit_offset := int64(0)
+	unit_used := int64(0)
+
+	for _, field := range record.Fields {
+		field_size, align, err := self.typeSize(field.Type)
+		if err != nil {
+			return self.errorf("%v.%v: %v", record.Name, field.Name, err)
+		}
+		if pack > 0 && align > pack {
+			align = pack
+		}
+
+		if field.Bits == 0 {
+			// Zero width bitfields align the next field.
+			offset = roundUp(offset, align*8)
+			unit_size = 0
+			continue
+		}
+
+		if field.Bits > field_size*8 {
+			return self.errorf("%v.%v: bitfield too wide", record.Name, field.Name)
+		}
+
+		if align > max_align {
+			max_align = align
+		}
+
+		if record.Union {
+			field.Offset = 0
+			if field_size > size {
+				size = field_size
+			}
+			if field.Bits > 0 {
+				field.StartBit = 0
+			}
+			continue
+		}
+
+		switch {
+		case field.Bits < 0:
+			unit_size = 0
+			offset = roundUp(offset, align*8)
+			field.Offset = offset / 8
+			offset += field_size * 8
+
+		case self.abi.MSVC:
+			// A new storage unit starts when the type size changes
+			// or the bits do not fit.
+			if unit_size != field_size || unit_used+field.Bits > field_size*8 {
+				offset = roundUp(offset, align*8)
+				unit_offset = offset / 8
+				unit_size = field_size
+				unit_used = 0
+				offset += field_size * 8
+			}
+			field.Offset = unit_offset
+			field.StartBit = unit_used
+			unit_used += field.Bits
+
+		default:
+			// GCC packs bitfields as long as they do not cross a
+			// naturally aligned storage unit of their type.
+			unit := field_size * 8
+			if pack == 0 && offset/unit != (offset+field.Bits-1)/unit {
+				offset = roundUp(offset, unit)
+			}
+
+			field.StorageSize = 0
+			start := offset - offset%(align*8)
+			if offset-start+field.Bits > unit {
+				// Packed bitfields may straddle their storage
+				// unit, so they are read from the smallest
+				// integer which covers their bits.
+				start = offset - offset%8
+				field.StorageSize, err = bitfieldStorageSize(offset - start + field.Bits)
+				if err != nil {
+					return self.errorf("%v.%v: %v", record.Name, field.Name, err)
+				}
+			}
+			field.Offset = start / 8
+			field.StartBit = offset - start
+			offset += field.Bits
+		}
+	}
+
+	if !record.Union {
+		size = (offset + 7) / 8
+	}
+
+	record.Align = max_align
+	record.Size = roundUp(size, max_align)
+	return nil
+}
+
+// The size of the smallest integer with at least this many bits.
+func bitfieldStorageSize(bits int64) (int64, error) {
+	for _, size := range []int64{1, 2, 4, 8} {
+		if bits <= size*8 {
+			return size, nil
+		}
+	}
+	return 0, fmt.Errorf("bitfield spans more than 64 bits")
+}
+
+// Conversion to vtypes
+
+func cIntegerName(field_type *cType) string {
+	return vtypeIntegerName(field_type.Size, field_type.Signed, false)
+}
+
+func (self *cParser) vtypeFor(field_type *cType) []interface{} {
+	switch field_type.Kind {
+	case cKindInt, cKindChar:
+		return vtypeType(cIntegerName(field_type), nil)
+
+	case cKindBool:
+		return vtypeType("unsigned char", nil)
+
+	case cKindEnum:
+		return vtypeType("Enumeration", map[string]interface{}{
+			"target": vtypeIntegerName(
+				field_type.Size, field_type.Enum.Signed, false),
+			"choices": field_type.Enum.Choices,
+		})
+
+	case cKindRecord:
+		if field_type.Record.Name == "" {
+			return nil
+		}
+		return vtypeType(field_type.Record.Name, nil)
+
+	case cKindPointer:
+		target := self.vtypeFor(field_type.Target)
+		if target == nil {
+			target = vtypeType("Void", nil)
+		}
+		return vtypeType("Pointer", vtypeTarget(
+			make(map[string]interface{}), target))
+
+	case cKindArray:
+		if field_type.Target.Kind == cKindChar {
+			return vtypeType("String", map[string]interface{}{
+				"length": field_type.Count,
+			})
+		}
+
+		target := self.vtypeFor(field_type.Target)
+		if target == nil {
+			return nil
+		}
+		return vtypeType("Array", vtypeTarget(map[string]interface{}{
+			"count": field_type.Count,
+		}, target))
+	}
+
+	// Floats, functions and void have no vtype equivalent.
+	return nil
+}
+
+func (self *cParser) vtypeFields(record *cRecord, base int64, fields *ordereddict.Dict) {
+	for _, field := range record.Fields {
+		offset := base + field.Offset
+
+		// Members of anonymous structs and unions are flattened.
+		if field.Name == "" && field.Type.isRecord() {
+			self.vtypeFields(field.Type.Record, offset, fields)
+			continue
+		}
+
+		if field.Name == "" {
+			continue
+		}
+
+		if field.Bits > 0 {
+			target := field.Type
+			signed := target.Signed
+			if target.Kind == cKindEnum {
+				signed = target.Enum.Signed
+			}
+			storage_size := target.Size
+			if field.StorageSize > 0 {
+				storage_size = field.StorageSize
+			}
+			fields.Set(field.Name, vtypeField(offset, vtypeType(
+				"BitField", map[string]interface{}{
+					"start_bit": field.StartBit,
+					"end_bit":   field.StartBit + field.Bits,
+					"target":    vtypeIntegerName(storage_size, signed, false),
+				})))
+			continue
+		}
+
+		definition := self.vtypeFor(field.Type)
+		if definition != nil {
+			fields.Set(field.Name, vtypeField(offset, definition))
+		}
+	}
+}
+
+func (self *cParser) vtypes() *ordereddict.Dict {
+	result := ordereddict.NewDict()
+	for _, record := range self.ordered {
+		if !record.Complete || record.Name == "" {
+			continue
+		}
+
+		fields := ordereddict.NewDict()
+		self.vtypeFields(record, 0, fields)
+		result.Set(record.Name, vtypeStruct(record.Size, fields))
+	}
+	return result
+}
+
+// Convert a C header into vtype definitions laid out for the spec's
+// Arch.
+func ConvertCHeader(data []byte, spec *ConversionSpec) (*ordereddict.Dict, *ProfileInfo, error) {
+	arch := spec.Arch
+	if arch == "" {
+		arch = "amd64"
+	}
+
+	abi, pres := cABIs[arch]
+	if !pres {
+		return nil, nil, fmt.Errorf("Unsupported Arch %v", arch)
+	}
+
+	tokens, err := cTokenize(string(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parser := newCParser(abi, tokens)
+	err = parser.parse()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return parser.vtypes(), &ProfileInfo{PointerSize: int(abi.PointerSize)}, nil
+}
@@ -0,0 +1,117 @@
+package binparsergen
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	"gotest.tools/assert"
+)
+
+func TestConvertCHeader(t *testing.T) {
+	spec := &ConversionSpec{
+		Profile:  "TestProfile",
+		Filename: "testdata/header.h",
+		Format:   FORMAT_C,
+		Structs:  []string{"_LIST_ENTRY", "_RECORD", "_RECORD_Point", "_PACKED"},
+	}
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+	definitions, err := LoadDefinitions(spec)
+	assert.NilError(t, err)
+	assert.Equal(t, definitions.PointerSize, 8)
+
+	record := profile["_RECORD"]
+	assert.Equal(t, record.Size, uint32(96))
+	assert.DeepEqual(t, record.fields, []string{
+		"Version", "List", "Type", "Flags", "Kind", "Length", "Name",
+		"Value", "Words", "Point", "Counts", "Callback"})
+
+	assert.Equal(t, record.Fields["List"].Offset, int64(8))
+	assert.Equal(t, record.Fields["Type"].Enumeration.Choices[16], "RECORD_LINK")
+	assert.Equal(t, record.Fields["Kind"].Offset, int64(28))
+	assert.Equal(t, record.Fields["Kind"].BitField.StartBit, uint64(4))
+	assert.Equal(t, record.Fields["Kind"].BitField.EndBit, uint64(16))
+	assert.Equal(t, record.Fields["Length"].Int64Parser != nil, true)
+	assert.Equal(t, record.Fields["Name"].StringParser.Length, uint64(8))
+
+	// Members of the anonymous union are flattened.
+	assert.Equal(t, record.Fields["Words"].Offset, int64(48))
+	assert.Equal(t, record.Fields["Point"].StructParser.Target, "RECORD_Point")
+	assert.Assert(t, record.Fields["Weight"] == nil)
+	assert.Equal(t, record.Fields["Counts"].ArrayParser.Count, 4)
+	assert.Equal(t, record.Fields["Callback"].Offset, int64(88))
+
+	packed := profile["_PACKED"]
+	assert.Equal(t, packed.Size, uint32(13))
+	assert.Equal(t, packed.Fields["Value"].Offset, int64(5))
+}
+
+func TestCHeaderPackedBitfields(t *testing.T) {
+	vtypes, _, err := ConvertCHeader([]byte(`
+#pragma pack(push, 1)
+struct _STRADDLE {
+    unsigned int Flags : 6;
+    unsigned int Value : 30;
+    unsigned long long Wide : 60;
+};
+#pragma pack(pop)
+`), &ConversionSpec{})
+	assert.NilError(t, err)
+
+	value, _ := vtypes.Get("_STRADDLE")
+	fields := value.([]interface{})[1].(*ordereddict.Dict)
+
+	// Value needs bits 6-35 so it is read from a 64 bit integer.
+	field, _ := fields.Get("Value")
+	assert.DeepEqual(t, field, vtypeField(0, vtypeType("BitField",
+		map[string]interface{}{
+			"start_bit": int64(6),
+			"end_bit":   int64(36),
+			"target":    "unsigned long long",
+		})))
+
+	// Wide needs bits 4-63 of the byte it starts in.
+	field, _ = fields.Get("Wide")
+	assert.DeepEqual(t, field, vtypeField(4, vtypeType("BitField",
+		map[string]interface{}{
+			"start_bit": int64(4),
+			"end_bit":   int64(64),
+			"target":    "unsigned long long",
+		})))
+
+	_, _, err = ConvertCHeader([]byte(`
+#pragma pack(push, 1)
+struct _TOO_WIDE {
+    unsigned char Tag : 4;
+    unsigned long long Value : 62;
+};
+#pragma pack(pop)
+`), &ConversionSpec{})
+	assert.ErrorContains(t, err, "_TOO_WIDE.Value: bitfield spans more than 64 bits")
+}
+
+func TestCHeaderABI(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/header.h")
+	assert.NilError(t, err)
+
+	for _, testcase := range []struct {
+		arch         string
+		size         int64
+		pointer_size int
+	}{
+		{"amd64", 96, 8},
+		{"386", 72, 4},
+		{"windows_amd64", 88, 8},
+		{"windows_386", 80, 4},
+	} {
+		vtypes, info, err := ConvertCHeader(data, &ConversionSpec{Arch: testcase.arch})
+		assert.NilError(t, err)
+
+		value, _ := vtypes.Get("_RECORD")
+		record := value.([]interface{})
+		assert.Equal(t, record[0], testcase.size, testcase.arch)
+		assert.Equal(t, info.PointerSize, testcase.pointer_size, testcase.arch)
+	}
+}
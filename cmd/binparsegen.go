@@ -8,10 +8,22 @@ import (
 	"www.velocidex.com/golang/binparsergen"
 )
 
+// Subcommands receive the remaining command line arguments. Without
+// a subcommand the only argument is the spec file.
+var commands = map[string]func(args []string){}
+
 func main() {
 	flag.Parse()
 
 	args := flag.Args()
+	if len(args) > 0 {
+		command, pres := commands[args[0]]
+		if pres {
+			command(args[1:])
+			return
+		}
+	}
+
 	if len(args) != 1 {
 		flag.Usage()
 		os.Exit(1)
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"www.velocidex.com/golang/binparsergen"
+)
+
+// Compare the structs of two profiles.
+func doDiff(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	format := flags.String("format", "", "The format of definitions files")
+	as_json := flags.Bool("json", false, "Print the differences as JSON")
+	exit_code := flags.Bool("exit-code", false, "Exit with 1 if there are differences")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: binparsegen diff [options] "+
+			"<old spec or definitions file> <new spec or definitions file> [struct...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	structs := flags.Args()[2:]
+
+	profiles := []map[string]*binparsergen.StructDefinition{}
+	for _, filename := range flags.Args()[:2] {
+		spec, err := loadSpec(filename, *format, structs)
+		binparsergen.FatalIfError(err, "Reading %v", filename)
+
+		profile, err := binparsergen.ConvertSpec(spec)
+		binparsergen.FatalIfError(err, "Parsing %v", filename)
+		profiles = append(profiles, profile)
+	}
+
+	diff, err := binparsergen.DiffProfiles(profiles[0], profiles[1], structs)
+	binparsergen.FatalIfError(err, "Comparing")
+
+	if *as_json {
+		serialized, err := json.MarshalIndent(diff, "", " ")
+		binparsergen.FatalIfError(err, "Formatting")
+		fmt.Println(string(serialized))
+	} else {
+		fmt.Print(diff.String())
+	}
+
+	if *exit_code && !diff.Empty() {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	commands["diff"] = doDiff
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"www.velocidex.com/golang/binparsergen"
+)
+
+// Print the memory layout of the selected structs.
+func doLayout(args []string) {
+	flags := flag.NewFlagSet("layout", flag.ExitOnError)
+	format := flags.String("format", "", "The format of a definitions file")
+	output := flags.String("output", "text", "The output: text, markdown or dot")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: binparsegen layout [options] "+
+			"<spec or definitions file> [struct...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	spec, err := loadSpec(flags.Arg(0), *format, flags.Args()[1:])
+	binparsergen.FatalIfError(err, "Reading")
+
+	profile, err := binparsergen.ConvertSpec(spec)
+	binparsergen.FatalIfError(err, "Parsing")
+
+	switch *output {
+	case "text":
+		fmt.Print(binparsergen.LayoutText(profile))
+	case "markdown":
+		fmt.Print(binparsergen.LayoutMarkdown(profile))
+	case "dot":
+		fmt.Print(binparsergen.LayoutDot(profile))
+	default:
+		flags.Usage()
+		os.Exit(1)
+	}
+}
+
+func init() {
+	commands["layout"] = doLayout
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"www.velocidex.com/golang/binparsergen"
+)
+
+// Subcommands accept either a spec file (.yaml or .yml) or a
+// definitions file in any input format. For definition files the
+// structs are selected by the command (all of them if none are given)
+// and referenced structs are included.
+func loadSpec(filename, format string, structs []string) (
+	*binparsergen.ConversionSpec, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		if format == "" {
+			return binparsergen.LoadSpecFile(filename)
+		}
+	}
+
+	if len(structs) == 0 {
+		structs = []string{"*"}
+	}
+
+	return &binparsergen.ConversionSpec{
+		Filename:          filename,
+		Format:            format,
+		Structs:           structs,
+		IncludeReferenced: true,
+	}, nil
+}
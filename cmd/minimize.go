@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"www.velocidex.com/golang/binparsergen"
+)
+
+// Write a definitions file with only the structs a spec needs.
+func doMinimize(args []string) {
+	flags := flag.NewFlagSet("minimize", flag.ExitOnError)
+	format := flags.String("format", "", "The format of a definitions file")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: binparsegen minimize [options] "+
+			"<spec or definitions file> [struct...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	spec, err := loadSpec(flags.Arg(0), *format, flags.Args()[1:])
+	binparsergen.FatalIfError(err, "Reading")
+
+	vtypes, err := binparsergen.MinimizeDefinitions(spec)
+	binparsergen.FatalIfError(err, "Minimizing")
+
+	out := &bytes.Buffer{}
+	err = json.Indent(out, vtypes, "", " ")
+	binparsergen.FatalIfError(err, "Formatting")
+
+	fmt.Println(out.String())
+}
+
+func init() {
+	commands["minimize"] = doMinimize
+}
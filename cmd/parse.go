@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"www.velocidex.com/golang/binparsergen"
+	"www.velocidex.com/golang/binparsergen/runtime"
+)
+
+// Parse structs from a file without generating code.
+func doParse(args []string) {
+	flags := flag.NewFlagSet("parse", flag.ExitOnError)
+	format := flags.String("format", "", "The format of a definitions file")
+	as_json := flags.Bool("json", false, "Print the structs as JSON")
+	depth := flags.Int("depth", 0, "How many levels of pointers to follow")
+	count := flags.Int("count", 1, "How many consecutive structs to parse")
+	stride := flags.Int64("stride", 0, "The distance between structs (default the struct size)")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: binparsegen parse [options] "+
+			"<spec or definitions file> <struct> <input file> <offset>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 4 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	struct_name := flags.Arg(1)
+
+	offset, err := strconv.ParseInt(flags.Arg(3), 0, 64)
+	binparsergen.FatalIfError(err, "Offset")
+
+	spec, err := loadSpec(flags.Arg(0), *format, []string{struct_name})
+	binparsergen.FatalIfError(err, "Reading")
+
+	profile, err := binparsergen.ConvertSpec(spec)
+	binparsergen.FatalIfError(err, "Parsing")
+
+	parser := runtime.NewParser(profile)
+	parser.PointerDepth = *depth
+
+	_, struct_def, pres := parser.Struct(struct_name)
+	if !pres {
+		binparsergen.FatalIfError(fmt.Errorf("Unknown struct %v", struct_name),
+			"Parsing")
+	}
+	if *stride == 0 {
+		*stride = int64(struct_def.Size)
+	}
+
+	fd, err := os.Open(flags.Arg(2))
+	binparsergen.FatalIfError(err, "Opening")
+	defer fd.Close()
+
+	result := []*runtime.Struct{}
+	for i := 0; i < *count; i++ {
+		value, err := parser.Parse(fd, struct_name, offset+int64(i)*(*stride))
+		binparsergen.FatalIfError(err, "Parsing")
+		result = append(result, value)
+	}
+
+	if *as_json {
+		var serialized []byte
+		if len(result) == 1 {
+			serialized, err = json.MarshalIndent(result[0], "", " ")
+		} else {
+			serialized, err = json.MarshalIndent(result, "", " ")
+		}
+		binparsergen.FatalIfError(err, "Formatting")
+		fmt.Println(string(serialized))
+		return
+	}
+
+	for _, value := range result {
+		fmt.Print(value.DebugString())
+	}
+}
+
+func init() {
+	commands["parse"] = doParse
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"www.velocidex.com/golang/binparsergen"
+)
+
+// Dump the types in a PDB file as vtype json.
+func doPDB(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: binparsegen pdb <file.pdb>")
+		os.Exit(1)
+	}
+
+	spec := &binparsergen.ConversionSpec{
+		Filename: args[0],
+		Format:   binparsergen.FORMAT_PDB,
+	}
+
+	definitions, err := binparsergen.LoadDefinitions(spec)
+	binparsergen.FatalIfError(err, "Reading PDB")
+
+	out := &bytes.Buffer{}
+	err = json.Indent(out, definitions.VTypes, "", " ")
+	binparsergen.FatalIfError(err, "Formatting")
+
+	fmt.Println(out.String())
+}
+
+func init() {
+	commands["pdb"] = doPDB
+}
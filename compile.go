@@ -5,6 +5,19 @@ import "fmt"
 func GenerateCode(
 	spec *ConversionSpec,
 	profile map[string]*StructDefinition) string {
+	// Some generated methods need additional packages.
+	imports := ""
+	references := ""
+	if spec.GenerateDict {
+		imports += "    \"github.com/Velocidex/ordereddict\"\n"
+		references += "   _ = ordereddict.NewDict\n"
+	}
+	// The offset loader is the only generated code which depends on
+	// this module.
+	if spec.GenerateOffsetLoader {
+		imports += "    \"www.velocidex.com/golang/binparsergen/runtime\"\n"
+	}
+
 	result := fmt.Sprintf(`
 package %s
 
@@ -12,14 +25,16 @@ package %s
 
 import (
     "encoding/binary"
+    "encoding/json"
     "fmt"
     "bytes"
     "io"
     "sort"
     "strings"
+    "time"
     "unicode/utf16"
     "unicode/utf8"
-)
+%s)
 
 var (
    // Depending on autogenerated code we may use this. Add a reference
@@ -28,11 +43,13 @@ var (
    _ = fmt.Sprintf
    _ = utf16.Decode
    _ = binary.LittleEndian
+   _ = json.Marshal
    _ = utf8.RuneError
    _ = sort.Strings
    _ = strings.Join
    _ = io.Copy
-)
+   _ = time.Unix
+%s)
 
 func indent(text string) string {
     result := []string{}
@@ -44,10 +61,21 @@ func indent(text string) string {
 }
 
 
-`, spec.Module, spec.Filename)
+`, spec.Module, spec.Filename, imports, references)
 	profile_name := spec.Profile
 
-	result += GenerateProfileCode(profile_name, profile)
+	result += GenerateSpecProfileCode(spec, profile)
+	if spec.GenerateOffsetLoader {
+		result += GenerateOffsetLoader(profile_name, profile)
+	}
+	if spec.GenerateTypes {
+		result += GenerateTypes(profile_name, profile)
+	}
+	if spec.Detect != nil {
+		// The Detect section was checked in ConvertSpec.
+		detect, _ := GenerateDetect(spec, profile)
+		result += detect
+	}
 	for _, struct_name := range SortedKeys(profile) {
 		struct_def := profile[struct_name]
 		struct_name := NormalizeName(struct_name)
@@ -56,8 +84,45 @@ func indent(text string) string {
 			result += GenerateDebugString(
 				struct_name, profile_name, struct_def)
 		}
+		if spec.GenerateBuilder {
+			result += GenerateBuilder(
+				struct_name, profile_name, struct_def)
+		}
+		if spec.GenerateDecode {
+			result += GenerateDecode(
+				struct_name, profile_name, struct_def,
+				specDepth(spec.DecodeDepth, DEFAULT_DECODE_DEPTH))
+		}
+		if spec.GenerateJSON {
+			result += GenerateJSON(
+				struct_name, profile_name, struct_def,
+				specDepth(spec.JSONDepth, DEFAULT_JSON_DEPTH))
+		}
+		if spec.GenerateDict {
+			result += GenerateDict(
+				struct_name, profile_name, struct_def, spec)
+		}
+		if spec.GenerateErrorAccessors {
+			result += GenerateErrorAccessors(
+				struct_name, profile_name, struct_def)
+		}
 	}
 
 	result += GeneratePrototypes()
+	if spec.GenerateBuilder {
+		result += GenerateBuilderPrototypes()
+	}
+	if spec.GenerateDecode {
+		result += GenerateDecodePrototypes()
+	}
+	if spec.GenerateJSON {
+		result += GenerateJSONPrototypes()
+	}
+	if spec.GenerateErrorAccessors {
+		result += GenerateErrorPrototypes()
+	}
+	if spec.GenerateTypes {
+		result += GenerateTypesPrototypes()
+	}
 	return result
 }
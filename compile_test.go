@@ -0,0 +1,342 @@
+package binparsergen
+
+import (
+	"go/format"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func generateTestCode(t *testing.T, spec *ConversionSpec) string {
+	spec.Module = "main"
+	spec.Profile = "TestProfile"
+	spec.Filename = "testdata/vtypes.json"
+	spec.Structs = []string{"_GUID", "_HEADER"}
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+
+	code := GenerateCode(spec, profile)
+
+	// The generated code must at least be valid Go.
+	_, err = format.Source([]byte(code))
+	assert.NilError(t, err)
+
+	return code
+}
+
+// Build the generated code with a main function in a temporary
+// module and return what it prints.
+func runGeneratedCode(t *testing.T, spec *ConversionSpec, main string) string {
+	if testing.Short() {
+		t.Skip("Building generated code is slow")
+	}
+	go_binary, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("No go toolchain")
+	}
+
+	spec.Module = "main"
+	spec.Profile = "TestProfile"
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+
+	go_sum, err := ioutil.ReadFile("go.sum")
+	assert.NilError(t, err)
+
+	// Generated code is self-contained except for offset loaders
+	// which import the runtime package.
+	go_mod := "module generated\n\ngo 1.13\n\n" +
+		"require github.com/Velocidex/ordereddict v0.0.0-20230909174157-2aa49cc5d11d\n"
+	if spec.GenerateOffsetLoader {
+		repo, err := filepath.Abs(".")
+		assert.NilError(t, err)
+		go_mod += "require www.velocidex.com/golang/binparsergen v0.0.0\n\n" +
+			"replace www.velocidex.com/golang/binparsergen => " + repo + "\n"
+	}
+
+	dir := t.TempDir()
+	for filename, data := range map[string]string{
+		"go.mod":     go_mod,
+		"go.sum":     string(go_sum),
+		"profile.go": GenerateCode(spec, profile),
+		"main.go":    main,
+	} {
+		assert.NilError(t, ioutil.WriteFile(
+			filepath.Join(dir, filename), []byte(data), 0644))
+	}
+
+	cmd := exec.Command(go_binary, "run", ".")
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	assert.NilError(t, err, string(output))
+	return string(output)
+}
+
+func TestBuilderRoundTrip(t *testing.T) {
+	output := runGeneratedCode(t, &ConversionSpec{
+		Filename:        "testdata/vtypes.json",
+		Structs:         []string{"_GUID", "_HEADER"},
+		GenerateBuilder: true,
+	}, `package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+func main() {
+	profile := NewTestProfile()
+	guid := profile.NewGUIDBuilder().SetData1(0xdeadbeef).SetData4([]byte{1, 2, 3})
+	data := profile.NewHEADERBuilder().SetVersion(3).SetType(2).SetFlags(3).
+		SetBits(5).SetCount(2).SetSigned(-1).SetSmall(-2).SetId(guid).
+		SetName("hello").SetWide("wide").SetItems([]uint16{7, 8, 9}).
+		SetSLong(-5).Bytes()
+
+	header := profile.HEADER(bytes.NewReader(data), 0)
+	flags := header.Flags().Values()
+	sort.Strings(flags)
+	fmt.Println(len(data), header.Magic().IsValid(), header.Version(),
+		header.Type().Name, flags, header.Bits())
+	fmt.Println(header.Count(), header.Signed(), header.Small(), header.SLong())
+	fmt.Printf("%#x %v\n", header.Id().Data1(), header.Id().Data4())
+	fmt.Printf("%q %q %v\n", header.Name(), header.Wide(), header.Items())
+}
+`)
+
+	assert.Equal(t, output, "80 true 3 TWO [A B] 5\n"+
+		"2 -1 -2 -5\n"+
+		"0xdeadbeef [1 2 3 0 0 0 0 0]\n"+
+		`"hello\x00\x00\x00" "wide" [7 8]`+"\n")
+}
+
+func TestGenerateBuilder(t *testing.T) {
+	code := generateTestCode(t, &ConversionSpec{GenerateBuilder: true})
+
+	assert.Assert(t, strings.Contains(code,
+		"func (self *TestProfile) NewHEADERBuilder() *HEADERBuilder {"))
+	assert.Assert(t, strings.Contains(code,
+		"func (self *HEADERBuilder) SetId(value *GUIDBuilder) *HEADERBuilder {"))
+	assert.Assert(t, strings.Contains(code,
+		"func (self *GUIDBuilder) SetData4(value []byte) *GUIDBuilder {"))
+}
+
+func TestGenerateDecode(t *testing.T) {
+	code := generateTestCode(t, &ConversionSpec{GenerateDecode: true})
+
+	assert.Assert(t, strings.Contains(code, "    Id *GUIDValue\n"))
+	assert.Assert(t, strings.Contains(code, "    return self.DecodeWithDepth(5)\n"))
+
+	// The renamed field clashes with the Offset member.
+	depth := 0
+	output := runGeneratedCode(t, &ConversionSpec{
+		Filename: "testdata/vtypes.json",
+		Structs:  []string{"_GUID", "_HEADER"},
+		FieldRenames: map[string]map[string]string{
+			"_GUID": {"Data2": "_Offset"},
+		},
+		GenerateBuilder: true,
+		GenerateDecode:  true,
+		DecodeDepth:     &depth,
+	}, `package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+func main() {
+	profile := NewTestProfile()
+	guid := profile.NewGUIDBuilder().SetData1(0xdeadbeef).Set_Offset(7).
+		SetData4([]byte{1, 2, 3})
+	data := profile.NewHEADERBuilder().SetVersion(3).SetType(2).SetCount(2).
+		SetId(guid).SetName("hello").SetItems([]uint16{7, 8}).Bytes()
+
+	// DecodeDepth 0 does not decode nested structs.
+	header := profile.HEADER(bytes.NewReader(data), 0).Decode()
+	fmt.Println(header.Offset, header.Version, header.Type.Name, header.Count,
+		header.Id == nil, header.Guids == nil)
+	fmt.Printf("%q %v %v\n", header.Name, header.Items, header.Dyn)
+
+	header = profile.HEADER(bytes.NewReader(data), 0).DecodeWithDepth(1)
+	fmt.Printf("%#x %d %v %#x\n", header.Id.Data1, header.Id.Offset_,
+		header.Id.Data4, header.Guids[0].Data1)
+}
+`)
+
+	assert.Equal(t, output, "0 3 TWO 2 true true\n"+
+		`"hello\x00\x00\x00" [7 8] [0 0]`+"\n"+
+		"0xdeadbeef 7 [1 2 3 0 0 0 0 0] 0xdeadbeef\n")
+}
+
+func TestGenerateJSON(t *testing.T) {
+	code := generateTestCode(t, &ConversionSpec{GenerateJSON: true})
+
+	assert.Assert(t, strings.Contains(code,
+		"func (self *HEADER) MarshalJSON() ([]byte, error) {"))
+	assert.Assert(t, strings.Contains(code, "    return self.marshalJSON(3), nil\n"))
+
+	// The header points to itself so only the first Next is followed.
+	depth := 1
+	output := runGeneratedCode(t, &ConversionSpec{
+		Filename:        "testdata/vtypes.json",
+		Structs:         []string{"_GUID", "_HEADER"},
+		GenerateBuilder: true,
+		GenerateJSON:    true,
+		JSONDepth:       &depth,
+	}, `package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+func main() {
+	profile := NewTestProfile()
+	guid := profile.NewGUIDBuilder().SetData1(1).SetData4([]byte{1, 2})
+	data := profile.NewHEADERBuilder().SetVersion(3).SetType(2).SetFlags(3).
+		SetBits(5).SetCount(2).SetId(guid).SetName("hi").SetWide("w").
+		SetItems([]uint16{7, 8}).SetNext(80).
+		SetModified(time.Unix(1600000000, 0)).Bytes()
+	data = append(make([]byte, 80), data...)
+
+	serialized, err := json.Marshal(profile.HEADER(bytes.NewReader(data), 80))
+	fmt.Println(string(serialized), err)
+}
+`)
+
+	header := `"Magic":"HDR1","Version":3,"Type":"TWO","Flags":["A","B"],` +
+		`"Bits":5,"Count":2,"Signed":0,"Small":0,`
+	guid := `{"Data1":1,"Data2":0,"Data3":0,"Data4":[1,2,0,0,0,0,0,0]}`
+	text := `"Name":"hi\u0000\u0000\u0000\u0000\u0000\u0000",` +
+		`"Wide":"w\u0000\u0000\u0000","Items":[7,8],"Dyn":[80,0],`
+	times := `"Big":80,"SBig":80,"SLong":0,"Created":"0001-01-01T00:00:00Z",` +
+		`"Modified":"2020-09-13T12:26:40Z",`
+	assert.Equal(t, output, "{"+header+`"Id":`+guid+","+text+
+		`"Next":{`+header+`"Id":null,`+text+`"Next":null,`+times+`"Guids":null},`+
+		times+`"Guids":[`+guid+"]} <nil>\n")
+}
+
+func TestGenerateDict(t *testing.T) {
+	code := generateTestCode(t, &ConversionSpec{
+		GenerateDict:      true,
+		DictNestedStructs: DICT_OMIT,
+		DictPointers:      DICT_LAZY,
+	})
+
+	assert.Assert(t, strings.Contains(code, `"github.com/Velocidex/ordereddict"`))
+	assert.Assert(t, strings.Contains(code,
+		"func (self *HEADER) ToDict() *ordereddict.Dict {"))
+	assert.Assert(t, !strings.Contains(code, `result.Set("Id"`))
+	assert.Assert(t, strings.Contains(code, `result.Set("Next", func() interface{} {`))
+	assert.Assert(t, strings.Contains(code, "return self.Next().toDict(depth - 1)"))
+
+	_, err := ConvertSpec(&ConversionSpec{
+		Filename:     "testdata/vtypes.json",
+		Structs:      []string{"_HEADER"},
+		DictPointers: "lazzy",
+	})
+	assert.ErrorContains(t, err, `DictPointers: unknown mode "lazzy"`)
+
+	// The header points to itself. The lazy Next is converted at
+	// depth 0 so it has no Id.
+	depth := 1
+	output := runGeneratedCode(t, &ConversionSpec{
+		Filename:          "testdata/vtypes.json",
+		Structs:           []string{"_GUID", "_HEADER"},
+		GenerateBuilder:   true,
+		GenerateDict:      true,
+		DictDepth:         &depth,
+		DictNestedStructs: DICT_INLINE,
+		DictPointers:      DICT_LAZY,
+	}, `package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+func main() {
+	profile := NewTestProfile()
+	guid := profile.NewGUIDBuilder().SetData1(1).SetData4([]byte{1, 2})
+	data := profile.NewHEADERBuilder().SetVersion(3).SetType(2).SetCount(2).
+		SetId(guid).SetNext(80).Bytes()
+	data = append(make([]byte, 80), data...)
+
+	dict := profile.HEADER(bytes.NewReader(data), 80).ToDict()
+	id, _ := dict.Get("Id")
+	serialized, err := json.Marshal(id)
+	fmt.Println(string(serialized), err)
+
+	dyn, _ := dict.Get("Dyn")
+	fmt.Printf("%T %v\n", dyn, dyn)
+
+	next, _ := dict.Get("Next")
+	next_dict := next.(func() interface{})().(*ordereddict.Dict)
+	version, _ := next_dict.Get("Version")
+	_, pres := next_dict.Get("Id")
+	fmt.Println(version, pres)
+}
+`)
+
+	assert.Equal(t, output,
+		`{"Data1":1,"Data2":0,"Data3":0,"Data4":[1,2,0,0,0,0,0,0]} <nil>`+"\n"+
+			"[]int [80 0]\n"+
+			"3 false\n")
+}
+
+func TestGenerateErrorAccessors(t *testing.T) {
+	code := generateTestCode(t, &ConversionSpec{GenerateErrorAccessors: true})
+
+	assert.Assert(t, strings.Contains(code,
+		"func (self *HEADER) VersionE() (uint16, error) {"))
+	assert.Assert(t, strings.Contains(code,
+		"func (self *HEADER) IdE() (*GUID, error) {"))
+	assert.Assert(t, strings.Contains(code,
+		`CheckField(self.Reader, self.Profile.Off_HEADER_Dyn + self.Offset, int(Count) * 1, "HEADER", "Dyn")`))
+
+	output := runGeneratedCode(t, &ConversionSpec{
+		Filename:               "testdata/vtypes.json",
+		Structs:                []string{"_GUID", "_HEADER"},
+		GenerateErrorAccessors: true,
+		GenerateBuilder:        true,
+	}, `package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+func main() {
+	profile := NewTestProfile()
+	data := profile.NewHEADERBuilder().SetVersion(3).SetCount(4).
+		SetDyn([]byte{1, 2, 3, 4}).Bytes()
+
+	// The count is readable but the array is not, then neither is.
+	for _, length := range []int{len(data), 57, 16} {
+		header := profile.HEADER(bytes.NewReader(data[:length]), 0)
+		version, err := header.VersionE()
+		fmt.Println(version, err)
+		dyn, err := header.DynE()
+		fmt.Println(dyn, err)
+		_, err = header.IdE()
+		fmt.Println(err)
+	}
+}
+`)
+
+	assert.Equal(t, output, "3 <nil>\n[1 2 3 4] <nil>\n<nil>\n"+
+		"3 <nil>\n[] HEADER.Dyn at 0x38: unexpected EOF\n<nil>\n"+
+		"3 <nil>\n[] HEADER.Count at 0x10: unexpected EOF\n"+
+		"HEADER.Id at 0x14: unexpected EOF\n")
+}
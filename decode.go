@@ -0,0 +1,167 @@
+package binparsergen
+
+import "fmt"
+
+/* Decoding eagerly reads all fields of a struct into a plain Go value
+   struct. For example:
+
+   type GUIDValue struct {
+       Data1 uint32
+       Data2 uint16
+       Data3 uint16
+       Data4 []byte
+   }
+
+   func (self *GUID) Decode() *GUIDValue
+
+   The struct is read from the reader in a single read and all
+   accessors are served from that buffer. Nested structs and arrays of
+   structs are decoded recursively up to the spec's DecodeDepth. The
+   value struct does not refer to the reader so it remains valid after
+   the reader is closed.
+
+   Members which clash with the Offset member are suffixed with _
+   (e.g. the field _Offset becomes Offset_). Only arrays of structs
+   and integers are decoded.
+*/
+
+const DEFAULT_DECODE_DEPTH = 5
+
+func GenerateDecodePrototypes() string {
+	return `
+// A BulkReader serves reads within a pre-read region from memory and
+// defers all other reads to the delegate reader.
+type BulkReader struct {
+    reader io.ReaderAt
+    offset int64
+    data   []byte
+}
+
+func NewBulkReader(reader io.ReaderAt, offset int64, size int) *BulkReader {
+    data := make([]byte, size)
+    n, _ := reader.ReadAt(data, offset)
+    if n < 0 {
+       n = 0
+    }
+    return &BulkReader{reader: reader, offset: offset, data: data[:n]}
+}
+
+func (self *BulkReader) ReadAt(buf []byte, offset int64) (int, error) {
+    if offset >= self.offset &&
+       offset + int64(len(buf)) <= self.offset + int64(len(self.data)) {
+       return copy(buf, self.data[offset - self.offset:]), nil
+    }
+    return self.reader.ReadAt(buf, offset)
+}
+`
+}
+
+func GenerateDecode(name string, profile_name string,
+	definition *StructDefinition, depth int) string {
+	members := ""
+	decoders := ""
+	for _, field_name := range definition.fields {
+		field_def := definition.Fields[field_name]
+		if field_def == nil {
+			continue
+		}
+
+		member := NormalizeName(field_name)
+		if member == "Offset" {
+			member += "_"
+		}
+
+		parser := field_def.GetParser()
+		code := ""
+		switch t := parser.(type) {
+		case *Uint64Parser, *Int64Parser, *Uint32Parser, *Int32Parser,
+			*Uint16Parser, *Int16Parser, *Uint8Parser, *Int8Parser,
+			*BitField, *StringParser, *UTF16StringParser,
+			*WinFileTime, *UnixTimeStamp:
+			members += fmt.Sprintf("    %s %s\n", member, parser.GoType())
+			code = fmt.Sprintf("    result.%s = self.%s()\n",
+				member, field_name)
+
+		case *Enumeration:
+			members += fmt.Sprintf("    %s Enumeration\n", member)
+			code = fmt.Sprintf("    result.%s = *self.%s()\n",
+				member, field_name)
+
+		case *Flags:
+			members += fmt.Sprintf("    %s Flags\n", member)
+			code = fmt.Sprintf("    result.%s = *self.%s()\n",
+				member, field_name)
+
+		case *SignatureParser:
+			members += fmt.Sprintf("    %s Signature\n", member)
+			code = fmt.Sprintf("    result.%s = *self.%s()\n",
+				member, field_name)
+
+		case *Pointer:
+			// Pointers are decoded as the address they point to.
+			members += fmt.Sprintf("    %s uint64\n", member)
+			code = fmt.Sprintf(
+				"    result.%s = uint64(%s(self.Reader, self.Profile.Off_%s_%s + self.Offset))\n",
+				member, t.addressParser().PrototypeName(), name, field_name)
+
+		case *StructParser:
+			members += fmt.Sprintf("    %s *%sValue\n", member, t.Target)
+			code = fmt.Sprintf(`    if depth > 0 {
+        result.%s = self.%s().decode(depth - 1)
+    }
+`, member, field_name)
+
+		case *ArrayParser:
+			target := t.Target.GetParser()
+			switch target.(type) {
+			case *StructParser:
+				members += fmt.Sprintf("    %s []*%sValue\n",
+					member, target.GoType())
+				code = fmt.Sprintf(`    if depth > 0 {
+        for _, item := range self.%s() {
+            result.%s = append(result.%s, item.decode(depth - 1))
+        }
+    }
+`, field_name, member, member)
+
+			case *Uint64Parser, *Int64Parser, *Uint32Parser, *Int32Parser,
+				*Uint16Parser, *Int16Parser, *Uint8Parser, *Int8Parser:
+				members += fmt.Sprintf("    %s []%s\n", member, target.GoType())
+				code = fmt.Sprintf("    result.%s = self.%s()\n",
+					member, field_name)
+
+			default:
+				Logger.Printf("%s.%s: Arrays of %s can not be decoded, skipping",
+					name, field_name, typeInfoKind(target))
+			}
+		}
+		decoders += guardField(name, field_name, field_def, code)
+	}
+
+	return fmt.Sprintf(`
+type %[1]sValue struct {
+    Offset int64
+%[2]s}
+
+// Read all the fields of the struct into a value struct.
+func (self *%[1]s) Decode() *%[1]sValue {
+    return self.DecodeWithDepth(%[4]d)
+}
+
+// Read all the fields of the struct, following nested structs up to
+// depth levels.
+func (self *%[1]s) DecodeWithDepth(depth int) *%[1]sValue {
+    bulk := &%[1]s{
+        Reader: NewBulkReader(self.Reader, self.Offset, self.Size()),
+        Offset: self.Offset,
+        Profile: self.Profile,
+    }
+    return bulk.decode(depth)
+}
+
+func (self *%[1]s) decode(depth int) *%[1]sValue {
+    result := &%[1]sValue{Offset: self.Offset}
+%[3]s    return result
+}
+`, name, members, decoders, depth)
+}
@@ -0,0 +1,365 @@
+package binparsergen
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+/* When the spec has a Detect section, Detect<Profile>() picks the
+   profile version which best matches the data:
+
+   Detect:
+     Struct: _HEADER
+     Checks:
+       Magic: true
+     Versions:
+       Win7: {MajorVersion: 6, MinorVersion: 1}
+       Win10: {MajorVersion: 10, Pdb.Guid: "1B2A3C4D-5E6F-7081-92A3-B4C5D6E7F809"}
+
+   The struct is parsed at the given offset with each version's
+   profile. Its Signature fields are always checked, as are the Checks
+   for all versions and the version's own checks. Fields in nested
+   structs are separated by dots. GUID structs (with the fields Data1
+   to Data4) are compared to GUID strings and arrays of integers to
+   lists of integers, or byte arrays to hex strings. The score of a
+   version is the fraction of its checks which pass.
+*/
+
+type DetectSpec struct {
+	Struct   string                            `json:"Struct"`
+	Checks   map[string]interface{}            `json:"Checks"`
+	Versions map[string]map[string]interface{} `json:"Versions"`
+}
+
+// A field path resolved to the structs and fields along it.
+type detectPath struct {
+	structs   []string
+	fields    []string
+	field_def *FieldDefinition
+
+	// The struct the last field refers to, if any.
+	target *StructDefinition
+}
+
+func resolveDetectPath(profile map[string]*StructDefinition,
+	struct_name, path string) (*detectPath, error) {
+	result := &detectPath{}
+	type_names := make(map[string]string)
+	for type_name := range profile {
+		type_names[NormalizeName(type_name)] = type_name
+	}
+
+	for idx, field_name := range strings.Split(path, ".") {
+		struct_def, pres := profile[struct_name]
+		if !pres {
+			return nil, fmt.Errorf("%v: %v is not generated", path, struct_name)
+		}
+
+		field_def, pres := struct_def.Fields[field_name]
+		if !pres || field_def == nil {
+			return nil, fmt.Errorf("%v: %v has no field %v", path,
+				struct_name, field_name)
+		}
+
+		result.structs = append(result.structs, struct_name)
+		result.fields = append(result.fields, field_name)
+		result.field_def = field_def
+
+		if idx < len(strings.Split(path, "."))-1 {
+			if field_def.StructParser == nil {
+				return nil, fmt.Errorf("%v: %v.%v is not a struct", path,
+					struct_name, field_name)
+			}
+			struct_name = type_names[field_def.StructParser.Target]
+		}
+	}
+
+	if result.field_def.StructParser != nil {
+		result.target = profile[type_names[result.field_def.StructParser.Target]]
+	}
+
+	return result, nil
+}
+
+// Whether the struct has the layout of a GUID.
+func isGUIDStruct(struct_def *StructDefinition) bool {
+	if struct_def == nil {
+		return false
+	}
+	field := func(name string) *FieldDefinition {
+		field_def := struct_def.Fields[name]
+		if field_def == nil {
+			return &FieldDefinition{}
+		}
+		return field_def
+	}
+	data4 := field("Data4").ArrayParser
+	return field("Data1").Uint32Parser != nil &&
+		field("Data2").Uint16Parser != nil &&
+		field("Data3").Uint16Parser != nil &&
+		data4 != nil && data4.Count == 8 && data4.DynamicCount == "" &&
+		data4.Target.Uint8Parser != nil
+}
+
+// Parse a GUID like 1B2A3C4D-5E6F-7081-92A3-B4C5D6E7F809, optionally
+// in braces or without the dashes.
+func parseGUID(text string) ([]byte, error) {
+	digits := strings.Replace(strings.Trim(text, "{}"), "-", "", -1)
+	data, err := hex.DecodeString(digits)
+	if err != nil || len(data) != 16 {
+		return nil, fmt.Errorf("Invalid GUID %v", text)
+	}
+	return data, nil
+}
+
+// A list of integers printed like fmt.Sprint prints slices.
+func detectIntegerList(value interface{}) (string, bool) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return "", false
+	}
+
+	result := []string{}
+	for _, item := range items {
+		switch item.(type) {
+		case int, int64, uint64:
+			result = append(result, fmt.Sprintf("%v", item))
+		default:
+			return "", false
+		}
+	}
+	return "[" + strings.Join(result, " ") + "]", true
+}
+
+// The Go expression checking the field at the path against the value.
+func detectCheck(path *detectPath, value interface{}) (string, error) {
+	accessor := "s"
+	for _, field_name := range path.fields {
+		accessor += "." + field_name + "()"
+	}
+
+	switch t := path.field_def.GetParser().(type) {
+	case *SignatureParser:
+		if valid, ok := value.(bool); ok {
+			return fmt.Sprintf("%s.IsValid() == %v", accessor, valid), nil
+		}
+
+	case *Enumeration:
+		switch value.(type) {
+		case string:
+			return fmt.Sprintf("%s.Name == %q", accessor, value), nil
+		case int, int64, uint64:
+			return fmt.Sprintf("%s.Value == %v", accessor, value), nil
+		}
+
+	case *Flags:
+		if name, ok := value.(string); ok {
+			return fmt.Sprintf("%s.IsSet(%q)", accessor, name), nil
+		}
+
+	// Fixed length strings are padded with nulls.
+	case *StringParser, *UTF16StringParser:
+		if text, ok := value.(string); ok {
+			return fmt.Sprintf("strings.TrimRight(%s, \"\\x00\") == %q",
+				accessor, text), nil
+		}
+
+	case *StructParser:
+		text, ok := value.(string)
+		if !ok || !isGUIDStruct(path.target) {
+			break
+		}
+		guid, err := parseGUID(text)
+		if err != nil {
+			return "", fmt.Errorf("%v: %w", strings.Join(path.fields, "."), err)
+		}
+		return fmt.Sprintf("%[1]s.Data1() == %#[2]x && %[1]s.Data2() == %#[3]x && "+
+			"%[1]s.Data3() == %#[4]x && fmt.Sprint(%[1]s.Data4()) == %[5]q",
+			accessor, guid[:4], guid[4:6], guid[6:8],
+			fmt.Sprint(guid[8:])), nil
+
+	case *ArrayParser:
+		integers := false
+		switch t.Target.GetParser().(type) {
+		case *Uint64Parser, *Int64Parser, *Uint32Parser, *Int32Parser,
+			*Uint16Parser, *Int16Parser, *Uint8Parser, *Int8Parser:
+			integers = true
+		}
+
+		if list, ok := detectIntegerList(value); ok && integers {
+			return fmt.Sprintf("fmt.Sprint(%s) == %q", accessor, list), nil
+		}
+
+		// Byte arrays may also be given as hex strings.
+		text, ok := value.(string)
+		if ok && t.Target.Uint8Parser != nil {
+			data, err := hex.DecodeString(text)
+			if err != nil {
+				return "", fmt.Errorf("%v: Invalid hex string %v",
+					strings.Join(path.fields, "."), text)
+			}
+			return fmt.Sprintf("fmt.Sprint(%s) == %q", accessor,
+				fmt.Sprint(data)), nil
+		}
+
+	case *Pointer:
+		switch value.(type) {
+		case int, int64, uint64:
+			if !t.pointsToStruct() {
+				return fmt.Sprintf("%s == %v", accessor, value), nil
+			}
+		}
+
+	case *Uint64Parser, *Int64Parser, *Uint32Parser, *Int32Parser,
+		*Uint16Parser, *Int16Parser, *Uint8Parser, *Int8Parser,
+		*BitField:
+		switch value.(type) {
+		case int, int64, uint64:
+			return fmt.Sprintf("%s == %v", accessor, value), nil
+		}
+	}
+
+	return "", fmt.Errorf("%v: can not compare %v to %v",
+		strings.Join(path.fields, "."), path.field_def.GetParser().GoType(), value)
+}
+
+// The checks for a version. Fields which are missing in the version
+// always fail.
+func detectChecks(spec *ConversionSpec, profile map[string]*StructDefinition,
+	version *profileVersion, checks map[string]interface{}) ([]string, error) {
+	result := []string{}
+	for _, field_path := range SortedKeys(checks) {
+		path, err := resolveDetectPath(profile, spec.Detect.Struct, field_path)
+		if err != nil {
+			return nil, err
+		}
+
+		check, err := detectCheck(path, checks[field_path])
+		if err != nil {
+			return nil, err
+		}
+
+		for idx, struct_name := range path.structs {
+			if version.offset(struct_name, path.fields[idx]) < 0 {
+				check = "false"
+			}
+		}
+		result = append(result, check)
+	}
+	return result, nil
+}
+
+// Check the Detect section against the profile.
+func checkDetectSpec(spec *ConversionSpec, profile map[string]*StructDefinition) error {
+	if spec.Detect == nil {
+		return nil
+	}
+
+	_, err := GenerateDetect(spec, profile)
+	if err != nil {
+		return fmt.Errorf("Detect: %w", err)
+	}
+	return nil
+}
+
+func GenerateDetect(spec *ConversionSpec,
+	profile map[string]*StructDefinition) (string, error) {
+	detect := spec.Detect
+	struct_def, pres := profile[detect.Struct]
+	if !pres {
+		return "", fmt.Errorf("%v is not generated", detect.Struct)
+	}
+
+	versions := profileVersions(spec, profile)
+	for _, name := range SortedKeys(detect.Versions) {
+		found := false
+		for _, version := range versions {
+			found = found || version.name == name
+		}
+		if !found {
+			return "", fmt.Errorf("Unknown version %v", name)
+		}
+	}
+
+	// Signatures are always checked.
+	signatures := make(map[string]interface{})
+	for _, field_name := range struct_def.fields {
+		field_def := struct_def.Fields[field_name]
+		if field_def != nil && field_def.SignatureParser != nil {
+			signatures[field_name] = true
+		}
+	}
+
+	profile_name := spec.Profile
+	cases := ""
+	names := []string{}
+	for _, version := range versions {
+		checks := []string{}
+		for _, version_checks := range []map[string]interface{}{
+			signatures, detect.Checks, detect.Versions[version.name]} {
+			version_checks, err := detectChecks(spec, profile, version, version_checks)
+			if err != nil {
+				return "", err
+			}
+			checks = append(checks, version_checks...)
+		}
+
+		names = append(names, fmt.Sprintf("%q", version.name))
+		cases += fmt.Sprintf(`
+        case %q:
+            checks = []bool{
+%s            }
+`, version.name, detectCheckList(checks))
+	}
+
+	new_profile := fmt.Sprintf("profile := New%s()", profile_name)
+	if versions[0].name != "" {
+		new_profile = fmt.Sprintf("profile, _ := New%sForVersion(version)",
+			profile_name)
+	}
+
+	return fmt.Sprintf(`
+// Detect the profile version from the %[2]s at the offset. Returns
+// the best matching profile, its version and the fraction of its
+// checks which passed.
+func Detect%[1]s(reader io.ReaderAt, offset int64) (*%[1]s, string, float64) {
+    var best *%[1]s
+    best_version := ""
+    best_score := -1.0
+    for _, version := range []string{%[3]s} {
+        %[4]s
+        s := profile.%[2]s(reader, offset)
+        _ = s
+
+        var checks []bool
+        switch version {
+%[5]s        }
+
+        passed := 0
+        for _, check := range checks {
+            if check {
+                passed++
+            }
+        }
+        score := 0.0
+        if len(checks) > 0 {
+            score = float64(passed) / float64(len(checks))
+        }
+        if score > best_score {
+            best, best_version, best_score = profile, version, score
+        }
+    }
+    return best, best_version, best_score
+}
+`, profile_name, NormalizeName(detect.Struct), strings.Join(names, ", "),
+		new_profile, cases), nil
+}
+
+func detectCheckList(checks []string) string {
+	result := ""
+	for _, check := range checks {
+		result += fmt.Sprintf("                %s,\n", check)
+	}
+	return result
+}
@@ -0,0 +1,99 @@
+package binparsergen
+
+import (
+	"go/format"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestDetect(t *testing.T) {
+	spec := &ConversionSpec{
+		Module:   "main",
+		Profile:  "TestProfile",
+		Filename: "testdata/vtypes.json",
+		Structs:  []string{"_GUID", "_HEADER"},
+		Detect: &DetectSpec{
+			Struct: "_HEADER",
+			Checks: map[string]interface{}{
+				"Version":  2,
+				"Type":     "TWO",
+				"Id.Data1": 5,
+				"Name":     "abc",
+				"Id":       "{00000005-0006-0007-0102-030000000000}",
+				"Id.Data4": "0102030000000000",
+				"Items":    []interface{}{7, 8},
+			},
+		},
+	}
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+
+	code := GenerateCode(spec, profile)
+	_, err = format.Source([]byte(code))
+	assert.NilError(t, err)
+
+	for _, expected := range []string{
+		"func DetectTestProfile(reader io.ReaderAt, offset int64) (*TestProfile, string, float64) {",
+		"        profile := NewTestProfile()",
+		"                s.Magic().IsValid() == true,\n" +
+			"                s.Id().Data1() == 0x00000005 && s.Id().Data2() == 0x0006 && " +
+			"s.Id().Data3() == 0x0007 && fmt.Sprint(s.Id().Data4()) == \"[1 2 3 0 0 0 0 0]\",\n" +
+			"                s.Id().Data1() == 5,\n" +
+			"                fmt.Sprint(s.Id().Data4()) == \"[1 2 3 0 0 0 0 0]\",\n" +
+			"                fmt.Sprint(s.Items()) == \"[7 8]\",\n" +
+			"                strings.TrimRight(s.Name(), \"\\x00\") == \"abc\",\n" +
+			"                s.Type().Name == \"TWO\",\n" +
+			"                s.Version() == 2,\n",
+	} {
+		assert.Assert(t, strings.Contains(code, expected), expected)
+	}
+
+	// Matching data scores 1 and other data the fraction of checks
+	// which pass.
+	checks := spec.Detect.Checks
+	output := runGeneratedCode(t, &ConversionSpec{
+		Filename:        "testdata/vtypes.json",
+		Structs:         []string{"_GUID", "_HEADER"},
+		GenerateBuilder: true,
+		Detect:          &DetectSpec{Struct: "_HEADER", Checks: checks},
+	}, `package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+func main() {
+	profile := NewTestProfile()
+	guid := profile.NewGUIDBuilder().SetData1(5).SetData2(6).SetData3(7).
+		SetData4([]byte{1, 2, 3})
+	header := profile.NewHEADERBuilder().SetVersion(2).SetType(2).
+		SetName("abc").SetItems([]uint16{7, 8})
+
+	for _, data := range [][]byte{
+		append([]byte{}, header.SetId(guid).Bytes()...),
+		header.SetId(guid.SetData4([]byte{1, 2, 4})).Bytes(),
+		profile.NewHEADERBuilder().Bytes(),
+	} {
+		_, version, score := DetectTestProfile(bytes.NewReader(data), 0)
+		fmt.Printf("%q %v\n", version, score)
+	}
+}
+`)
+	assert.Equal(t, output, "\"\" 1\n\"\" 0.75\n\"\" 0.125\n")
+
+	spec.Detect.Checks = map[string]interface{}{"Id": "1B2A"}
+	_, err = ConvertSpec(spec)
+	assert.ErrorContains(t, err, "Detect: Id: Invalid GUID 1B2A")
+
+	spec.Detect.Checks = map[string]interface{}{"Id.Data9": 1}
+	_, err = ConvertSpec(spec)
+	assert.ErrorContains(t, err, "Detect: Id.Data9: _GUID has no field Data9")
+
+	spec.Detect.Checks = map[string]interface{}{"Flags": 1}
+	_, err = ConvertSpec(spec)
+	assert.ErrorContains(t, err, "Detect: Flags: can not compare")
+}
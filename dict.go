@@ -0,0 +1,199 @@
+package binparsergen
+
+import "fmt"
+
+/* Generated structs may be converted to an *ordereddict.Dict for use
+   in Velociraptor VQL queries. Fields are added in vtype order.
+
+   How nested structs and pointers to structs are represented is
+   controlled by the spec's DictNestedStructs and DictPointers:
+
+   - "inline": The target is converted to a dict immediately (up to
+     DictDepth levels).
+   - "lazy": The target is represented by a func() interface{} which
+     converts it on demand. Lazy targets count towards DictDepth as
+     well, so recursive structs terminate.
+   - "omit": The field is not included at all.
+
+   Pointers may additionally be set to "address" (the default) to
+   just include the address they point to.
+
+   Byte arrays are added as []int so they are serialized as numbers
+   like the other integer arrays. Only arrays of structs and integers
+   are added.
+*/
+
+const (
+	DEFAULT_DICT_DEPTH = 3
+
+	DICT_INLINE  = "inline"
+	DICT_LAZY    = "lazy"
+	DICT_OMIT    = "omit"
+	DICT_ADDRESS = "address"
+)
+
+func checkDictSpec(spec *ConversionSpec) error {
+	switch spec.DictNestedStructs {
+	case "", DICT_INLINE, DICT_LAZY, DICT_OMIT:
+	default:
+		return fmt.Errorf("DictNestedStructs: unknown mode %q", spec.DictNestedStructs)
+	}
+
+	switch spec.DictPointers {
+	case "", DICT_INLINE, DICT_LAZY, DICT_OMIT, DICT_ADDRESS:
+	default:
+		return fmt.Errorf("DictPointers: unknown mode %q", spec.DictPointers)
+	}
+	return nil
+}
+
+// Generates the expression representing a struct valued field
+// according to mode.
+func dictStructValue(mode string, field_name, accessor string) string {
+	switch mode {
+	case DICT_OMIT:
+		return ""
+
+	case DICT_LAZY:
+		return fmt.Sprintf(`    if depth > 0 {
+        result.Set(%q, func() interface{} {
+            return %s.toDict(depth - 1)
+        })
+    }
+`, field_name, accessor)
+	}
+
+	return fmt.Sprintf(`    if depth > 0 {
+        result.Set(%q, %s.toDict(depth - 1))
+    }
+`, field_name, accessor)
+}
+
+func GenerateDict(name string, profile_name string,
+	definition *StructDefinition, spec *ConversionSpec) string {
+	depth := specDepth(spec.DictDepth, DEFAULT_DICT_DEPTH)
+
+	result := fmt.Sprintf(`
+func (self *%[1]s) ToDict() *ordereddict.Dict {
+    return self.toDict(%[2]d)
+}
+
+func (self *%[1]s) toDict(depth int) *ordereddict.Dict {
+    result := ordereddict.NewDict()
+`, name, depth)
+
+	for _, field_name := range definition.fields {
+		field_def := definition.Fields[field_name]
+		if field_def == nil {
+			continue
+		}
+
+		code := ""
+		switch t := field_def.GetParser().(type) {
+		case *Uint64Parser, *Int64Parser, *Uint32Parser, *Int32Parser,
+			*Uint16Parser, *Int16Parser, *Uint8Parser, *Int8Parser,
+			*BitField, *StringParser, *UTF16StringParser,
+			*WinFileTime, *UnixTimeStamp:
+			code = fmt.Sprintf("    result.Set(%q, self.%s())\n",
+				field_name, field_name)
+
+		case *Enumeration:
+			code = fmt.Sprintf("    result.Set(%q, self.%s().Name)\n",
+				field_name, field_name)
+
+		case *Flags:
+			code = fmt.Sprintf(`    {
+        names := self.%s().Values()
+        sort.Strings(names)
+        result.Set(%q, names)
+    }
+`, field_name, field_name)
+
+		case *SignatureParser:
+			code = fmt.Sprintf("    result.Set(%q, self.%s().value)\n",
+				field_name, field_name)
+
+		case *StructParser:
+			code = dictStructValue(spec.DictNestedStructs,
+				field_name, fmt.Sprintf("self.%s()", field_name))
+
+		case *Pointer:
+			address := fmt.Sprintf(
+				"uint64(%s(self.Reader, self.Profile.Off_%s_%s + self.Offset))",
+				t.addressParser().PrototypeName(), name, field_name)
+
+			mode := spec.DictPointers
+			if !t.pointsToStruct() || mode == "" || mode == DICT_ADDRESS {
+				code = fmt.Sprintf("    result.Set(%q, %s)\n",
+					field_name, address)
+				break
+			}
+
+			value := dictStructValue(mode, field_name,
+				fmt.Sprintf("self.%s()", field_name))
+			if value != "" {
+				code = fmt.Sprintf("    if %s != 0 {\n    %s    }\n",
+					address, value)
+			}
+
+		case *ArrayParser:
+			target := t.Target.GetParser()
+			switch target.(type) {
+			case *StructParser:
+				code = dictStructArray(spec.DictNestedStructs, field_name)
+
+			case *Uint8Parser:
+				code = fmt.Sprintf(`    {
+        items := []int{}
+        for _, item := range self.%s() {
+            items = append(items, int(item))
+        }
+        result.Set(%q, items)
+    }
+`, field_name, field_name)
+
+			case *Uint64Parser, *Int64Parser, *Uint32Parser, *Int32Parser,
+				*Uint16Parser, *Int16Parser, *Int8Parser:
+				code = fmt.Sprintf("    result.Set(%q, self.%s())\n",
+					field_name, field_name)
+
+			default:
+				Logger.Printf("%s.%s: Arrays of %s can not be added to the dict, skipping",
+					name, field_name, typeInfoKind(target))
+			}
+		}
+		result += guardField(name, field_name, field_def, code)
+	}
+
+	result += "    return result\n}\n"
+
+	return result
+}
+
+func dictStructArray(mode string, field_name string) string {
+	switch mode {
+	case DICT_OMIT:
+		return ""
+
+	case DICT_LAZY:
+		return fmt.Sprintf(`    if depth > 0 {
+        result.Set(%[1]q, func() interface{} {
+            items := []*ordereddict.Dict{}
+            for _, item := range self.%[1]s() {
+                items = append(items, item.toDict(depth - 1))
+            }
+            return items
+        })
+    }
+`, field_name)
+	}
+
+	return fmt.Sprintf(`    if depth > 0 {
+        items := []*ordereddict.Dict{}
+        for _, item := range self.%[1]s() {
+            items = append(items, item.toDict(depth - 1))
+        }
+        result.Set(%[1]q, items)
+    }
+`, field_name)
+}
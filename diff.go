@@ -0,0 +1,203 @@
+package binparsergen
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* Two profiles (e.g. of consecutive Windows builds) may be compared
+   to find the structs whose size changed and the fields which were
+   added, removed, moved or changed their type. The differences are
+   printed as text or serialized to JSON.
+*/
+
+const (
+	DIFF_ADDED   = "added"
+	DIFF_REMOVED = "removed"
+	DIFF_MOVED   = "moved"
+	DIFF_RETYPED = "retyped"
+)
+
+type FieldDiff struct {
+	Name      string
+	Changes   []string
+	OldOffset int64
+	NewOffset int64
+	OldType   string
+	NewType   string
+}
+
+// Change is only set for added or removed structs.
+type StructDiff struct {
+	Name    string
+	Change  string `json:",omitempty"`
+	OldSize uint32
+	NewSize uint32
+	Fields  []*FieldDiff
+}
+
+type ProfileDiff struct {
+	Structs []*StructDiff
+}
+
+func (self *ProfileDiff) Empty() bool {
+	return len(self.Structs) == 0
+}
+
+// Compare the structs which match the patterns (all of them if there
+// are none).
+func DiffProfiles(old_profile, new_profile map[string]*StructDefinition,
+	structs []string) (*ProfileDiff, error) {
+	if len(structs) == 0 {
+		structs = []string{"*"}
+	}
+	patterns, err := newPatternList("Structs", structs)
+	if err != nil {
+		return nil, err
+	}
+
+	type_names := SortedKeys(old_profile)
+	for _, type_name := range SortedKeys(new_profile) {
+		if !InString(type_names, type_name) {
+			type_names = append(type_names, type_name)
+		}
+	}
+
+	old_names := layoutStructNames(old_profile)
+	new_names := layoutStructNames(new_profile)
+	result := &ProfileDiff{}
+	for _, type_name := range type_names {
+		if !patterns.Match(type_name, type_name) {
+			continue
+		}
+
+		old_def, old_pres := old_profile[type_name]
+		new_def, new_pres := new_profile[type_name]
+		switch {
+		case !new_pres:
+			result.Structs = append(result.Structs, &StructDiff{
+				Name: type_name, Change: DIFF_REMOVED, OldSize: old_def.Size})
+
+		case !old_pres:
+			result.Structs = append(result.Structs, &StructDiff{
+				Name: type_name, Change: DIFF_ADDED, NewSize: new_def.Size})
+
+		default:
+			struct_diff := diffStruct(type_name, old_def, new_def, old_names, new_names)
+			if struct_diff != nil {
+				result.Structs = append(result.Structs, struct_diff)
+			}
+		}
+	}
+	return result, nil
+}
+
+// The differences between the two versions of the struct or nil.
+func diffStruct(type_name string, old_def, new_def *StructDefinition,
+	old_names, new_names map[string]string) *StructDiff {
+	result := &StructDiff{
+		Name:    type_name,
+		OldSize: old_def.Size,
+		NewSize: new_def.Size,
+	}
+
+	for _, field_name := range old_def.fields {
+		old_field := old_def.Fields[field_name]
+		if old_field == nil {
+			continue
+		}
+		old_type := layoutTypeName(old_field.GetParser(), old_names)
+
+		new_field := new_def.Fields[field_name]
+		if new_field == nil {
+			result.Fields = append(result.Fields, &FieldDiff{
+				Name:      field_name,
+				Changes:   []string{DIFF_REMOVED},
+				OldOffset: old_field.Offset,
+				OldType:   old_type,
+			})
+			continue
+		}
+
+		field_diff := &FieldDiff{
+			Name:      field_name,
+			OldOffset: old_field.Offset,
+			NewOffset: new_field.Offset,
+			OldType:   old_type,
+			NewType:   layoutTypeName(new_field.GetParser(), new_names),
+		}
+		if field_diff.OldOffset != field_diff.NewOffset {
+			field_diff.Changes = append(field_diff.Changes, DIFF_MOVED)
+		}
+		if field_diff.OldType != field_diff.NewType {
+			field_diff.Changes = append(field_diff.Changes, DIFF_RETYPED)
+		}
+		if len(field_diff.Changes) > 0 {
+			result.Fields = append(result.Fields, field_diff)
+		}
+	}
+
+	for _, field_name := range new_def.fields {
+		new_field := new_def.Fields[field_name]
+		if new_field == nil || old_def.Fields[field_name] != nil {
+			continue
+		}
+		result.Fields = append(result.Fields, &FieldDiff{
+			Name:      field_name,
+			Changes:   []string{DIFF_ADDED},
+			NewOffset: new_field.Offset,
+			NewType:   layoutTypeName(new_field.GetParser(), new_names),
+		})
+	}
+
+	if result.OldSize == result.NewSize && len(result.Fields) == 0 {
+		return nil
+	}
+	return result
+}
+
+func (self *ProfileDiff) String() string {
+	result := ""
+	for _, struct_diff := range self.Structs {
+		switch struct_diff.Change {
+		case DIFF_ADDED:
+			result += fmt.Sprintf("%s: added (%d bytes)\n",
+				struct_diff.Name, struct_diff.NewSize)
+			continue
+		case DIFF_REMOVED:
+			result += fmt.Sprintf("%s: removed (%d bytes)\n",
+				struct_diff.Name, struct_diff.OldSize)
+			continue
+		}
+
+		if struct_diff.OldSize != struct_diff.NewSize {
+			result += fmt.Sprintf("%s: size %d -> %d\n", struct_diff.Name,
+				struct_diff.OldSize, struct_diff.NewSize)
+		} else {
+			result += fmt.Sprintf("%s:\n", struct_diff.Name)
+		}
+
+		for _, field_diff := range struct_diff.Fields {
+			changes := []string{}
+			for _, change := range field_diff.Changes {
+				switch change {
+				case DIFF_ADDED:
+					changes = append(changes, fmt.Sprintf("added at %#x (%s)",
+						field_diff.NewOffset, field_diff.NewType))
+				case DIFF_REMOVED:
+					changes = append(changes, fmt.Sprintf("removed from %#x (%s)",
+						field_diff.OldOffset, field_diff.OldType))
+				case DIFF_MOVED:
+					changes = append(changes, fmt.Sprintf("moved %#x -> %#x",
+						field_diff.OldOffset, field_diff.NewOffset))
+				case DIFF_RETYPED:
+					changes = append(changes, fmt.Sprintf("retyped %s -> %s",
+						field_diff.OldType, field_diff.NewType))
+				}
+			}
+			result += fmt.Sprintf("  %s: %s\n", field_diff.Name,
+				strings.Join(changes, ", "))
+		}
+	}
+	return result
+}
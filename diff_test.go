@@ -0,0 +1,73 @@
+package binparsergen
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	"gotest.tools/assert"
+)
+
+func TestDiff(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/vtypes.json")
+	assert.NilError(t, err)
+
+	// The new version grows _GUID: Data2 moves, Data3 is dropped,
+	// Data1 becomes signed and Data5 is added.
+	vtypes := ordereddict.NewDict()
+	assert.NilError(t, json.Unmarshal(data, vtypes))
+	value, _ := vtypes.Get("_GUID")
+	guid := value.([]interface{})
+	guid[0] = 24
+	fields := guid[1].(*ordereddict.Dict)
+	value, _ = fields.Get("Data1")
+	value.([]interface{})[1] = []interface{}{"long", map[string]interface{}{}}
+	value, _ = fields.Get("Data2")
+	value.([]interface{})[0] = 16
+	fields.Delete("Data3")
+	fields.Set("Data5", []interface{}{20, []interface{}{"unsigned long"}})
+
+	// _HEADER.Version becomes big endian.
+	value, _ = vtypes.Get("_HEADER")
+	value, _ = value.([]interface{})[1].(*ordereddict.Dict).Get("Version")
+	value.([]interface{})[1] = []interface{}{"unsigned be short", map[string]interface{}{}}
+
+	data, err = json.Marshal(vtypes)
+	assert.NilError(t, err)
+	filename := filepath.Join(t.TempDir(), "new.json")
+	assert.NilError(t, ioutil.WriteFile(filename, data, 0644))
+
+	old_profile, err := ConvertSpec(&ConversionSpec{
+		Filename: "testdata/vtypes.json",
+		Structs:  []string{"_GUID", "_HEADER"},
+	})
+	assert.NilError(t, err)
+
+	new_profile, err := ConvertSpec(&ConversionSpec{
+		Filename: filename,
+		Structs:  []string{"_GUID", "_HEADER"},
+	})
+	assert.NilError(t, err)
+
+	diff, err := DiffProfiles(old_profile, new_profile, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, diff.String(), `_GUID: size 16 -> 24
+  Data1: retyped unsigned long -> long
+  Data2: moved 0x4 -> 0x10
+  Data3: removed from 0x6 (unsigned short)
+  Data5: added at 0x14 (unsigned long)
+_HEADER:
+  Version: retyped unsigned short -> unsigned be short
+`)
+
+	delete(new_profile, "_GUID")
+	diff, err = DiffProfiles(old_profile, new_profile, []string{"_G*"})
+	assert.NilError(t, err)
+	assert.Equal(t, diff.String(), "_GUID: removed (16 bytes)\n")
+
+	diff, err = DiffProfiles(old_profile, old_profile, nil)
+	assert.NilError(t, err)
+	assert.Assert(t, diff.Empty())
+}
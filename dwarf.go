@@ -0,0 +1,298 @@
+package binparsergen
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+/* Linux binaries, kernels and their separate debug files describe
+   their types in DWARF. We read the .debug_info of an ELF file and
+   convert all named structs, unions and classes into vtypes.
+
+   Structs without a name are named after their typedef or, when used
+   as a field, after the parent struct and field
+   (e.g. task_struct_thread_info). Anonymous struct or union members
+   are flattened into their parent. Arrays of char become Strings.
+*/
+
+type dwarfConverter struct {
+	data       *dwarf.Data
+	big_endian bool
+
+	// Names assigned to each struct type.
+	names map[*dwarf.StructType]string
+
+	// Structs still to be converted, in order.
+	pending []*dwarf.StructType
+}
+
+// Convert the DWARF information in an ELF file into vtype
+// definitions.
+func ConvertDWARF(data []byte, spec *ConversionSpec) (*ordereddict.Dict, *ProfileInfo, error) {
+	elf_file, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dwarf_data, err := elf_file.DWARF()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := &ProfileInfo{
+		PointerSize:      8,
+		PointerBigEndian: elf_file.ByteOrder == binary.BigEndian,
+	}
+	if elf_file.Class == elf.ELFCLASS32 {
+		info.PointerSize = 4
+	}
+
+	self := &dwarfConverter{
+		data:       dwarf_data,
+		big_endian: elf_file.ByteOrder == binary.BigEndian,
+		names:      make(map[*dwarf.StructType]string),
+	}
+
+	err = self.collect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := ordereddict.NewDict()
+	for len(self.pending) > 0 {
+		struct_type := self.pending[0]
+		self.pending = self.pending[1:]
+
+		name := self.names[struct_type]
+		fields := ordereddict.NewDict()
+		err := self.convertFields(name, struct_type, 0, fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		result.Set(name, vtypeStruct(struct_type.ByteSize, fields))
+	}
+
+	return result, info, nil
+}
+
+// Find all the named struct types. When the same struct is defined
+// in several compilation units we keep the first one.
+func (self *dwarfConverter) collect() error {
+	seen := make(map[string]bool)
+	reader := self.data.Reader()
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return nil
+		}
+
+		switch entry.Tag {
+		case dwarf.TagStructType, dwarf.TagUnionType, dwarf.TagClassType,
+			dwarf.TagTypedef:
+		default:
+			continue
+		}
+
+		entry_type, err := self.data.Type(entry.Offset)
+		if err != nil {
+			return err
+		}
+
+		name := ""
+		var struct_type *dwarf.StructType
+		switch t := entry_type.(type) {
+		case *dwarf.StructType:
+			name, struct_type = t.StructName, t
+
+		case *dwarf.TypedefType:
+			// typedef struct {...} name;
+			target, ok := t.Type.(*dwarf.StructType)
+			if !ok || target.StructName != "" {
+				continue
+			}
+			name, struct_type = t.Name, target
+		}
+
+		if name == "" || struct_type.Incomplete || seen[name] {
+			continue
+		}
+		if _, pres := self.names[struct_type]; pres {
+			continue
+		}
+
+		seen[name] = true
+		self.names[struct_type] = name
+		self.pending = append(self.pending, struct_type)
+	}
+}
+
+func (self *dwarfConverter) convertFields(name string,
+	struct_type *dwarf.StructType, base int64, fields *ordereddict.Dict) error {
+	for _, field := range struct_type.Field {
+		offset := base + field.ByteOffset
+
+		// Anonymous struct or union members are flattened.
+		if field.Name == "" {
+			member, ok := unqualifiedType(field.Type).(*dwarf.StructType)
+			if ok {
+				err := self.convertFields(name, member, offset, fields)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if field.BitSize > 0 {
+			definition, err := self.convertBitField(field)
+			if err != nil {
+				return fmt.Errorf("%v.%v: %w", name, field.Name, err)
+			}
+			if definition != nil {
+				fields.Set(field.Name, definition)
+			}
+			continue
+		}
+
+		// Name anonymous structs after the field they define.
+		member, ok := unqualifiedType(field.Type).(*dwarf.StructType)
+		if ok && member.StructName == "" && !member.Incomplete {
+			if _, pres := self.names[member]; !pres {
+				self.names[member] = name + "_" + field.Name
+				self.pending = append(self.pending, member)
+			}
+		}
+
+		definition, err := self.convertType(field.Type)
+		if err != nil {
+			return fmt.Errorf("%v.%v: %w", name, field.Name, err)
+		}
+
+		// Unsupported types (e.g. floats) are skipped.
+		if definition == nil {
+			continue
+		}
+		fields.Set(field.Name, vtypeField(offset, definition))
+	}
+
+	return nil
+}
+
+func (self *dwarfConverter) convertBitField(field *dwarf.StructField) ([]interface{}, error) {
+	target, err := self.convertType(field.Type)
+	if err != nil || target == nil {
+		return nil, err
+	}
+
+	// Bitfields of enums are read as the enum's base type.
+	target_name := target[0]
+	if target_name == "Enumeration" {
+		target_name = target[1].(map[string]interface{})["target"]
+	}
+
+	// DWARF 4 gives the bit offset from the start of the struct,
+	// while earlier versions count from the most significant bit of
+	// the storage unit.
+	bit := field.DataBitOffset
+	if field.ByteSize > 0 {
+		bit = field.ByteOffset*8 + field.ByteSize*8 - field.BitOffset - field.BitSize
+	}
+
+	return vtypeBitField(bit, field.BitSize, field.Type.Size(), target_name), nil
+}
+
+func unqualifiedType(dwarf_type dwarf.Type) dwarf.Type {
+	for {
+		switch t := dwarf_type.(type) {
+		case *dwarf.TypedefType:
+			dwarf_type = t.Type
+		case *dwarf.QualType:
+			dwarf_type = t.Type
+		default:
+			return dwarf_type
+		}
+	}
+}
+
+func (self *dwarfConverter) convertType(dwarf_type dwarf.Type) ([]interface{}, error) {
+	if dwarf_type == nil {
+		return nil, nil
+	}
+
+	switch t := unqualifiedType(dwarf_type).(type) {
+	case *dwarf.IntType, *dwarf.CharType:
+		return vtypeType(vtypeIntegerName(
+			t.Size(), true, self.big_endian), nil), nil
+
+	case *dwarf.UintType, *dwarf.UcharType, *dwarf.BoolType:
+		return vtypeType(vtypeIntegerName(
+			t.Size(), false, self.big_endian), nil), nil
+
+	case *dwarf.EnumType:
+		choices := make(map[string]interface{})
+		signed := false
+		for _, value := range t.Val {
+			choices[fmt.Sprintf("%d", value.Val)] = value.Name
+			if value.Val < 0 {
+				signed = true
+			}
+		}
+		return vtypeType("Enumeration", map[string]interface{}{
+			"target":  vtypeIntegerName(t.ByteSize, signed, self.big_endian),
+			"choices": choices,
+		}), nil
+
+	case *dwarf.StructType:
+		name, pres := self.names[t]
+		if !pres {
+			name = t.StructName
+		}
+		if name == "" {
+			return nil, nil
+		}
+		return vtypeType(name, nil), nil
+
+	case *dwarf.PtrType:
+		target, err := self.convertType(t.Type)
+		if err != nil {
+			return nil, err
+		}
+		if target == nil {
+			target = vtypeType("Void", nil)
+		}
+		return vtypeType("Pointer", vtypeTarget(
+			make(map[string]interface{}), target)), nil
+
+	case *dwarf.ArrayType:
+		// Flexible array members have no elements.
+		count := t.Count
+		if count < 0 {
+			count = 0
+		}
+
+		if _, ok := unqualifiedType(t.Type).(*dwarf.CharType); ok {
+			return vtypeType("String", map[string]interface{}{
+				"length": count,
+			}), nil
+		}
+
+		target, err := self.convertType(t.Type)
+		if err != nil || target == nil {
+			return nil, err
+		}
+		return vtypeType("Array", vtypeTarget(map[string]interface{}{
+			"count": count,
+		}, target)), nil
+	}
+
+	// Floats, functions and void have no vtype equivalent.
+	return nil, nil
+}
@@ -0,0 +1,49 @@
+package binparsergen
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestConvertDWARF(t *testing.T) {
+	spec := &ConversionSpec{
+		Profile:  "TestProfile",
+		Filename: "testdata/dwarf.o",
+		Format:   FORMAT_DWARF,
+		Structs:  []string{"list_head", "task", "task_stats", "version_t"},
+	}
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+
+	// Pointer size comes from the ELF class.
+	definitions, err := LoadDefinitions(spec)
+	assert.NilError(t, err)
+	assert.Equal(t, definitions.PointerSize, 8)
+
+	task := profile["task"]
+	assert.Equal(t, task.Size, uint32(104))
+	assert.DeepEqual(t, task.fields, []string{
+		"pid", "state", "flags", "prio", "tasks", "comm", "version",
+		"value", "bytes", "stats", "parent", "handler", "payload"})
+
+	assert.Equal(t, task.Fields["state"].Enumeration.Target, "long")
+	assert.Equal(t, task.Fields["state"].Enumeration.Choices[-1], "STATE_DEAD")
+	assert.Equal(t, task.Fields["prio"].Offset, int64(8))
+	assert.Equal(t, task.Fields["prio"].BitField.StartBit, uint64(3))
+	assert.Equal(t, task.Fields["prio"].BitField.EndBit, uint64(10))
+	assert.Equal(t, task.Fields["tasks"].StructParser.Target, "List_head")
+	assert.Equal(t, task.Fields["comm"].StringParser.Length, uint64(16))
+
+	// Typedefs and fields name anonymous structs.
+	assert.Equal(t, task.Fields["version"].StructParser.Target, "Version_t")
+	assert.Equal(t, task.Fields["stats"].StructParser.Target, "Task_stats")
+	assert.Equal(t, profile["task_stats"].Size, uint32(16))
+
+	// Members of the anonymous union are flattened.
+	assert.Equal(t, task.Fields["bytes"].Offset, int64(56))
+	assert.Assert(t, task.Fields["load"] == nil)
+	assert.Equal(t, task.Fields["parent"].Pointer.Target.StructParser.Target, "Task")
+	assert.Equal(t, task.Fields["handler"].Pointer.pointsToStruct(), false)
+}
@@ -6,18 +6,13 @@ type Enumeration struct {
 	BaseParser
 	Choices map[int]string `json:"choices,omitempty"`
 	Target  string         `json:"target,omitempty"`
+
+	// Rekall profiles refer to enums in $ENUMS by name.
+	EnumName string `json:"enum_name,omitempty"`
 }
 
 func (self Enumeration) getParser() Parser {
-	switch self.Target {
-	case "unsigned long":
-		return &Uint32Parser{}
-	case "unsigned short":
-		return &Uint16Parser{}
-	case "unsigned char":
-		return &Uint8Parser{}
-	}
-	return &Uint64Parser{}
+	return IntegerParser(self.Target)
 }
 
 func (self *Enumeration) Prototype() string {
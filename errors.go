@@ -0,0 +1,190 @@
+package binparsergen
+
+import "fmt"
+
+/* The regular accessors never fail - if the data can not be read they
+   return a zero value. This makes it impossible to distinguish a
+   truncated file from a real zero. When the spec sets
+   GenerateErrorAccessors, each field also gets an error returning
+   variant:
+
+   func (self *GUID) Data1E() (uint32, error)
+
+   Which fails with a *FieldError if the field's data can not be read
+   completely, or wrapping ErrMissingField if the field is not in this
+   version of the profile. Dynamic strings and arrays also fail if
+   their length or count can not be read.
+*/
+
+func GenerateErrorPrototypes() string {
+	return `
+var ErrMissingField = fmt.Errorf("Field is not in this version of the profile")
+
+// A FieldError describes a field that could not be read.
+type FieldError struct {
+    Struct string
+    Field  string
+    Offset int64
+    Err    error
+}
+
+func (self *FieldError) Error() string {
+    return fmt.Sprintf("%s.%s at %#x: %v", self.Struct, self.Field, self.Offset, self.Err)
+}
+
+func (self *FieldError) Unwrap() error {
+    return self.Err
+}
+
+// Ensure that size bytes may be read at offset.
+func CheckField(reader io.ReaderAt, offset int64, size int,
+    struct_name, field_name string) error {
+    if size <= 0 {
+       return nil
+    }
+    if size > 4000000 {
+       size = 4000000
+    }
+    data := make([]byte, size)
+    n, err := reader.ReadAt(data, offset)
+    if n < size {
+       if err == nil || err == io.EOF {
+          err = io.ErrUnexpectedEOF
+       }
+       return &FieldError{Struct: struct_name, Field: field_name,
+                          Offset: offset, Err: err}
+    }
+    return nil
+}
+`
+}
+
+// Returns a Go expression for the number of bytes a field occupies
+// and the fields holding dynamic lengths and counts it uses. These
+// are read with their own E() accessors into local variables of the
+// same name.
+func errorProbeSize(parser Parser) (string, []string) {
+	switch t := parser.(type) {
+	case *BitField:
+		return t.getParser().Size(""), nil
+	case *Enumeration:
+		return t.getParser().Size(""), nil
+	case *Flags:
+		return t.getParser().Size(""), nil
+	case *SignatureParser:
+		return fmt.Sprintf("%d", len(t.Value)), nil
+	case *StringParser:
+		if t.DynamicLength != "" {
+			return fmt.Sprintf("int(%s)", t.DynamicLength), []string{t.DynamicLength}
+		}
+		if t.Length == 0 {
+			return "1", nil
+		}
+		return fmt.Sprintf("%d", t.Length), nil
+	case *UTF16StringParser:
+		if t.DynamicLength != "" {
+			return fmt.Sprintf("int(%s)", t.DynamicLength), []string{t.DynamicLength}
+		}
+		if t.Length == 0 {
+			return "2", nil
+		}
+		return fmt.Sprintf("%d", t.Length), nil
+	case *StructParser:
+		return fmt.Sprintf("(&%s{Profile: self.Profile}).Size()", t.Target), nil
+	case *ArrayParser:
+		element, fields := errorProbeSize(t.Target.GetParser())
+		if element == "" {
+			return "", nil
+		}
+		count := fmt.Sprintf("%d", t.Count)
+		if t.DynamicCount != "" {
+			count = fmt.Sprintf("int(%s)", t.DynamicCount)
+			if !InString(fields, t.DynamicCount) {
+				fields = append([]string{t.DynamicCount}, fields...)
+			}
+		}
+		return fmt.Sprintf("%s * %s", count, element), fields
+	}
+
+	switch size := parser.Size("value"); size {
+	case "1", "2", "4", "8":
+		return size, nil
+	}
+	return "", nil
+}
+
+func GenerateErrorAccessors(name string, profile_name string,
+	definition *StructDefinition) string {
+	result := ""
+	for _, field_name := range definition.fields {
+		field_def := definition.Fields[field_name]
+		if field_def == nil {
+			continue
+		}
+
+		parser := field_def.GetParser()
+		size, dynamic_fields := errorProbeSize(parser)
+		if size == "" {
+			Logger.Printf("%s.%s: Can not compute the size of %s, no %sE() accessor generated",
+				name, field_name, parser.GoType(), field_name)
+			continue
+		}
+
+		// The type returned by the regular accessor.
+		go_type := ""
+		switch t := parser.(type) {
+		case *Enumeration:
+			go_type = "*Enumeration"
+		case *Flags:
+			go_type = "*Flags"
+		case *SignatureParser:
+			go_type = "*Signature"
+		case *StructParser:
+			go_type = "*" + t.Target
+		case *Pointer:
+			go_type = parser.GoType()
+		case *ArrayParser:
+			target := t.Target.GetParser()
+			go_type = "[]" + target.GoTypePointer() + target.GoType()
+		default:
+			go_type = parser.GoType()
+		}
+
+		// Fields missing in some versions can not be read at all.
+		guard := ""
+		if field_def.optional {
+			guard = fmt.Sprintf(`    if self.Profile.Off_%[1]s_%[2]s < 0 {
+        var zero %[3]s
+        return zero, &FieldError{Struct: %[1]q, Field: %[2]q,
+                                 Offset: self.Offset, Err: ErrMissingField}
+    }
+`, name, field_name, go_type)
+		}
+
+		// Dynamic lengths and counts must be readable themselves.
+		dynamic := ""
+		assign := ":="
+		for _, dynamic_field := range dynamic_fields {
+			dynamic += fmt.Sprintf(`    %[1]s, err := self.%[1]sE()
+    if err != nil {
+        var zero %[2]s
+        return zero, err
+    }
+`, dynamic_field, go_type)
+			assign = "="
+		}
+
+		result += fmt.Sprintf(`
+func (self *%[1]s) %[2]sE() (%[3]s, error) {
+%[5]s%[6]s    err %[7]s CheckField(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset, %[4]s, %[1]q, %[2]q)
+    if err != nil {
+        var zero %[3]s
+        return zero, err
+    }
+    return self.%[2]s(), nil
+}
+`, name, field_name, go_type, size, guard, dynamic, assign)
+	}
+
+	return result
+}
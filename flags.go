@@ -11,6 +11,10 @@ type Flags struct {
 	Target  string         `json:"target,omitempty"`
 }
 
+func (self Flags) getParser() Parser {
+	return IntegerParser(self.Target)
+}
+
 func (self *Flags) Prototype() string {
 	return `
 type Flags struct {
@@ -50,21 +54,12 @@ func (self Flags) PrototypeName() string {
 }
 
 func (self Flags) Compile(struct_name string, field_name string) string {
-	parser_func := "ParseUint64"
-	switch self.Target {
-	case "unsigned long":
-		parser_func = "ParseUint32"
-	case "unsigned short":
-		parser_func = "ParseUint16"
-	case "unsigned char":
-		parser_func = "ParseUint8"
-	}
 	result := fmt.Sprintf(`
 func (self *%[1]s) %[2]s() *Flags {
    value := %[3]s(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
    names := make(map[string]bool)
 
-`, struct_name, field_name, parser_func)
+`, struct_name, field_name, self.getParser().PrototypeName())
 	for k, v := range self.Maskmap {
 		result += fmt.Sprintf(`
    if value & %v != 0 {
@@ -96,3 +91,7 @@ func (self Flags) GoType() string {
 func (self Flags) Size(value string) string {
 	return "8"
 }
+
+func (self Flags) Dependencies() []Parser {
+	return []Parser{self.getParser()}
+}
@@ -28,6 +28,17 @@ type FieldDefinition struct {
 	StringParser      *StringParser      `json:"StringParser,omitempty"`
 	SignatureParser   *SignatureParser   `json:"SignatureParser,omitempty"`
 	UTF16StringParser *UTF16StringParser `json:"UTF16StringParser,omitempty"`
+	WinFileTime       *WinFileTime       `json:"WinFileTime,omitempty"`
+	UnixTimeStamp     *UnixTimeStamp     `json:"UnixTimeStamp,omitempty"`
+
+	// The field's name and type in the vtypes, before any renames or
+	// overrides.
+	vtype_name string
+	vtype_type string
+
+	// The field is missing in some versions of the profile, where
+	// its offset is -1.
+	optional bool
 }
 
 // Extract the active parser from the field definition.
@@ -84,6 +95,12 @@ func (self *FieldDefinition) GetParser() Parser {
 	} else if self.UTF16StringParser != nil {
 		result = self.UTF16StringParser
 
+	} else if self.WinFileTime != nil {
+		result = self.WinFileTime
+
+	} else if self.UnixTimeStamp != nil {
+		result = self.UnixTimeStamp
+
 	}
 
 	_, pres := prototypes[result.PrototypeName()]
@@ -106,3 +123,8 @@ type StructDefinition struct {
 
 	fields []string
 }
+
+// The names of the fields in vtype order.
+func (self *StructDefinition) FieldNames() []string {
+	return self.fields
+}
@@ -0,0 +1,229 @@
+package binparsergen
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+/* Volatility 3 uses the Intermediate Symbol Format (ISF) to describe
+   types. For example:
+
+   {
+     "base_types": {
+        "unsigned long": {"kind": "int", "size": 4, "signed": false, "endian": "little"}
+     },
+     "user_types": {
+        "_GUID": {"kind": "struct", "size": 16, "fields": {
+           "Data1": {"offset": 0, "type": {"kind": "base", "name": "unsigned long"}},
+           "Data4": {"offset": 8, "type": {"kind": "array", "count": 8,
+                        "subtype": {"kind": "base", "name": "unsigned char"}}}
+        }}
+     },
+     "enums": {...},
+     "symbols": {...}
+   }
+
+   We convert the ISF user types into the equivalent vtype
+   definitions.
+*/
+
+type isfType struct {
+	Kind        string   `json:"kind"`
+	Name        string   `json:"name"`
+	Count       int64    `json:"count"`
+	Subtype     *isfType `json:"subtype"`
+	Type        *isfType `json:"type"`
+	BitPosition int64    `json:"bit_position"`
+	BitLength   int64    `json:"bit_length"`
+}
+
+type isfField struct {
+	Offset int64    `json:"offset"`
+	Type   *isfType `json:"type"`
+}
+
+type isfUserType struct {
+	Kind   string               `json:"kind"`
+	Size   int64                `json:"size"`
+	Fields map[string]*isfField `json:"fields"`
+}
+
+type isfBaseType struct {
+	Kind   string `json:"kind"`
+	Size   int64  `json:"size"`
+	Signed bool   `json:"signed"`
+	Endian string `json:"endian"`
+}
+
+type isfEnum struct {
+	Base      string           `json:"base"`
+	Size      int64            `json:"size"`
+	Constants map[string]int64 `json:"constants"`
+}
+
+type isfSymbol struct {
+	Address uint64 `json:"address"`
+}
+
+type isfFile struct {
+	BaseTypes map[string]*isfBaseType `json:"base_types"`
+	UserTypes map[string]*isfUserType `json:"user_types"`
+	Enums     map[string]*isfEnum     `json:"enums"`
+	Symbols   map[string]*isfSymbol   `json:"symbols"`
+}
+
+// Convert an ISF file into vtype definitions.
+func ConvertISF(data []byte, spec *ConversionSpec) (*ordereddict.Dict, *ProfileInfo, error) {
+	isf := &isfFile{}
+	err := json.Unmarshal(data, isf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := &ProfileInfo{}
+	pointer, pres := isf.BaseTypes["pointer"]
+	if pres {
+		info.PointerSize = int(pointer.Size)
+		info.PointerBigEndian = pointer.Endian == "big"
+	}
+
+	// Symbols may be used as Constants.
+	info.Constants = make(map[string]uint64)
+	for name, symbol := range isf.Symbols {
+		info.Constants[name] = symbol.Address
+	}
+
+	result := ordereddict.NewDict()
+	for _, type_name := range SortedKeys(isf.UserTypes) {
+		user_type := isf.UserTypes[type_name]
+
+		// Emit fields in offset order.
+		field_names := SortedKeys(user_type.Fields)
+		sort.SliceStable(field_names, func(i, j int) bool {
+			return user_type.Fields[field_names[i]].Offset <
+				user_type.Fields[field_names[j]].Offset
+		})
+
+		fields := ordereddict.NewDict()
+		for _, field_name := range field_names {
+			field := user_type.Fields[field_name]
+			definition, err := isf.convertType(field.Type)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%v.%v: %w", type_name, field_name, err)
+			}
+
+			// Unsupported types (e.g. floats) are skipped.
+			if definition == nil {
+				continue
+			}
+			fields.Set(field_name, vtypeField(field.Offset, definition))
+		}
+
+		result.Set(type_name, vtypeStruct(user_type.Size, fields))
+	}
+
+	return result, info, nil
+}
+
+func (self *isfFile) baseTypeName(name string) (string, error) {
+	base_type, pres := self.BaseTypes[name]
+	if !pres {
+		return "", fmt.Errorf("Unknown base type %v", name)
+	}
+
+	switch base_type.Kind {
+	case "int", "char", "bool":
+		return vtypeIntegerName(base_type.Size,
+			base_type.Signed, base_type.Endian == "big"), nil
+
+	case "void":
+		return "Void", nil
+	}
+
+	// Floats have no vtype equivalent.
+	return "", nil
+}
+
+func (self *isfFile) convertType(isf_type *isfType) ([]interface{}, error) {
+	if isf_type == nil {
+		return nil, fmt.Errorf("Missing type")
+	}
+
+	switch isf_type.Kind {
+	case "base":
+		name, err := self.baseTypeName(isf_type.Name)
+		if err != nil || name == "" {
+			return nil, err
+		}
+		return vtypeType(name, nil), nil
+
+	case "struct", "union", "class":
+		return vtypeType(isf_type.Name, nil), nil
+
+	case "function":
+		return vtypeType("Void", nil), nil
+
+	case "pointer":
+		target, err := self.convertType(isf_type.Subtype)
+		if err != nil {
+			return nil, err
+		}
+		if target == nil {
+			target = vtypeType("Void", nil)
+		}
+		return vtypeType("Pointer", vtypeTarget(
+			make(map[string]interface{}), target)), nil
+
+	case "array":
+		target, err := self.convertType(isf_type.Subtype)
+		if err != nil || target == nil {
+			return nil, err
+		}
+		return vtypeType("Array", vtypeTarget(map[string]interface{}{
+			"count": isf_type.Count,
+		}, target)), nil
+
+	case "enum":
+		enum, pres := self.Enums[isf_type.Name]
+		if !pres {
+			return nil, fmt.Errorf("Unknown enum %v", isf_type.Name)
+		}
+
+		target, err := self.baseTypeName(enum.Base)
+		if err != nil {
+			return nil, err
+		}
+
+		choices := make(map[string]interface{})
+		for name, value := range enum.Constants {
+			choices[fmt.Sprintf("%d", value)] = name
+		}
+		return vtypeType("Enumeration", map[string]interface{}{
+			"target":  target,
+			"choices": choices,
+		}), nil
+
+	case "bitfield":
+		target, err := self.convertType(isf_type.Type)
+		if err != nil || target == nil {
+			return nil, err
+		}
+
+		// Bitfields of enums are read as the enum's base type.
+		target_name := target[0]
+		if target_name == "Enumeration" {
+			target_name = target[1].(map[string]interface{})["target"]
+		}
+
+		return vtypeType("BitField", map[string]interface{}{
+			"start_bit": isf_type.BitPosition,
+			"end_bit":   isf_type.BitPosition + isf_type.BitLength,
+			"target":    target_name,
+		}), nil
+	}
+
+	return nil, fmt.Errorf("Unsupported type kind %v", isf_type.Kind)
+}
@@ -0,0 +1,70 @@
+package binparsergen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestConvertISF(t *testing.T) {
+	spec := &ConversionSpec{
+		Profile:   "TestProfile",
+		Filename:  "testdata/isf.json",
+		Format:    FORMAT_ISF,
+		Structs:   []string{"_LIST_ENTRY", "_OBJECT"},
+		Constants: []string{"PsActiveProcessHead"},
+	}
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+
+	// Pointer size comes from the "pointer" base type.
+	definitions, err := LoadDefinitions(spec)
+	assert.NilError(t, err)
+	assert.Equal(t, definitions.PointerSize, 4)
+	assert.Equal(t, profile["_LIST_ENTRY"].Fields["Blink"].Pointer.PointerSize, 4)
+
+	object := profile["_OBJECT"]
+	assert.Equal(t, object.Size, uint32(40))
+	assert.DeepEqual(t, object.fields, []string{
+		"Type", "Locked", "Magic", "List", "Name", "Callback"})
+
+	assert.Equal(t, object.Fields["Type"].Enumeration.Target, "unsigned short")
+	assert.Equal(t, object.Fields["Type"].Enumeration.Choices[2], "Key")
+	assert.Equal(t, object.Fields["Locked"].BitField.StartBit, uint64(1))
+	assert.Equal(t, object.Fields["Locked"].BitField.EndBit, uint64(3))
+	assert.Equal(t, object.Fields["Magic"].Uint32Parser.BigEndian, true)
+	assert.Assert(t, object.Fields["Weight"] == nil)
+	assert.Equal(t, object.Fields["List"].StructParser.Target, "LIST_ENTRY")
+	assert.Equal(t, object.Fields["List"].Offset, int64(16))
+	assert.Equal(t, object.Fields["Name"].ArrayParser.Count, 8)
+
+	// Symbols are available as constants.
+	assert.Equal(t, spec.constants["PsActiveProcessHead"], uint64(0x806c8000))
+}
+
+func TestConvertISFBigEndianPointers(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/isf.json")
+	assert.NilError(t, err)
+
+	filename := filepath.Join(t.TempDir(), "isf.json")
+	data = bytes.Replace(data,
+		[]byte(`"pointer": {"kind": "int", "size": 4, "signed": false, "endian": "little"}`),
+		[]byte(`"pointer": {"kind": "int", "size": 4, "signed": false, "endian": "big"}`), 1)
+	assert.NilError(t, ioutil.WriteFile(filename, data, 0644))
+
+	spec := &ConversionSpec{
+		Filename: filename,
+		Format:   FORMAT_ISF,
+		Structs:  []string{"_LIST_ENTRY"},
+	}
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+
+	pointer := profile["_LIST_ENTRY"].Fields["Flink"].Pointer
+	assert.Equal(t, pointer.BigEndian, true)
+	assert.Equal(t, pointer.addressParser().PrototypeName(), "ParseUint32BE")
+}
@@ -0,0 +1,168 @@
+package binparsergen
+
+import "fmt"
+
+/* Generated structs may implement json.Marshaler. Fields are emitted
+   in the same order they appear in the vtype definition:
+
+   - Enumerations are emitted as their name.
+   - Flags are emitted as a sorted list of set flag names.
+   - Timestamps are emitted in RFC3339 format.
+   - Arrays of integers are emitted as lists of numbers, including
+     byte arrays which encoding/json would emit as base64.
+   - Nested structs and pointers to structs are emitted as objects up
+     to the spec's JSONDepth. Beyond that depth they are emitted as
+     null so reference cycles terminate.
+
+   Only arrays of structs and integers are emitted.
+*/
+
+const DEFAULT_JSON_DEPTH = 3
+
+func GenerateJSONPrototypes() string {
+	return `
+// A JSONObject builds a JSON object with keys in insertion order.
+type JSONObject struct {
+    buf bytes.Buffer
+}
+
+func (self *JSONObject) Set(key string, value interface{}) {
+    data, err := json.Marshal(value)
+    if err != nil {
+       data = []byte("null")
+    }
+    self.SetRaw(key, data)
+}
+
+func (self *JSONObject) SetRaw(key string, data []byte) {
+    if self.buf.Len() > 0 {
+       self.buf.WriteString(",")
+    }
+    serialized_key, _ := json.Marshal(key)
+    self.buf.Write(serialized_key)
+    self.buf.WriteString(":")
+    self.buf.Write(data)
+}
+
+func (self *JSONObject) Bytes() []byte {
+    return []byte("{" + self.buf.String() + "}")
+}
+
+func FormatJSONTime(value time.Time) string {
+    return value.UTC().Format(time.RFC3339)
+}
+
+func JSONBytes(value []byte) []uint16 {
+    result := make([]uint16, 0, len(value))
+    for _, item := range value {
+        result = append(result, uint16(item))
+    }
+    return result
+}
+`
+}
+
+func GenerateJSON(name string, profile_name string,
+	definition *StructDefinition, depth int) string {
+	result := fmt.Sprintf(`
+func (self *%[1]s) MarshalJSON() ([]byte, error) {
+    return self.marshalJSON(%[2]d), nil
+}
+
+func (self *%[1]s) marshalJSON(depth int) []byte {
+    result := &JSONObject{}
+`, name, depth)
+
+	for _, field_name := range definition.fields {
+		field_def := definition.Fields[field_name]
+		if field_def == nil {
+			continue
+		}
+
+		code := ""
+		switch t := field_def.GetParser().(type) {
+		case *Uint64Parser, *Int64Parser, *Uint32Parser, *Int32Parser,
+			*Uint16Parser, *Int16Parser, *Uint8Parser, *Int8Parser,
+			*BitField, *StringParser, *UTF16StringParser:
+			code = fmt.Sprintf("    result.Set(%q, self.%s())\n",
+				field_name, field_name)
+
+		case *WinFileTime, *UnixTimeStamp:
+			code = fmt.Sprintf(
+				"    result.Set(%q, FormatJSONTime(self.%s()))\n",
+				field_name, field_name)
+
+		case *Enumeration:
+			code = fmt.Sprintf("    result.Set(%q, self.%s().Name)\n",
+				field_name, field_name)
+
+		case *Flags:
+			code = fmt.Sprintf(`    {
+        names := self.%s().Values()
+        sort.Strings(names)
+        result.Set(%q, names)
+    }
+`, field_name, field_name)
+
+		case *SignatureParser:
+			code = fmt.Sprintf("    result.Set(%q, self.%s().value)\n",
+				field_name, field_name)
+
+		case *StructParser:
+			code = fmt.Sprintf(`    if depth > 0 {
+        result.SetRaw(%q, self.%s().marshalJSON(depth - 1))
+    } else {
+        result.SetRaw(%q, []byte("null"))
+    }
+`, field_name, field_name, field_name)
+
+		case *Pointer:
+			// Only pointers to structs are followed.
+			if !t.pointsToStruct() {
+				code = fmt.Sprintf("    result.Set(%q, self.%s())\n",
+					field_name, field_name)
+				break
+			}
+			code = fmt.Sprintf(`    if depth > 0 && %[3]s(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset) != 0 {
+        result.SetRaw(%[2]q, self.%[2]s().marshalJSON(depth - 1))
+    } else {
+        result.SetRaw(%[2]q, []byte("null"))
+    }
+`, name, field_name, t.addressParser().PrototypeName())
+
+		case *ArrayParser:
+			target := t.Target.GetParser()
+			switch target.(type) {
+			case *StructParser:
+				code = fmt.Sprintf(`    if depth > 0 {
+        items := [][]byte{}
+        for _, item := range self.%[1]s() {
+            items = append(items, item.marshalJSON(depth - 1))
+        }
+        result.SetRaw(%[1]q, []byte("[" + string(bytes.Join(items, []byte(","))) + "]"))
+    } else {
+        result.SetRaw(%[1]q, []byte("null"))
+    }
+`, field_name)
+
+			case *Uint8Parser:
+				code = fmt.Sprintf("    result.Set(%q, JSONBytes(self.%s()))\n",
+					field_name, field_name)
+
+			case *Uint64Parser, *Int64Parser, *Uint32Parser, *Int32Parser,
+				*Uint16Parser, *Int16Parser, *Int8Parser:
+				code = fmt.Sprintf("    result.Set(%q, self.%s())\n",
+					field_name, field_name)
+
+			default:
+				Logger.Printf("%s.%s: Arrays of %s can not be emitted as JSON, skipping",
+					name, field_name, typeInfoKind(target))
+			}
+		}
+		result += guardField(name, field_name, field_def, code)
+	}
+
+	result += "    return result.Bytes()\n}\n"
+
+	return result
+}
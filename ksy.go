@@ -0,0 +1,565 @@
+package binparsergen
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Velocidex/ordereddict"
+	yaml "github.com/Velocidex/yaml/v2"
+)
+
+/* Kaitai Struct describes binary formats in .ksy YAML files:
+
+   meta:
+     id: my_format
+     endian: le
+   seq:
+     - id: magic
+       contents: "MYF"
+     - id: num_entries
+       type: u4
+     - id: entries
+       type: entry
+       repeat: expr
+       repeat-expr: num_entries
+   types:
+     entry:
+       seq:
+         - id: kind
+           type: u2
+           enum: kinds
+   enums:
+     kinds:
+       1: file
+       2: directory
+
+   Kaitai is much more expressive than vtypes so we only convert the
+   sequential subset: fixed size integers, sized strings, byte arrays,
+   contents, user types, enums, repeat-expr arrays and instances at a
+   fixed pos. Anything else (expressions, switch types, bit sized
+   integers, conditionals etc.) is reported as an error listing all
+   the unsupported constructs.
+
+   Type and field ids are converted to CamelCase like Kaitai's own Go
+   generator does (e.g. num_entries -> NumEntries). Fields which clash
+   with generated methods get a _ suffix (e.g. size -> Size_).
+*/
+
+var (
+	ksyIntegerRegex = regexp.MustCompile(`^([us])([1248])(le|be)?$`)
+	ksyFloatRegex   = regexp.MustCompile(`^f([48])(le|be)?$`)
+	ksyBitsRegex    = regexp.MustCompile(`^b[0-9]+(le|be)?$`)
+	ksyIdRegex      = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+	ksyTopLevelKeys = []string{"meta", "seq", "types", "enums", "instances",
+		"doc", "doc-ref"}
+	ksyMetaKeys = []string{"id", "title", "application", "file-extension",
+		"xref", "license", "ks-version", "ks-debug", "ks-opaque-types",
+		"encoding", "endian", "tags", "doc", "doc-ref"}
+	ksyAttributeKeys = []string{"id", "type", "size", "contents", "encoding",
+		"enum", "repeat", "repeat-expr", "doc", "doc-ref", "-orig-id"}
+
+	// Names of the generated struct members which fields may not
+	// use.
+	ksyReservedNames = []string{"Size", "Offset", "Reader", "Profile",
+		"DebugString", "Decode", "DecodeWithDepth", "MarshalJSON", "ToDict"}
+)
+
+type ksyType struct {
+	Name     string
+	Body     yaml.MapSlice
+	Parent   *ksyType
+	Endian   string
+	Encoding string
+}
+
+type ksyConverter struct {
+	types      map[string]*ksyType
+	type_order []string
+
+	// The converted structs and their sizes. Variable sized structs
+	// end with a dynamic array.
+	structs    map[string]*ordereddict.Dict
+	sizes      map[string]int64
+	variable   map[string]bool
+	converting map[string]bool
+
+	unsupported []string
+}
+
+func ksyGet(body yaml.MapSlice, key string) (interface{}, bool) {
+	for _, item := range body {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+func ksyString(body yaml.MapSlice, key string) string {
+	value, _ := ksyGet(body, key)
+	result, _ := value.(string)
+	return result
+}
+
+func ksyInt(value interface{}) (int64, bool) {
+	switch t := value.(type) {
+	case int:
+		return int64(t), true
+	case int64:
+		return t, true
+	case uint64:
+		return int64(t), true
+	}
+	return 0, false
+}
+
+// Convert a Kaitai id to CamelCase.
+func ksyName(id string) string {
+	result := ""
+	for _, part := range strings.Split(id, "_") {
+		if part != "" {
+			result += strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return result
+}
+
+// Field names are suffixed with _ if they clash with generated
+// members (e.g. size -> Size_).
+func ksyFieldName(id string) string {
+	result := ksyName(id)
+	if InString(ksyReservedNames, result) {
+		result += "_"
+	}
+	return result
+}
+
+func (self *ksyConverter) unsupportedf(path string, format string, args ...interface{}) {
+	self.unsupported = append(self.unsupported,
+		path+": "+fmt.Sprintf(format, args...))
+}
+
+func (self *ksyConverter) checkKeys(path string, body yaml.MapSlice, allowed []string) {
+	for _, item := range body {
+		key := fmt.Sprintf("%v", item.Key)
+		if !InString(allowed, key) {
+			self.unsupportedf(path, "%v is not supported", key)
+		}
+	}
+}
+
+// Register the type and its nested types. Endianness and encoding
+// are inherited from the enclosing type.
+func (self *ksyConverter) collect(name string, body yaml.MapSlice, parent *ksyType) {
+	ksy_type := &ksyType{Name: name, Body: body, Parent: parent}
+	if parent != nil {
+		ksy_type.Endian = parent.Endian
+		ksy_type.Encoding = parent.Encoding
+	}
+
+	if meta_value, pres := ksyGet(body, "meta"); pres {
+		meta, _ := meta_value.(yaml.MapSlice)
+		self.checkKeys(name+".meta", meta, ksyMetaKeys)
+
+		if endian, pres := ksyGet(meta, "endian"); pres {
+			switch endian {
+			case "le", "be":
+				ksy_type.Endian = endian.(string)
+			default:
+				self.unsupportedf(name+".meta", "switchable endianness is not supported")
+			}
+		}
+		if encoding := ksyString(meta, "encoding"); encoding != "" {
+			ksy_type.Encoding = encoding
+		}
+	}
+
+	if _, pres := self.types[name]; pres {
+		self.unsupportedf(name, "duplicate type name")
+		return
+	}
+	self.types[name] = ksy_type
+	self.type_order = append(self.type_order, name)
+
+	types, _ := ksyGet(body, "types")
+	nested, _ := types.(yaml.MapSlice)
+	for _, item := range nested {
+		body, _ := item.Value.(yaml.MapSlice)
+		self.collect(ksyName(fmt.Sprintf("%v", item.Key)), body, ksy_type)
+	}
+}
+
+// Find an enum by name in the type's scope. Paths like a::b are
+// looked up by their last component.
+func (self *ksyConverter) findEnum(ksy_type *ksyType, name string) (yaml.MapSlice, bool) {
+	components := strings.Split(name, "::")
+	name = components[len(components)-1]
+
+	for scope := ksy_type; scope != nil; scope = scope.Parent {
+		enums, _ := ksyGet(scope.Body, "enums")
+		enums_body, _ := enums.(yaml.MapSlice)
+		if enum, pres := ksyGet(enums_body, name); pres {
+			result, ok := enum.(yaml.MapSlice)
+			return result, ok
+		}
+	}
+
+	// Fall back to any type's enums.
+	for _, type_name := range self.type_order {
+		enums, _ := ksyGet(self.types[type_name].Body, "enums")
+		enums_body, _ := enums.(yaml.MapSlice)
+		if enum, pres := ksyGet(enums_body, name); pres {
+			result, ok := enum.(yaml.MapSlice)
+			return result, ok
+		}
+	}
+	return nil, false
+}
+
+// Convert a user type returning its size.
+func (self *ksyConverter) convertStruct(name string) (int64, bool) {
+	if size, pres := self.sizes[name]; pres {
+		return size, true
+	}
+
+	ksy_type := self.types[name]
+	if self.converting[name] {
+		self.unsupportedf(name, "recursive types are not supported")
+		return 0, false
+	}
+	self.converting[name] = true
+	defer delete(self.converting, name)
+
+	self.checkKeys(name, ksy_type.Body, ksyTopLevelKeys)
+
+	fields := ordereddict.NewDict()
+	offset := int64(0)
+	variable := ""
+
+	seq_value, _ := ksyGet(ksy_type.Body, "seq")
+	seq, _ := seq_value.([]interface{})
+	for i, item := range seq {
+		attribute, _ := item.(yaml.MapSlice)
+		id := ksyString(attribute, "id")
+		path := fmt.Sprintf("%v.seq[%d] (%v)", name, i, id)
+		if !ksyIdRegex.MatchString(id) {
+			self.unsupportedf(path, "attributes must have a valid id")
+			continue
+		}
+		self.checkKeys(path, attribute, ksyAttributeKeys)
+
+		if variable != "" {
+			self.unsupportedf(path, "follows the variable sized field %v", variable)
+			continue
+		}
+
+		definition, size := self.convertAttribute(ksy_type, path, attribute, fields)
+		if definition != nil {
+			fields.Set(ksyFieldName(id), vtypeField(offset, definition))
+		}
+		if size < 0 {
+			variable = id
+			continue
+		}
+		offset += size
+	}
+
+	instances_value, _ := ksyGet(ksy_type.Body, "instances")
+	instances, _ := instances_value.(yaml.MapSlice)
+	for _, item := range instances {
+		id := fmt.Sprintf("%v", item.Key)
+		path := fmt.Sprintf("%v.instances.%v", name, id)
+		attribute, _ := item.Value.(yaml.MapSlice)
+		self.checkKeys(path, attribute, append([]string{"pos"}, ksyAttributeKeys[1:]...))
+
+		pos_value, pres := ksyGet(attribute, "pos")
+		pos, ok := ksyInt(pos_value)
+		if !pres || !ok {
+			self.unsupportedf(path, "only instances with a constant pos are supported")
+			continue
+		}
+
+		definition, _ := self.convertAttribute(ksy_type, path, attribute, fields)
+		if definition != nil {
+			fields.Set(ksyFieldName(id), vtypeField(pos, definition))
+		}
+	}
+
+	self.structs[name] = fields
+	self.sizes[name] = offset
+	self.variable[name] = variable != ""
+	return offset, true
+}
+
+// Convert an attribute to a vtype definition and its size in bytes
+// (-1 if the size is not fixed).
+func (self *ksyConverter) convertAttribute(ksy_type *ksyType, path string,
+	attribute yaml.MapSlice, fields *ordereddict.Dict) ([]interface{}, int64) {
+
+	if contents, pres := ksyGet(attribute, "contents"); pres {
+		value, ok := self.contents(contents)
+		if !ok {
+			self.unsupportedf(path, "invalid contents")
+			return nil, 0
+		}
+
+		// Signatures are stored as json strings.
+		if utf8.ValidString(value) {
+			return vtypeType("Signature", map[string]interface{}{
+				"value": value,
+			}), int64(len(value))
+		}
+		return vtypeType("Array", vtypeTarget(map[string]interface{}{
+			"count": len(value),
+		}, vtypeType("unsigned char", nil))), int64(len(value))
+	}
+
+	size_value, has_size := ksyGet(attribute, "size")
+	size, fixed_size := ksyInt(size_value)
+	if has_size && !fixed_size {
+		self.unsupportedf(path, "size expressions are not supported")
+		return nil, -1
+	}
+
+	type_value, has_type := ksyGet(attribute, "type")
+	type_name, ok := type_value.(string)
+	if has_type && !ok {
+		self.unsupportedf(path, "switch types are not supported")
+		return nil, -1
+	}
+
+	var definition []interface{}
+	element_size := size
+	encoding := ksyString(attribute, "encoding")
+	if encoding == "" {
+		encoding = ksy_type.Encoding
+	}
+
+	switch {
+	case type_name == "":
+		if !has_size {
+			self.unsupportedf(path, "attributes need a type or a size")
+			return nil, -1
+		}
+		definition = vtypeType("Array", vtypeTarget(map[string]interface{}{
+			"count": size,
+		}, vtypeType("unsigned char", nil)))
+
+	case ksyIntegerRegex.MatchString(type_name):
+		match := ksyIntegerRegex.FindStringSubmatch(type_name)
+		element_size, _ = strconv.ParseInt(match[2], 10, 64)
+		endian := match[3]
+		if endian == "" {
+			endian = ksy_type.Endian
+		}
+		if endian == "" && element_size > 1 {
+			self.unsupportedf(path, "%v has no endianness", type_name)
+			return nil, element_size
+		}
+		definition = vtypeType(vtypeIntegerName(
+			element_size, match[1] == "s", endian == "be"), nil)
+
+	case ksyFloatRegex.MatchString(type_name):
+		// Floats have no vtype equivalent but have a known size.
+		match := ksyFloatRegex.FindStringSubmatch(type_name)
+		element_size, _ = strconv.ParseInt(match[1], 10, 64)
+
+	case ksyBitsRegex.MatchString(type_name):
+		self.unsupportedf(path, "bit sized integers (%v) are not supported", type_name)
+		return nil, -1
+
+	case type_name == "str" || type_name == "strz":
+		if !has_size {
+			if type_name == "str" {
+				self.unsupportedf(path, "strings need a size")
+			}
+			definition = vtypeType("String", nil)
+			element_size = -1
+			break
+		}
+
+		switch strings.ToUpper(encoding) {
+		case "UTF-16LE", "UTF-16":
+			definition = vtypeType("UnicodeString", map[string]interface{}{
+				"length": size,
+			})
+		case "UTF-16BE", "UTF-32LE", "UTF-32BE":
+			self.unsupportedf(path, "%v strings are not supported", encoding)
+			return nil, size
+		default:
+			definition = vtypeType("String", map[string]interface{}{
+				"length": size,
+			})
+		}
+
+	case strings.Contains(type_name, "("):
+		self.unsupportedf(path, "parametrized types are not supported")
+		return nil, -1
+
+	default:
+		components := strings.Split(type_name, "::")
+		struct_name := ksyName(components[len(components)-1])
+		if _, pres := self.types[struct_name]; !pres {
+			self.unsupportedf(path, "unknown type %v", type_name)
+			return nil, -1
+		}
+
+		struct_size, ok := self.convertStruct(struct_name)
+		if !ok {
+			return nil, -1
+		}
+		if !has_size {
+			element_size = struct_size
+			if self.variable[struct_name] {
+				element_size = -1
+			}
+		}
+		definition = vtypeType(struct_name, nil)
+	}
+
+	// Arrays can not hold enumerations so repeated enums remain
+	// plain integers.
+	if enum_name := ksyString(attribute, "enum"); enum_name != "" &&
+		ksyString(attribute, "repeat") == "" {
+		definition = self.convertEnum(ksy_type, path, enum_name, definition)
+	}
+
+	switch repeat := ksyString(attribute, "repeat"); repeat {
+	case "":
+		return definition, element_size
+
+	case "expr":
+		repeat_expr, _ := ksyGet(attribute, "repeat-expr")
+		if count, ok := ksyInt(repeat_expr); ok {
+			if element_size < 0 {
+				element_size, count = 1, -1
+			}
+			if definition == nil {
+				return nil, count * element_size
+			}
+			return vtypeType("Array", vtypeTarget(map[string]interface{}{
+				"count": count,
+			}, definition)), count * element_size
+		}
+
+		// A count from a previous field.
+		count_field, _ := repeat_expr.(string)
+		if definition == nil || !ksyIdRegex.MatchString(count_field) ||
+			!InString(fields.Keys(), ksyFieldName(count_field)) {
+			self.unsupportedf(path, "repeat-expr must be a number or a previous field")
+			return nil, -1
+		}
+		return vtypeType("Array", vtypeTarget(map[string]interface{}{
+			"dynamic_count": ksyFieldName(count_field),
+		}, definition)), -1
+
+	default:
+		self.unsupportedf(path, "repeat: %v is not supported", repeat)
+		return nil, -1
+	}
+}
+
+func (self *ksyConverter) contents(value interface{}) (string, bool) {
+	switch t := value.(type) {
+	case string:
+		return t, true
+
+	case []interface{}:
+		result := ""
+		for _, item := range t {
+			if str, ok := item.(string); ok {
+				result += str
+				continue
+			}
+			value, ok := ksyInt(item)
+			if !ok || value < 0 || value > 255 {
+				return "", false
+			}
+			result += string([]byte{byte(value)})
+		}
+		return result, true
+	}
+	return "", false
+}
+
+func (self *ksyConverter) convertEnum(ksy_type *ksyType, path string,
+	enum_name string, definition []interface{}) []interface{} {
+	if definition == nil || newPrimitiveParser(
+		definition[0].(string), BaseParser{}) == nil {
+		self.unsupportedf(path, "enums must be integers")
+		return definition
+	}
+
+	enum, pres := self.findEnum(ksy_type, enum_name)
+	if !pres {
+		self.unsupportedf(path, "unknown enum %v", enum_name)
+		return definition
+	}
+
+	choices := make(map[string]interface{})
+	for _, item := range enum {
+		value, ok := ksyInt(item.Key)
+		if !ok {
+			self.unsupportedf(path, "invalid enum value %v", item.Key)
+			continue
+		}
+
+		// Values may be a name or a map with an id.
+		name, ok := item.Value.(string)
+		if !ok {
+			body, _ := item.Value.(yaml.MapSlice)
+			name = ksyString(body, "id")
+		}
+		choices[fmt.Sprintf("%d", value)] = name
+	}
+
+	return vtypeType("Enumeration", map[string]interface{}{
+		"target":  definition[0],
+		"choices": choices,
+	})
+}
+
+// Convert a Kaitai Struct .ksy file into vtype definitions.
+func ConvertKSY(data []byte, spec *ConversionSpec) (*ordereddict.Dict, *ProfileInfo, error) {
+	root := yaml.MapSlice{}
+	err := yaml.Unmarshal(data, &root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta_value, _ := ksyGet(root, "meta")
+	meta, _ := meta_value.(yaml.MapSlice)
+	id := ksyString(meta, "id")
+	if id == "" {
+		return nil, nil, errors.New("Kaitai file has no meta.id")
+	}
+
+	self := &ksyConverter{
+		types:      make(map[string]*ksyType),
+		structs:    make(map[string]*ordereddict.Dict),
+		sizes:      make(map[string]int64),
+		variable:   make(map[string]bool),
+		converting: make(map[string]bool),
+	}
+
+	self.collect(ksyName(id), root, nil)
+	for _, name := range self.type_order {
+		self.convertStruct(name)
+	}
+
+	if len(self.unsupported) > 0 {
+		return nil, nil, fmt.Errorf("Unsupported Kaitai constructs:\n  %v",
+			strings.Join(self.unsupported, "\n  "))
+	}
+
+	result := ordereddict.NewDict()
+	for _, name := range self.type_order {
+		result.Set(name, vtypeStruct(self.sizes[name], self.structs[name]))
+	}
+	return result, &ProfileInfo{}, nil
+}
@@ -0,0 +1,72 @@
+package binparsergen
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestConvertKSY(t *testing.T) {
+	spec := &ConversionSpec{
+		Profile:  "TestProfile",
+		Filename: "testdata/archive.ksy",
+		Format:   FORMAT_KSY,
+		Structs:  []string{"Archive", "Header", "Entry"},
+	}
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+
+	archive := profile["Archive"]
+	assert.Equal(t, archive.Size, uint32(44))
+	assert.DeepEqual(t, archive.fields, []string{
+		"Magic", "Version", "Flags", "Header", "Name", "Label", "Reserved",
+		"Kinds", "NumEntries", "Entries", "Trailer"})
+
+	assert.Equal(t, archive.Fields["Magic"].SignatureParser.Value, "ARC\x00")
+	assert.Equal(t, archive.Fields["Flags"].Uint16Parser.BigEndian, true)
+	assert.Equal(t, archive.Fields["Header"].StructParser.Target, "Header")
+	assert.Equal(t, archive.Fields["Name"].Offset, int64(14))
+	assert.Equal(t, archive.Fields["Label"].UTF16StringParser.Length, uint64(8))
+	assert.Equal(t, archive.Fields["Reserved"].ArrayParser.Count, 4)
+	assert.Equal(t, archive.Fields["Kinds"].Offset, int64(38))
+	assert.Equal(t, archive.Fields["Kinds"].ArrayParser.Count, 2)
+	assert.Assert(t, archive.Fields["Kinds"].ArrayParser.Target.Uint8Parser != nil)
+	assert.Equal(t, archive.Fields["Entries"].ArrayParser.DynamicCount, "NumEntries")
+	assert.Equal(t, archive.Fields["Trailer"].Offset, int64(0x100))
+
+	// Nested types may override the endianness.
+	header := profile["Header"]
+	assert.Equal(t, header.Size, uint32(6))
+	assert.Equal(t, header.Fields["Created"].Uint32Parser.BigEndian, true)
+	assert.Equal(t, header.Fields["OwnerId"].Int16Parser.BigEndian, true)
+	assert.Equal(t, profile["Entry"].Size, uint32(5))
+	assert.DeepEqual(t, profile["Entry"].fields, []string{"Kind", "Size_"})
+}
+
+func TestConvertKSYUnsupported(t *testing.T) {
+	_, _, err := ConvertKSY([]byte(`
+meta:
+  id: bad
+  imports: [other]
+seq:
+  - id: flag
+    type: b1
+  - id: body
+    size: len_body
+  - id: tail
+    type: u1
+    if: flag == 1
+types:
+  thing:
+    params:
+      - id: size
+`), &ConversionSpec{})
+	assert.Error(t, err, `Unsupported Kaitai constructs:
+  Bad.meta: imports is not supported
+  Bad.seq[0] (flag): bit sized integers (b1) are not supported
+  Bad.seq[1] (body): follows the variable sized field flag
+  Bad.seq[2] (tail): if is not supported
+  Bad.seq[2] (tail): follows the variable sized field flag
+  Thing: params is not supported`)
+}
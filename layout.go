@@ -0,0 +1,326 @@
+package binparsergen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/* The layout of the structs in a profile can be printed as offset
+   tables which show padding holes, fields which overlap (unions) and
+   the bit ranges of bitfields:
+
+   _GUID (16 bytes)
+     Offset  Size  Type             Name   Notes
+     0x0000     4  unsigned long    Data1
+     ...
+
+   The references between structs may be drawn with Graphviz (dot) or
+   as a Mermaid diagram in Markdown.
+*/
+
+// A row in the layout table. Padding rows have no name.
+type layoutRow struct {
+	offset    int64
+	size      int64
+	type_name string
+	name      string
+	notes     []string
+}
+
+// The struct names by their normalized names (as used by
+// StructParser targets).
+func layoutStructNames(profile map[string]*StructDefinition) map[string]string {
+	result := make(map[string]string)
+	for type_name := range profile {
+		result[NormalizeName(type_name)] = type_name
+	}
+	return result
+}
+
+// The name of a field's type, e.g. "unsigned short[2]" or "*_HEADER".
+func layoutTypeName(parser Parser, names map[string]string) string {
+	switch t := parser.(type) {
+	case *StructParser:
+		name, pres := names[t.Target]
+		if !pres {
+			return t.Target
+		}
+		return name
+	case *ArrayParser:
+		element := layoutTypeName(t.Target.GetParser(), names)
+		if t.DynamicCount != "" {
+			return fmt.Sprintf("%s[%s]", element, t.DynamicCount)
+		}
+		return fmt.Sprintf("%s[%d]", element, t.Count)
+	case *Pointer:
+		return "*" + layoutTypeName(t.Target.GetParser(), names)
+	case *Enumeration:
+		return fmt.Sprintf("Enumeration<%s>", typeInfoKind(t.getParser()))
+	case *Flags:
+		return fmt.Sprintf("Flags<%s>", typeInfoKind(t.getParser()))
+	case *BitField:
+		return typeInfoKind(t.getParser())
+	case *StringParser:
+		if t.DynamicLength != "" {
+			return fmt.Sprintf("String[%s]", t.DynamicLength)
+		}
+		return fmt.Sprintf("String[%d]", t.Length)
+	case *UTF16StringParser:
+		if t.DynamicLength != "" {
+			return fmt.Sprintf("UnicodeString[%s]", t.DynamicLength)
+		}
+		return fmt.Sprintf("UnicodeString[%d]", t.Length)
+	case *SignatureParser:
+		return fmt.Sprintf("Signature[%d]", len(t.Value))
+	}
+	return typeInfoKind(parser)
+}
+
+// The number of bytes a field occupies or 0 if it depends on the data.
+func layoutSize(parser Parser, profile map[string]*StructDefinition,
+	names map[string]string) int64 {
+	switch t := parser.(type) {
+	case *StructParser:
+		struct_def, pres := profile[names[t.Target]]
+		if !pres {
+			return 0
+		}
+		return int64(struct_def.Size)
+	case *ArrayParser:
+		if t.DynamicCount != "" {
+			return 0
+		}
+		return int64(t.Count) * layoutSize(t.Target.GetParser(), profile, names)
+	case *Pointer:
+		return layoutSize(t.addressParser(), profile, names)
+	case *BitField:
+		return layoutSize(t.getParser(), profile, names)
+	case *Enumeration:
+		return layoutSize(t.getParser(), profile, names)
+	case *Flags:
+		return layoutSize(t.getParser(), profile, names)
+	case *StringParser:
+		if t.DynamicLength != "" {
+			return 0
+		}
+		return int64(t.Length)
+	case *UTF16StringParser:
+		if t.DynamicLength != "" {
+			return 0
+		}
+		return int64(t.Length)
+	case *SignatureParser:
+		return int64(len(t.Value))
+	}
+
+	switch size := parser.Size(""); size {
+	case "1", "2", "4", "8":
+		return int64(size[0] - '0')
+	}
+	return 0
+}
+
+// Two fields overlap if their bytes do, unless they are bitfields
+// with separate bit ranges.
+func layoutOverlaps(a, b *FieldDefinition, a_size, b_size int64) bool {
+	if a.Offset >= b.Offset+b_size || b.Offset >= a.Offset+a_size {
+		return false
+	}
+	if a.BitField != nil && b.BitField != nil && a.Offset == b.Offset {
+		return a.BitField.StartBit < b.BitField.EndBit &&
+			b.BitField.StartBit < a.BitField.EndBit
+	}
+	return true
+}
+
+// The struct's rows sorted by offset, including padding.
+func structLayout(struct_def *StructDefinition,
+	profile map[string]*StructDefinition, names map[string]string) []*layoutRow {
+	field_names := []string{}
+	for _, field_name := range struct_def.fields {
+		if struct_def.Fields[field_name] != nil {
+			field_names = append(field_names, field_name)
+		}
+	}
+	sort.SliceStable(field_names, func(i, j int) bool {
+		return struct_def.Fields[field_names[i]].Offset <
+			struct_def.Fields[field_names[j]].Offset
+	})
+
+	sizes := make(map[string]int64)
+	for _, field_name := range field_names {
+		sizes[field_name] = layoutSize(
+			struct_def.Fields[field_name].GetParser(), profile, names)
+	}
+
+	result := []*layoutRow{}
+	end := int64(0)
+	for _, field_name := range field_names {
+		field_def := struct_def.Fields[field_name]
+		if field_def.Offset > end {
+			result = append(result, &layoutRow{
+				offset: end, size: field_def.Offset - end})
+		}
+
+		row := &layoutRow{
+			offset:    field_def.Offset,
+			size:      sizes[field_name],
+			type_name: layoutTypeName(field_def.GetParser(), names),
+			name:      field_name,
+		}
+		if field_def.BitField != nil {
+			row.notes = append(row.notes, fmt.Sprintf("bits %d-%d",
+				field_def.BitField.StartBit, field_def.BitField.EndBit-1))
+		}
+
+		overlaps := []string{}
+		for _, other := range field_names {
+			if other != field_name && layoutOverlaps(field_def,
+				struct_def.Fields[other], sizes[field_name], sizes[other]) {
+				overlaps = append(overlaps, other)
+			}
+		}
+		if len(overlaps) > 0 {
+			row.notes = append(row.notes, "overlaps "+strings.Join(overlaps, ", "))
+		}
+		result = append(result, row)
+
+		if field_def.Offset+row.size > end {
+			end = field_def.Offset + row.size
+		}
+	}
+
+	if int64(struct_def.Size) > end {
+		result = append(result, &layoutRow{
+			offset: end, size: int64(struct_def.Size) - end})
+	}
+	return result
+}
+
+func (self *layoutRow) columns() []string {
+	size := fmt.Sprintf("%d", self.size)
+	if self.size == 0 {
+		size = "?"
+	}
+	if self.name == "" {
+		return []string{fmt.Sprintf("%#04x", self.offset), size,
+			"", "[padding]", ""}
+	}
+	return []string{fmt.Sprintf("%#04x", self.offset), size,
+		self.type_name, self.name, strings.Join(self.notes, "; ")}
+}
+
+// The layout of each struct as a text table.
+func LayoutText(profile map[string]*StructDefinition) string {
+	names := layoutStructNames(profile)
+	result := ""
+	for _, type_name := range SortedKeys(profile) {
+		struct_def := profile[type_name]
+		rows := [][]string{{"Offset", "Size", "Type", "Name", "Notes"}}
+		for _, row := range structLayout(struct_def, profile, names) {
+			rows = append(rows, row.columns())
+		}
+
+		widths := make([]int, len(rows[0]))
+		for _, row := range rows {
+			for idx, column := range row {
+				if len(column) > widths[idx] {
+					widths[idx] = len(column)
+				}
+			}
+		}
+
+		result += fmt.Sprintf("%s (%d bytes)\n", type_name, struct_def.Size)
+		for _, row := range rows {
+			line := fmt.Sprintf("  %*s  %*s  %-*s  %-*s  %s", widths[0], row[0],
+				widths[1], row[1], widths[2], row[2], widths[3], row[3], row[4])
+			result += strings.TrimRight(line, " ") + "\n"
+		}
+		result += "\n"
+	}
+	return result
+}
+
+// A reference from a field to a struct.
+type layoutReference struct {
+	from, to, field string
+	pointer         bool
+}
+
+func layoutReferences(profile map[string]*StructDefinition) []layoutReference {
+	names := layoutStructNames(profile)
+	result := []layoutReference{}
+	for _, type_name := range SortedKeys(profile) {
+		struct_def := profile[type_name]
+		for _, field_name := range struct_def.fields {
+			parser, pointer := referencedStruct(struct_def.Fields[field_name])
+			if parser == nil {
+				continue
+			}
+			target, pres := names[parser.Target]
+			if !pres {
+				continue
+			}
+			result = append(result, layoutReference{
+				from: type_name, to: target, field: field_name, pointer: pointer})
+		}
+	}
+	return result
+}
+
+// The references between structs as a Graphviz graph. Pointers are
+// dashed.
+func LayoutDot(profile map[string]*StructDefinition) string {
+	result := "digraph profile {\n    node [shape=box];\n"
+	for _, type_name := range SortedKeys(profile) {
+		result += fmt.Sprintf("    %q [label=\"%s\\n%d bytes\"];\n",
+			type_name, type_name, profile[type_name].Size)
+	}
+	for _, reference := range layoutReferences(profile) {
+		style := ""
+		if reference.pointer {
+			style = ", style=dashed"
+		}
+		result += fmt.Sprintf("    %q -> %q [label=%q%s];\n",
+			reference.from, reference.to, reference.field, style)
+	}
+	return result + "}\n"
+}
+
+// The layout tables and a Mermaid diagram of the references between
+// structs.
+func LayoutMarkdown(profile map[string]*StructDefinition) string {
+	names := layoutStructNames(profile)
+	ids := make(map[string]string)
+	result := "```mermaid\ngraph LR\n"
+	for idx, type_name := range SortedKeys(profile) {
+		ids[type_name] = fmt.Sprintf("S%d", idx)
+		result += fmt.Sprintf("    %s[\"%s\"]\n", ids[type_name], type_name)
+	}
+	for _, reference := range layoutReferences(profile) {
+		arrow := "-->"
+		if reference.pointer {
+			arrow = "-.->"
+		}
+		result += fmt.Sprintf("    %s %s|%s| %s\n", ids[reference.from], arrow,
+			reference.field, ids[reference.to])
+	}
+	result += "```\n"
+
+	escape := strings.NewReplacer("|", "\\|", "*", "\\*", "<", "&lt;", ">", "&gt;")
+	for _, type_name := range SortedKeys(profile) {
+		struct_def := profile[type_name]
+		result += fmt.Sprintf("\n## %s (%d bytes)\n\n", type_name, struct_def.Size)
+		result += "| Offset | Size | Type | Name | Notes |\n"
+		result += "|-------:|-----:|------|------|-------|\n"
+		for _, row := range structLayout(struct_def, profile, names) {
+			columns := row.columns()
+			for idx, column := range columns {
+				columns[idx] = escape.Replace(column)
+			}
+			result += "| " + strings.Join(columns, " | ") + " |\n"
+		}
+	}
+	return result
+}
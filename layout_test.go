@@ -0,0 +1,40 @@
+package binparsergen
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestLayout(t *testing.T) {
+	profile, err := ConvertSpec(&ConversionSpec{
+		Filename: "testdata/vtypes.json",
+		Structs:  []string{"_GUID", "_HEADER"},
+	})
+	assert.NilError(t, err)
+
+	text := LayoutText(profile)
+	for _, expected := range []string{
+		"_HEADER (80 bytes)\n",
+		"  0x000c     4  unsigned long                Bits       bits 2-4\n",
+		"  0x0014    16  _GUID                        Id         overlaps Guids\n",
+		"  0x0038     ?  unsigned char[Count]         Dyn\n",
+		"  0x0038     8  *_HEADER                     Next       overlaps Big, SBig, SLong\n",
+		"  0x004c     4                               [padding]\n",
+	} {
+		assert.Assert(t, strings.Contains(text, expected), expected)
+	}
+
+	dot := LayoutDot(profile)
+	assert.Assert(t, strings.Contains(dot,
+		"    \"_HEADER\" -> \"_HEADER\" [label=\"Next\", style=dashed];\n"))
+
+	markdown := LayoutMarkdown(profile)
+	for _, expected := range []string{
+		"    S1 -.->|Next| S1\n",
+		"| 0x0038 | 8 | \\*_HEADER | Next | overlaps Big, SBig, SLong |\n",
+	} {
+		assert.Assert(t, strings.Contains(markdown, expected), expected)
+	}
+}
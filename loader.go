@@ -0,0 +1,226 @@
+package binparsergen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+// Supported formats for the spec's input file.
+const (
+	// Rekall style vtype json files (the default).
+	FORMAT_VTYPES = "vtypes"
+
+	// Volatility 3 Intermediate Symbol Format json files.
+	FORMAT_ISF = "isf"
+
+	// C header files.
+	FORMAT_C = "c"
+
+	// ELF files with DWARF debug information.
+	FORMAT_DWARF = "dwarf"
+
+	// Microsoft PDB files.
+	FORMAT_PDB = "pdb"
+
+	// Linux BTF, either raw or in the .BTF section of an ELF file.
+	FORMAT_BTF = "btf"
+
+	// Kaitai Struct .ksy files.
+	FORMAT_KSY = "ksy"
+)
+
+// What an input file defines about the profile besides its structs.
+// Zero values are not defined by the file.
+type ProfileInfo struct {
+	PointerSize      int
+	PointerBigEndian bool
+
+	// Named enums and constants (e.g. in Rekall profiles).
+	Enums     map[string]map[int]string
+	Constants map[string]uint64
+}
+
+// Merge what a later file defines. Its enums and constants replace
+// those with the same names.
+func (self *ProfileInfo) merge(other *ProfileInfo) {
+	if self.PointerSize == 0 {
+		self.PointerSize = other.PointerSize
+	}
+	if other.PointerBigEndian {
+		self.PointerBigEndian = true
+	}
+
+	for name, choices := range other.Enums {
+		if self.Enums == nil {
+			self.Enums = make(map[string]map[int]string)
+		}
+		self.Enums[name] = choices
+	}
+	for name, value := range other.Constants {
+		if self.Constants == nil {
+			self.Constants = make(map[string]uint64)
+		}
+		self.Constants[name] = value
+	}
+}
+
+// A copy of the spec which uses what the files define unless the spec
+// sets it.
+func (self *ProfileInfo) apply(spec *ConversionSpec) *ConversionSpec {
+	result := *spec
+	if result.PointerSize == 0 {
+		result.PointerSize = self.PointerSize
+	}
+	if self.PointerBigEndian {
+		result.PointerBigEndian = true
+	}
+	result.enums = self.Enums
+	result.constants = self.Constants
+	return &result
+}
+
+// The vtype json of the spec's input files and what they define about
+// the profile.
+type Definitions struct {
+	VTypes []byte
+	ProfileInfo
+}
+
+// Load the spec's input files and convert them to vtype json. All
+// input formats are converted to vtypes first so they can share the
+// rest of the conversion pipeline.
+func LoadDefinitions(spec *ConversionSpec) (*Definitions, error) {
+	definitions, err := loadDefinitionFile(spec.Filename, spec.Format, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(spec.Filenames) > 0 {
+		return applyOverlays(definitions, spec)
+	}
+	return definitions, nil
+}
+
+func loadDefinitionFile(
+	filename string, format string, spec *ConversionSpec) (*Definitions, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var vtypes *ordereddict.Dict
+	var info *ProfileInfo
+
+	switch format {
+	case "", FORMAT_VTYPES:
+		if isRekallProfile(data) {
+			structs, info, err := ConvertRekall(data, spec)
+			if err != nil {
+				return nil, err
+			}
+			return &Definitions{VTypes: structs, ProfileInfo: *info}, nil
+		}
+		return &Definitions{VTypes: data}, nil
+
+	case FORMAT_ISF:
+		vtypes, info, err = ConvertISF(data, spec)
+
+	case FORMAT_C:
+		vtypes, info, err = ConvertCHeader(data, spec)
+
+	case FORMAT_DWARF:
+		vtypes, info, err = ConvertDWARF(data, spec)
+
+	case FORMAT_PDB:
+		vtypes, info, err = ConvertPDB(data, spec)
+
+	case FORMAT_BTF:
+		vtypes, info, err = ConvertBTF(data, spec)
+
+	case FORMAT_KSY:
+		vtypes, info, err = ConvertKSY(data, spec)
+
+	default:
+		return nil, fmt.Errorf("Unsupported input format %v", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	serialized, err := json.Marshal(vtypes)
+	if err != nil {
+		return nil, err
+	}
+	return &Definitions{VTypes: serialized, ProfileInfo: *info}, nil
+}
+
+// Helpers for building vtype definitions in memory. Loaders for other
+// formats produce an *ordereddict.Dict mapping struct names to
+// vtypeStruct() definitions.
+
+func vtypeStruct(size int64, fields *ordereddict.Dict) []interface{} {
+	return []interface{}{size, fields}
+}
+
+func vtypeField(offset int64, definition []interface{}) []interface{} {
+	return []interface{}{offset, definition}
+}
+
+func vtypeType(name string, args map[string]interface{}) []interface{} {
+	if args == nil {
+		args = make(map[string]interface{})
+	}
+	return []interface{}{name, args}
+}
+
+// Wrap a type definition as the target of a Pointer or Array.
+func vtypeTarget(args map[string]interface{}, target []interface{}) map[string]interface{} {
+	args["target"] = target[0]
+	args["target_args"] = target[1]
+	return args
+}
+
+// A BitField field for the bits [bit, bit + length) counted from the
+// start of the struct. The bits are read from the smallest aligned
+// unit of the target type (of size bytes) which contains them.
+func vtypeBitField(bit, length, size int64, target interface{}) []interface{} {
+	unit := size * 8
+	start := bit - bit%unit
+	if bit-start+length > unit {
+		start = bit - bit%8
+	}
+
+	return vtypeField(start/8, vtypeType("BitField", map[string]interface{}{
+		"start_bit": bit - start,
+		"end_bit":   bit - start + length,
+		"target":    target,
+	}))
+}
+
+// The vtype name of an integer with the given properties.
+func vtypeIntegerName(size int64, signed bool, big_endian bool) string {
+	name := ""
+	switch size {
+	case 1:
+		name = "char"
+	case 2:
+		name = "short"
+	case 4:
+		name = "long"
+	case 8:
+		name = "long long"
+	default:
+		return ""
+	}
+
+	if big_endian && size > 1 {
+		name = "be " + name
+	}
+	if !signed {
+		name = "unsigned " + name
+	}
+	return name
+}
@@ -0,0 +1,181 @@
+package binparsergen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+/* Vtype files of whole kernels are large but specs typically only use
+   a few of their structs. A minimized file only contains the structs
+   the spec selects and everything they reference, in their original
+   order, so it may be committed next to the spec instead.
+
+   Rekall profiles stay Rekall profiles but only keep the enums the
+   structs use and the spec's Constants. Definitions in other formats
+   are minimized to Rekall profiles as well so the pointer size, enums
+   and constants the loader found in them are kept.
+*/
+
+func MinimizeDefinitions(spec *ConversionSpec) ([]byte, error) {
+	if len(spec.Versions) > 0 {
+		return nil, fmt.Errorf("Specs with Versions can not be minimized, " +
+			"minimize each version's file instead")
+	}
+
+	// Referenced structs are always kept.
+	convert_spec := *spec
+	convert_spec.IncludeReferenced = true
+	profile, err := ConvertSpec(&convert_spec)
+	if err != nil {
+		return nil, err
+	}
+
+	definitions, err := LoadDefinitions(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	vtypes := ordereddict.NewDict()
+	err = json.Unmarshal(definitions.VTypes, vtypes)
+	if err != nil {
+		return nil, err
+	}
+
+	structs := ordereddict.NewDict()
+	for _, type_name := range vtypes.Keys() {
+		if _, pres := profile[type_name]; pres {
+			definition, _ := vtypes.Get(type_name)
+			structs.Set(type_name, definition)
+		}
+	}
+	Logger.Printf("%v: Kept %d of %d structs", spec.Filename,
+		len(structs.Keys()), len(vtypes.Keys()))
+
+	if spec.Format != "" && spec.Format != FORMAT_VTYPES {
+		return minimizeToRekall(definitions, structs, spec)
+	}
+
+	data, err := ioutil.ReadFile(spec.Filename)
+	if err != nil {
+		return nil, err
+	}
+	if !isRekallProfile(data) {
+		return json.Marshal(structs)
+	}
+	return minimizeRekall(data, structs, spec)
+}
+
+// Replace the $STRUCTS of the Rekall profile and drop the enums and
+// constants which are not needed.
+func minimizeRekall(data []byte, structs *ordereddict.Dict,
+	spec *ConversionSpec) ([]byte, error) {
+	rekall := ordereddict.NewDict()
+	err := json.Unmarshal(data, rekall)
+	if err != nil {
+		return nil, err
+	}
+
+	enum_names := make(map[string]bool)
+	collectEnumNames(structs, enum_names)
+
+	result := ordereddict.NewDict()
+	for _, key := range rekall.Keys() {
+		value, _ := rekall.Get(key)
+		switch key {
+		case "$STRUCTS":
+			value = structs
+
+		case "$ENUMS":
+			value = filterDict(value, func(name string) bool {
+				return enum_names[name]
+			})
+
+		case "$CONSTANTS":
+			value = filterDict(value, func(name string) bool {
+				return InString(spec.Constants, name)
+			})
+		}
+		result.Set(key, value)
+	}
+	return json.Marshal(result)
+}
+
+// Build a Rekall profile from the structs and what the loader found
+// in the original file.
+func minimizeToRekall(definitions *Definitions, structs *ordereddict.Dict,
+	spec *ConversionSpec) ([]byte, error) {
+	metadata := ordereddict.NewDict().Set("Type", "Profile")
+	if definitions.PointerSize != 0 {
+		arch := rekallArch(definitions.PointerSize)
+		if arch == "" {
+			return nil, fmt.Errorf("%v: Pointer size %d can not be stored "+
+				"in a Rekall profile", spec.Filename, definitions.PointerSize)
+		}
+		metadata.Set("arch", arch)
+	}
+	if definitions.PointerBigEndian {
+		metadata.Set("endian", "big")
+	}
+
+	enum_names := make(map[string]bool)
+	collectEnumNames(structs, enum_names)
+
+	enums := make(map[string]map[int]string)
+	for name, choices := range definitions.Enums {
+		if enum_names[name] {
+			enums[name] = choices
+		}
+	}
+
+	constants := make(map[string]uint64)
+	for name, value := range definitions.Constants {
+		if InString(spec.Constants, name) {
+			constants[name] = value
+		}
+	}
+
+	return json.Marshal(ordereddict.NewDict().
+		Set("$METADATA", metadata).
+		Set("$STRUCTS", structs).
+		Set("$ENUMS", enums).
+		Set("$CONSTANTS", constants))
+}
+
+// The keys of the dict which are kept.
+func filterDict(value interface{}, keep func(name string) bool) *ordereddict.Dict {
+	result := ordereddict.NewDict()
+	dict, ok := value.(*ordereddict.Dict)
+	if !ok {
+		return result
+	}
+
+	for _, key := range dict.Keys() {
+		if keep(key) {
+			item, _ := dict.Get(key)
+			result.Set(key, item)
+		}
+	}
+	return result
+}
+
+// Find the enum_name parameters of Enumeration fields.
+func collectEnumNames(value interface{}, result map[string]bool) {
+	switch t := value.(type) {
+	case *ordereddict.Dict:
+		for _, key := range t.Keys() {
+			item, _ := t.Get(key)
+			if name, ok := item.(string); ok && key == "enum_name" {
+				result[name] = true
+			}
+			collectEnumNames(item, result)
+		}
+
+	case []interface{}:
+		for _, item := range t {
+			collectEnumNames(item, result)
+		}
+	}
+}
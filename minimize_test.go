@@ -0,0 +1,88 @@
+package binparsergen
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	"gotest.tools/assert"
+)
+
+func TestMinimize(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/vtypes.json")
+	assert.NilError(t, err)
+
+	// An unused struct before the ones the spec needs.
+	vtypes := ordereddict.NewDict()
+	vtypes.Set("_UNUSED", []interface{}{4, map[string]interface{}{}})
+	assert.NilError(t, json.Unmarshal(data, vtypes))
+
+	data, err = json.Marshal(vtypes)
+	assert.NilError(t, err)
+	filename := filepath.Join(t.TempDir(), "vtypes.json")
+	assert.NilError(t, ioutil.WriteFile(filename, data, 0644))
+
+	// _GUID is kept because _HEADER refers to it.
+	data, err = MinimizeDefinitions(&ConversionSpec{
+		Filename: filename,
+		Structs:  []string{"_HEADER"},
+	})
+	assert.NilError(t, err)
+
+	minimized := ordereddict.NewDict()
+	assert.NilError(t, json.Unmarshal(data, minimized))
+	assert.DeepEqual(t, minimized.Keys(), []string{"_GUID", "_HEADER"})
+
+	// Rekall profiles only keep the enums used and the spec's
+	// constants.
+	data, err = MinimizeDefinitions(&ConversionSpec{
+		Filename:  "testdata/rekall.json",
+		Structs:   []string{"_POOL_HEADER"},
+		Constants: []string{"PsActiveProcessHead"},
+	})
+	assert.NilError(t, err)
+
+	rekall := &rekallProfile{}
+	assert.NilError(t, json.Unmarshal(data, rekall))
+	assert.DeepEqual(t, rekall.Constants, map[string]uint64{
+		"PsActiveProcessHead": 0x806c8000})
+	assert.DeepEqual(t, rekall.Enums, map[string]map[int]string{
+		"_POOL_TYPE": {0: "NonPagedPool", 1: "PagedPool"}})
+	assert.Equal(t, rekall.Metadata["arch"], "I386")
+}
+
+// Other formats are minimized to Rekall profiles which generate the
+// same profile.
+func TestMinimizeRoundTrip(t *testing.T) {
+	spec := &ConversionSpec{
+		Module:    "main",
+		Profile:   "TestProfile",
+		Filename:  "testdata/isf.json",
+		Format:    FORMAT_ISF,
+		Structs:   []string{"_LIST_ENTRY", "_OBJECT"},
+		Constants: []string{"PsActiveProcessHead"},
+	}
+
+	data, err := MinimizeDefinitions(spec)
+	assert.NilError(t, err)
+	filename := filepath.Join(t.TempDir(), "minimized.json")
+	assert.NilError(t, ioutil.WriteFile(filename, data, 0644))
+
+	minimized_spec := *spec
+	minimized_spec.Filename = filename
+	minimized_spec.Format = ""
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+	minimized_profile, err := ConvertSpec(&minimized_spec)
+	assert.NilError(t, err)
+
+	assert.Equal(t, minimized_profile["_LIST_ENTRY"].Fields["Blink"].Pointer.PointerSize, 4)
+	// Only the file name in the header differs.
+	code := strings.Replace(GenerateCode(&minimized_spec, minimized_profile),
+		filename, spec.Filename, 1)
+	assert.Equal(t, code, GenerateCode(spec, profile))
+}
@@ -0,0 +1,125 @@
+package binparsergen
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+/* Generated profiles are only correct for the vtypes they were
+   generated from. When the spec sets GenerateOffsetLoader, the
+   profile can be updated from a newer vtype, Rekall or ISF json file
+   at runtime instead of regenerating the code for offset shifts:
+
+   profile, warnings, err := LoadNtProfileOffsets(fd)
+
+   Struct sizes and field offsets are looked up by their names in the
+   original vtypes by the runtime package's OffsetLoader. Missing
+   fields and the fields of missing structs get the offset -1, so all
+   fields get a Has_<Field>() method and their accessors return zero
+   values when they are missing. Missing structs and fields are
+   reported in the warnings, as are fields whose type or bit range
+   changed.
+
+   Unlike the rest of the generated code, the offset loader imports
+   www.velocidex.com/golang/binparsergen/runtime so the module using
+   it must require this module.
+*/
+
+// Integer type names which have a canonical name (the names the
+// loaders produce).
+var integerTypeAliases = map[string]string{
+	"unsigned int":    "unsigned long",
+	"uint32":          "unsigned long",
+	"unsigned be int": "unsigned be long",
+	"int":             "long",
+	"int32":           "long",
+	"be int":          "be long",
+	"uint64":          "unsigned long long",
+	"int64":           "long long",
+	"uint16":          "unsigned short",
+	"int16":           "short",
+	"uint8":           "unsigned char",
+	"int8":            "char",
+}
+
+// The canonical name of a vtype type name.
+func canonicalTypeName(name string) string {
+	canonical, pres := integerTypeAliases[name]
+	if pres {
+		return canonical
+	}
+	return name
+}
+
+// The type of a vtype type definition (e.g. ["BitField", {...}]) which
+// the offset loader compares. Integer types have their canonical name
+// and bit fields include their bit range (e.g. "BitField[2:5]") so
+// moved bits are reported as well.
+func VtypeTypeName(params []json.RawMessage) string {
+	var name string
+	if len(params) == 0 || json.Unmarshal(params[0], &name) != nil {
+		return ""
+	}
+
+	if name == "BitField" && len(params) > 1 {
+		bitfield := &BitField{}
+		if json.Unmarshal(params[1], bitfield) == nil {
+			return fmt.Sprintf("BitField[%d:%d]", bitfield.StartBit, bitfield.EndBit)
+		}
+	}
+	return canonicalTypeName(name)
+}
+
+// Fields may be missing from the offsets loaded at runtime.
+func markLoadableFields(spec *ConversionSpec,
+	profile map[string]*StructDefinition) {
+	if !spec.GenerateOffsetLoader {
+		return
+	}
+	for _, struct_def := range profile {
+		for _, field_def := range struct_def.Fields {
+			if field_def != nil {
+				field_def.optional = true
+			}
+		}
+	}
+}
+
+func GenerateOffsetLoader(profile_name string,
+	profile map[string]*StructDefinition) string {
+	setters := ""
+	for _, struct_name := range SortedKeys(profile) {
+		struct_def := profile[struct_name]
+		normalized_name := NormalizeName(struct_name)
+		setters += fmt.Sprintf("    loader.Size(&self.Size_%s, %q)\n",
+			normalized_name, struct_name)
+
+		for _, field_name := range struct_def.fields {
+			field_def := struct_def.Fields[field_name]
+			if field_def == nil {
+				continue
+			}
+
+			setters += fmt.Sprintf("    loader.Offset(&self.Off_%s_%s, %q, %q, %q)\n",
+				normalized_name, field_name, struct_name,
+				field_def.vtype_name, field_def.vtype_type)
+		}
+	}
+
+	return fmt.Sprintf(`
+// Load the struct sizes and field offsets from a vtype, Rekall or ISF
+// json file, starting from the default profile. Missing fields and
+// the fields of missing structs get the offset -1. They are returned
+// as warnings together with fields whose type changed.
+func Load%[1]sOffsets(reader io.Reader) (*%[1]s, []string, error) {
+    types, err := runtime.ParseOffsetTypes(reader)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    self := New%[1]s()
+    loader := &runtime.OffsetLoader{Types: types}
+%[2]s    return self, loader.Warnings, nil
+}
+`, profile_name, setters)
+}
@@ -0,0 +1,96 @@
+package binparsergen
+
+import (
+	"go/format"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestGenerateOffsetLoader(t *testing.T) {
+	spec := &ConversionSpec{
+		Module:               "main",
+		Profile:              "TestProfile",
+		Filename:             "testdata/vtypes.json",
+		Structs:              []string{"_GUID", "_HEADER"},
+		GenerateOffsetLoader: true,
+		FieldRenames: map[string]map[string]string{
+			"_HEADER": {"Count": "ItemsLength"},
+		},
+		FieldTypeOverrides: map[string]map[string][]interface{}{
+			"_HEADER": {"Items": {"UnicodeString", map[string]interface{}{
+				"dynamic_length": "Count"}}},
+		},
+	}
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+
+	code := GenerateCode(spec, profile)
+	_, err = format.Source([]byte(code))
+	assert.NilError(t, err)
+
+	// Offsets are loaded by the original names and types.
+	for _, expected := range []string{
+		"func LoadTestProfileOffsets(reader io.Reader) (*TestProfile, []string, error) {",
+		`    loader.Size(&self.Size_GUID, "_GUID")`,
+		`    loader.Offset(&self.Off_GUID_Data1, "_GUID", "Data1", "unsigned long")`,
+		`    loader.Offset(&self.Off_HEADER_ItemsLength, "_HEADER", "Count", "unsigned char")`,
+		`    loader.Offset(&self.Off_HEADER_Items, "_HEADER", "Items", "Array")`,
+		`    loader.Offset(&self.Off_HEADER_Id, "_HEADER", "Id", "_GUID")`,
+		`    loader.Offset(&self.Off_HEADER_Bits, "_HEADER", "Bits", "BitField[2:5]")`,
+		`    "www.velocidex.com/golang/binparsergen/runtime"`,
+		"    types, err := runtime.ParseOffsetTypes(reader)",
+		"func (self *GUID) Has_Data1() bool {",
+	} {
+		assert.Assert(t, strings.Contains(code, expected), expected)
+	}
+
+	// Only offset loaders depend on this module.
+	spec.GenerateOffsetLoader = false
+	profile, err = ConvertSpec(spec)
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(GenerateCode(spec, profile), "binparsergen"))
+}
+
+func TestOffsetLoaderMissingFields(t *testing.T) {
+	// Data1 moved, Data2 is missing and so is all of _HEADER.
+	output := runGeneratedCode(t, &ConversionSpec{
+		Filename:             "testdata/vtypes.json",
+		Structs:              []string{"_GUID", "_HEADER"},
+		GenerateOffsetLoader: true,
+	}, `package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+func main() {
+	profile, warnings, err := LoadTestProfileOffsets(strings.NewReader(`+"`"+`{
+  "_GUID": [20, {
+     "Data1": [4, ["unsigned int", {}]],
+     "Data3": [8, ["unsigned short", {}]],
+     "Data4": [12, ["Array", {"count": 8, "target": "unsigned char"}]]
+  }]
+}`+"`"+`))
+	if err != nil {
+		panic(err)
+	}
+
+	data := []byte{0xff, 0xff, 0xff, 0xff, 1, 0, 0, 0, 2, 0}
+	guid := profile.GUID(bytes.NewReader(data), 0)
+	header := profile.HEADER(bytes.NewReader(data), 0)
+	fmt.Println(profile.Size_GUID, guid.Data1(), guid.Has_Data2(), guid.Data2(), guid.Data3())
+	fmt.Println(header.Has_Version(), header.Version(), profile.Off_HEADER_Magic)
+	fmt.Println(strings.Join(warnings, ", "))
+}
+`)
+
+	lines := strings.Split(output, "\n")
+	assert.Equal(t, lines[0], "20 1 false 0 2")
+	assert.Equal(t, lines[1], "false 0 -1")
+	assert.Equal(t, lines[2], "_GUID.Data2 is missing, _HEADER is missing")
+}
@@ -0,0 +1,128 @@
+package binparsergen
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+/* Additional vtype files are merged over the definitions in order,
+   like Rekall overlays. An overlay only needs to mention what it
+   changes, with null keeping the original value:
+
+   {
+     "_EPROCESS": [null, {
+        "ImageFileName": [null, ["String", {"length": 16}]],
+        "Cookie": [0x100, ["unsigned long", {}]]
+     }]
+   }
+
+   Here ImageFileName keeps its offset but becomes a String, and
+   Cookie is a new field. Structs which are not in the definitions yet
+   are added as they are.
+*/
+
+// Merge the overlay into the definitions. Returns the overlay keys
+// which modify something that does not exist.
+func mergeOverlay(definitions, overlay *ordereddict.Dict) []string {
+	unmatched := []string{}
+	for _, struct_name := range overlay.Keys() {
+		value, _ := overlay.Get(struct_name)
+		overlay_struct, ok := value.([]interface{})
+		if !ok || len(overlay_struct) < 2 {
+			unmatched = append(unmatched, struct_name)
+			continue
+		}
+
+		value, pres := definitions.Get(struct_name)
+		if !pres {
+			// A new struct must be complete.
+			if overlay_struct[0] == nil {
+				unmatched = append(unmatched, struct_name)
+				continue
+			}
+			definitions.Set(struct_name, overlay_struct)
+			continue
+		}
+
+		base_struct, ok := value.([]interface{})
+		if !ok || len(base_struct) < 2 {
+			definitions.Set(struct_name, overlay_struct)
+			continue
+		}
+
+		if overlay_struct[0] != nil {
+			base_struct[0] = overlay_struct[0]
+		}
+
+		base_fields, _ := base_struct[1].(*ordereddict.Dict)
+		overlay_fields, _ := overlay_struct[1].(*ordereddict.Dict)
+		if base_fields == nil || overlay_fields == nil {
+			continue
+		}
+
+		for _, field_name := range overlay_fields.Keys() {
+			value, _ := overlay_fields.Get(field_name)
+			overlay_field, ok := value.([]interface{})
+			if !ok || len(overlay_field) < 2 {
+				unmatched = append(unmatched, struct_name+"."+field_name)
+				continue
+			}
+
+			value, pres := base_fields.Get(field_name)
+			base_field, ok := value.([]interface{})
+			if !pres || !ok || len(base_field) < 2 {
+				// A new field needs both an offset and a type.
+				if overlay_field[0] == nil || overlay_field[1] == nil {
+					unmatched = append(unmatched, struct_name+"."+field_name)
+					continue
+				}
+				base_fields.Set(field_name, overlay_field)
+				continue
+			}
+
+			if overlay_field[0] != nil {
+				base_field[0] = overlay_field[0]
+			}
+			if overlay_field[1] != nil {
+				base_field[1] = overlay_field[1]
+			}
+		}
+	}
+
+	return unmatched
+}
+
+// Merge the vtype json from the overlay files over the definitions.
+func applyOverlays(definitions *Definitions, spec *ConversionSpec) (*Definitions, error) {
+	merged := ordereddict.NewDict()
+	err := json.Unmarshal(definitions.VTypes, merged)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range spec.Filenames {
+		overlay_definitions, err := loadDefinitionFile(filename, FORMAT_VTYPES, spec)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", filename, err)
+		}
+		definitions.merge(&overlay_definitions.ProfileInfo)
+
+		overlay := ordereddict.NewDict()
+		err = json.Unmarshal(overlay_definitions.VTypes, overlay)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", filename, err)
+		}
+
+		for _, key := range mergeOverlay(merged, overlay) {
+			Logger.Printf("%v: Overlay %v did not match anything", filename, key)
+		}
+	}
+
+	definitions.VTypes, err = json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	return definitions, nil
+}
@@ -0,0 +1,41 @@
+package binparsergen
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestOverlays(t *testing.T) {
+	output := &bytes.Buffer{}
+	defer func(logger *log.Logger) { Logger = logger }(Logger)
+	Logger = log.New(output, "", 0)
+
+	spec := &ConversionSpec{
+		Filename:  "testdata/vtypes.json",
+		Filenames: []string{"testdata/overlay.json"},
+		Structs:   []string{"_GUID", "_HEADER", "_TRAILER"},
+	}
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+
+	header := profile["_HEADER"]
+	assert.Equal(t, header.Size, uint32(96))
+	assert.Equal(t, header.fields[len(header.fields)-1], "Trailer")
+
+	// Overlays may replace only the offset or only the type.
+	assert.Equal(t, header.Fields["Items"].Offset, int64(52))
+	assert.Equal(t, header.Fields["Items"].UTF16StringParser.Length, uint64(4))
+	assert.Equal(t, header.Fields["Version"].Offset, int64(2))
+	assert.Assert(t, header.Fields["Version"].Uint16Parser != nil)
+	assert.Assert(t, header.Fields["Missing"] == nil)
+
+	assert.Equal(t, profile["_TRAILER"].Size, uint32(4))
+
+	assert.Equal(t, output.String(),
+		"testdata/overlay.json: Overlay _HEADER.Missing did not match anything\n"+
+			"testdata/overlay.json: Overlay _UNKNOWN did not match anything\n")
+}
@@ -0,0 +1,236 @@
+package binparsergen
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+/* The spec may rename fields and replace their type definitions:
+
+   FieldRenames:
+     _CM_KEY_VALUE:
+       u1: Flags
+   FieldTypeOverrides:
+     _CM_KEY_VALUE:
+       Name: [String, {dynamic_length: NameLength}]
+
+   Overrides are vtype type definitions and refer to the fields by
+   their original names, like the FieldWhiteList and FieldBlackList.
+*/
+
+// Check that the renames and overrides refer to existing fields.
+func checkFieldOptions(types map[string][]*json.RawMessage, spec *ConversionSpec) error {
+	errors := []string{}
+	check := func(option, type_name string, field_names []string) {
+		definition_list, pres := types[type_name]
+		if !pres || len(definition_list) < 2 {
+			errors = append(errors, fmt.Sprintf("  %v: %v does not exist",
+				option, type_name))
+			return
+		}
+
+		fields := ordereddict.NewDict()
+		err := json.Unmarshal(*definition_list[1], fields)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("  %v: %v: %v",
+				option, type_name, err))
+			return
+		}
+
+		for _, field_name := range field_names {
+			_, pres := fields.Get(field_name)
+			if !pres {
+				errors = append(errors, fmt.Sprintf("  %v: %v.%v does not exist",
+					option, type_name, field_name))
+			}
+		}
+	}
+
+	for _, type_name := range SortedKeys(spec.FieldRenames) {
+		check("FieldRenames", type_name, SortedKeys(spec.FieldRenames[type_name]))
+	}
+	for _, type_name := range SortedKeys(spec.FieldTypeOverrides) {
+		check("FieldTypeOverrides", type_name,
+			SortedKeys(spec.FieldTypeOverrides[type_name]))
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("Invalid field options:\n%v", strings.Join(errors, "\n"))
+	}
+	return nil
+}
+
+// The field definition with the type replaced by the override.
+func overrideFieldDef(field_def []*json.RawMessage,
+	override []interface{}) ([]*json.RawMessage, error) {
+	if len(field_def) < 1 {
+		return nil, fmt.Errorf("Invalid field definition")
+	}
+
+	serialized, err := json.Marshal(normalizeYAML(override))
+	if err != nil {
+		return nil, err
+	}
+
+	// ParseFieldDef can not report errors so the override must be
+	// checked first.
+	var params []json.RawMessage
+	err = json.Unmarshal(serialized, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkVtypeParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := json.RawMessage(serialized)
+	return []*json.RawMessage{field_def[0], &raw}, nil
+}
+
+// Check that a vtype type definition (e.g. ["Array", {"count": 4,
+// "target": "long"}]) can be parsed.
+func checkVtypeParams(params []json.RawMessage) error {
+	if len(params) == 0 || len(params) > 2 {
+		return fmt.Errorf("Expected [type] or [type, arguments]")
+	}
+
+	var parser_name string
+	err := json.Unmarshal(params[0], &parser_name)
+	if err != nil {
+		return fmt.Errorf("Type must be a string, not %s", params[0])
+	}
+
+	var args json.RawMessage
+	if len(params) > 1 {
+		args = params[1]
+	}
+
+	var target interface{}
+	switch parser_name {
+	case "Pointer", "Array":
+		target = &VtypeArray{}
+	case "Enumeration":
+		target = &Enumeration{}
+	case "Signature":
+		target = &SignatureParser{}
+	case "Flags":
+		target = &Flags{}
+	case "BitField":
+		target = &BitField{}
+	case "String":
+		if len(args) == 0 {
+			return nil
+		}
+		target = &StringParser{}
+	case "UnicodeString":
+		if len(params) == 1 {
+			return nil
+		}
+		target = &UTF16StringParser{}
+	default:
+		return nil
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("%v needs arguments", parser_name)
+	}
+
+	err = json.Unmarshal(args, target)
+	if err != nil {
+		return fmt.Errorf("%v: %w", parser_name, err)
+	}
+
+	// Pointer and Array targets are type definitions themselves.
+	vtype_array, ok := target.(*VtypeArray)
+	if ok {
+		return checkVtypeParams([]json.RawMessage{
+			vtype_array.Target, vtype_array.TargetArgs})
+	}
+	return nil
+}
+
+// Rename a struct's fields. Fields which refer to renamed fields
+// (e.g. the dynamic count of arrays) are updated as well.
+func renameFields(type_name string, struct_def *StructDefinition,
+	renames map[string]string) error {
+	if len(renames) == 0 {
+		return nil
+	}
+
+	fields := make(map[string]*FieldDefinition)
+	new_names := []string{}
+	for _, field_name := range struct_def.fields {
+		new_name, pres := renames[field_name]
+		if !pres {
+			new_name = field_name
+		}
+
+		if InString(new_names, new_name) {
+			return fmt.Errorf("FieldRenames: %v: %v is used by more than one field",
+				type_name, new_name)
+		}
+		new_names = append(new_names, new_name)
+
+		field_def, pres := struct_def.Fields[field_name]
+		if pres {
+			fields[new_name] = field_def
+		}
+	}
+	struct_def.fields = new_names
+	struct_def.Fields = fields
+
+	rename := func(name *string) {
+		new_name, pres := renames[*name]
+		if pres {
+			*name = new_name
+		}
+	}
+
+	for _, field_def := range fields {
+		if field_def.ArrayParser != nil {
+			rename(&field_def.ArrayParser.DynamicCount)
+		}
+		if field_def.StringParser != nil {
+			rename(&field_def.StringParser.DynamicLength)
+		}
+		if field_def.UTF16StringParser != nil {
+			rename(&field_def.UTF16StringParser.DynamicLength)
+		}
+	}
+
+	return nil
+}
+
+// YAML decodes maps as map[interface{}]interface{} which can not be
+// serialized to json.
+func normalizeYAML(value interface{}) interface{} {
+	switch t := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{})
+		for k, v := range t {
+			result[fmt.Sprintf("%v", k)] = normalizeYAML(v)
+		}
+		return result
+
+	case map[string]interface{}:
+		result := make(map[string]interface{})
+		for k, v := range t {
+			result[k] = normalizeYAML(v)
+		}
+		return result
+
+	case []interface{}:
+		result := make([]interface{}, 0, len(t))
+		for _, v := range t {
+			result = append(result, normalizeYAML(v))
+		}
+		return result
+	}
+
+	return value
+}
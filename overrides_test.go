@@ -0,0 +1,97 @@
+package binparsergen
+
+import (
+	"strings"
+	"testing"
+
+	yaml "github.com/Velocidex/yaml/v2"
+	"gotest.tools/assert"
+)
+
+func TestFieldOverrides(t *testing.T) {
+	spec := &ConversionSpec{}
+	err := yaml.Unmarshal([]byte(`
+Module: main
+Profile: TestProfile
+Filename: testdata/vtypes.json
+Structs: [_GUID, _HEADER]
+GenerateBuilder: true
+GenerateErrorAccessors: true
+FieldRenames:
+  _HEADER:
+    Count: ItemsLength
+    Id: Guid
+FieldTypeOverrides:
+  _HEADER:
+    Items: [UnicodeString, {dynamic_length: Count}]
+`), spec)
+	assert.NilError(t, err)
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+
+	header := profile["_HEADER"]
+	assert.Equal(t, header.fields[8], "Guid")
+	assert.Assert(t, header.Fields["Id"] == nil)
+	assert.Equal(t, header.Fields["Guid"].StructParser.Target, "GUID")
+	assert.Equal(t, header.Fields["Items"].Offset, int64(52))
+	assert.Equal(t, header.Fields["Items"].UTF16StringParser.DynamicLength, "ItemsLength")
+	assert.Equal(t, header.Fields["Dyn"].ArrayParser.DynamicCount, "ItemsLength")
+
+	code := GenerateCode(spec, profile)
+	assert.Assert(t, strings.Contains(code,
+		"ParseUTF16String(self.Reader, self.Profile.Off_HEADER_Items + self.Offset, int64(self.ItemsLength()))"))
+	assert.Assert(t, strings.Contains(code,
+		"WriteUTF16String(self.buf, self.Profile.Off_HEADER_Items, value, -1)"))
+
+	_, err = ConvertSpec(&ConversionSpec{
+		Filename: "testdata/vtypes.json",
+		Structs:  []string{"_GUID"},
+		FieldRenames: map[string]map[string]string{
+			"_GUID":    {"Data5": "Extra"},
+			"_MISSING": {"A": "B"},
+		},
+		FieldTypeOverrides: map[string]map[string][]interface{}{
+			"_HEADER": {"Nothing": {"unsigned long"}},
+		},
+	})
+	assert.Error(t, err, "Invalid field options:\n"+
+		"  FieldRenames: _GUID.Data5 does not exist\n"+
+		"  FieldRenames: _MISSING does not exist\n"+
+		"  FieldTypeOverrides: _HEADER.Nothing does not exist")
+
+	_, err = ConvertSpec(&ConversionSpec{
+		Filename: "testdata/vtypes.json",
+		Structs:  []string{"_GUID"},
+		FieldRenames: map[string]map[string]string{
+			"_GUID": {"Data1": "Data2"},
+		},
+	})
+	assert.Error(t, err, "FieldRenames: _GUID: Data2 is used by more than one field")
+}
+
+func TestInvalidFieldOverrides(t *testing.T) {
+	for _, testcase := range []struct {
+		override []interface{}
+		err      string
+	}{
+		{[]interface{}{}, "Expected [type] or [type, arguments]"},
+		{[]interface{}{4}, "Type must be a string, not 4"},
+		{[]interface{}{"Array"}, "Array needs arguments"},
+		{[]interface{}{"BitField", "bits"}, "BitField: json: cannot unmarshal string"},
+		{[]interface{}{"Array", map[string]interface{}{"count": 2}},
+			"Type must be a string, not "},
+		{[]interface{}{"Pointer", map[string]interface{}{
+			"target": "Array", "target_args": map[string]interface{}{
+				"target": "Enumeration"}}}, "Enumeration needs arguments"},
+	} {
+		_, err := ConvertSpec(&ConversionSpec{
+			Filename: "testdata/vtypes.json",
+			Structs:  []string{"_GUID"},
+			FieldTypeOverrides: map[string]map[string][]interface{}{
+				"_GUID": {"Data1": testcase.override},
+			},
+		})
+		assert.ErrorContains(t, err, "FieldTypeOverrides: _GUID.Data1: "+testcase.err)
+	}
+}
@@ -1,6 +1,9 @@
 package binparsergen
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // A parser is an object which generates code to extract a specific
 // object from binary data.
@@ -69,37 +72,49 @@ func (self BaseParser) Dependencies() []Parser {
 	return nil
 }
 
+// The name of the encoding/binary byte order used to decode integers.
+func byteOrder(big_endian bool) string {
+	if big_endian {
+		return "BigEndian"
+	}
+	return "LittleEndian"
+}
+
 type NullParser struct {
 	BaseParser
 }
 
 type Uint64Parser struct {
 	BaseParser
+	BigEndian bool `json:"big_endian,omitempty"`
 }
 
 func (self Uint64Parser) Compile(struct_name string, field_name string) string {
 	return fmt.Sprintf(`
 func (self *%[1]s) %[2]s() uint64 {
-    return ParseUint64(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
+    return %[3]s(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
 }
-`, struct_name, field_name)
+`, struct_name, field_name, self.PrototypeName())
 }
 
 func (self Uint64Parser) Prototype() string {
 	return fmt.Sprintf(`
-func ParseUint64(reader io.ReaderAt, offset int64) uint64 {
+func %[1]s(reader io.ReaderAt, offset int64) uint64 {
 	var buf [8]byte
 	data := buf[:]
     _, err := reader.ReadAt(data, offset)
     if err != nil {
        return 0
     }
-    return binary.LittleEndian.Uint64(data)
+    return binary.%[2]s.Uint64(data)
 }
-`)
+`, self.PrototypeName(), byteOrder(self.BigEndian))
 }
 
 func (self Uint64Parser) PrototypeName() string {
+	if self.BigEndian {
+		return "ParseUint64BE"
+	}
 	return "ParseUint64"
 }
 
@@ -113,31 +128,35 @@ func (self Uint64Parser) Size(value string) string {
 
 type Int64Parser struct {
 	BaseParser
+	BigEndian bool `json:"big_endian,omitempty"`
 }
 
 func (self Int64Parser) Compile(struct_name string, field_name string) string {
 	return fmt.Sprintf(`
 func (self *%[1]s) %[2]s() int64 {
-    return ParseInt64(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
+    return %[3]s(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
 }
-`, struct_name, field_name)
+`, struct_name, field_name, self.PrototypeName())
 }
 
 func (self Int64Parser) Prototype() string {
 	return fmt.Sprintf(`
-func ParseInt64(reader io.ReaderAt, offset int64) int64 {
+func %[1]s(reader io.ReaderAt, offset int64) int64 {
 	var buf [8]byte
 	data := buf[:]
     _, err := reader.ReadAt(data, offset)
     if err != nil {
        return 0
     }
-    return int64(binary.LittleEndian.Uint64(data))
+    return int64(binary.%[2]s.Uint64(data))
 }
-`)
+`, self.PrototypeName(), byteOrder(self.BigEndian))
 }
 
 func (self Int64Parser) PrototypeName() string {
+	if self.BigEndian {
+		return "ParseInt64BE"
+	}
 	return "ParseInt64"
 }
 
@@ -151,32 +170,36 @@ func (self Int64Parser) Size(value string) string {
 
 type Uint32Parser struct {
 	BaseParser
+	BigEndian bool `json:"big_endian,omitempty"`
 }
 
 func (self Uint32Parser) Prototype() string {
-	return `
-func ParseUint32(reader io.ReaderAt, offset int64) uint32 {
+	return fmt.Sprintf(`
+func %[1]s(reader io.ReaderAt, offset int64) uint32 {
 	var buf [4]byte
 	data := buf[:]
     _, err := reader.ReadAt(data, offset)
     if err != nil {
        return 0
     }
-    return binary.LittleEndian.Uint32(data)
+    return binary.%[2]s.Uint32(data)
 }
-`
+`, self.PrototypeName(), byteOrder(self.BigEndian))
 }
 
 func (self Uint32Parser) PrototypeName() string {
+	if self.BigEndian {
+		return "ParseUint32BE"
+	}
 	return "ParseUint32"
 }
 
 func (self Uint32Parser) Compile(struct_name string, field_name string) string {
 	return fmt.Sprintf(`
 func (self *%[1]s) %[2]s() uint32 {
-   return ParseUint32(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
+   return %[3]s(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
 }
-`, struct_name, field_name)
+`, struct_name, field_name, self.PrototypeName())
 }
 func (self Uint32Parser) GoType() string {
 	return "uint32"
@@ -187,32 +210,36 @@ func (self Uint32Parser) Size(value string) string {
 
 type Int32Parser struct {
 	BaseParser
+	BigEndian bool `json:"big_endian,omitempty"`
 }
 
 func (self Int32Parser) Prototype() string {
-	return `
-func ParseInt32(reader io.ReaderAt, offset int64) int32 {
+	return fmt.Sprintf(`
+func %[1]s(reader io.ReaderAt, offset int64) int32 {
 	var buf [4]byte
 	data := buf[:]
     _, err := reader.ReadAt(data, offset)
     if err != nil {
        return 0
     }
-    return int32(binary.LittleEndian.Uint32(data))
+    return int32(binary.%[2]s.Uint32(data))
 }
-`
+`, self.PrototypeName(), byteOrder(self.BigEndian))
 }
 
 func (self Int32Parser) PrototypeName() string {
+	if self.BigEndian {
+		return "ParseInt32BE"
+	}
 	return "ParseInt32"
 }
 
 func (self Int32Parser) Compile(struct_name string, field_name string) string {
 	return fmt.Sprintf(`
 func (self *%[1]s) %[2]s() int32 {
-   return ParseInt32(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
+   return %[3]s(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
 }
-`, struct_name, field_name)
+`, struct_name, field_name, self.PrototypeName())
 }
 func (self Int32Parser) GoType() string {
 	return "int32"
@@ -223,32 +250,36 @@ func (self Int32Parser) Size(value string) string {
 
 type Uint16Parser struct {
 	BaseParser
+	BigEndian bool `json:"big_endian,omitempty"`
 }
 
 func (self Uint16Parser) Prototype() string {
-	return `
-func ParseUint16(reader io.ReaderAt, offset int64) uint16 {
+	return fmt.Sprintf(`
+func %[1]s(reader io.ReaderAt, offset int64) uint16 {
 	var buf [2]byte
 	data := buf[:]
     _, err := reader.ReadAt(data, offset)
     if err != nil {
        return 0
     }
-    return binary.LittleEndian.Uint16(data)
+    return binary.%[2]s.Uint16(data)
 }
-`
+`, self.PrototypeName(), byteOrder(self.BigEndian))
 }
 
 func (self Uint16Parser) PrototypeName() string {
+	if self.BigEndian {
+		return "ParseUint16BE"
+	}
 	return "ParseUint16"
 }
 
 func (self Uint16Parser) Compile(struct_name string, field_name string) string {
 	return fmt.Sprintf(`
 func (self *%[1]s) %[2]s() uint16 {
-   return ParseUint16(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
+   return %[3]s(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
 }
-`, struct_name, field_name)
+`, struct_name, field_name, self.PrototypeName())
 }
 func (self Uint16Parser) GoType() string {
 	return "uint16"
@@ -259,32 +290,36 @@ func (self Uint16Parser) Size(value string) string {
 
 type Int16Parser struct {
 	BaseParser
+	BigEndian bool `json:"big_endian,omitempty"`
 }
 
 func (self Int16Parser) Prototype() string {
-	return `
-func ParseInt16(reader io.ReaderAt, offset int64) int16 {
+	return fmt.Sprintf(`
+func %[1]s(reader io.ReaderAt, offset int64) int16 {
 	var buf [2]byte
 	data := buf[:]
     _, err := reader.ReadAt(data, offset)
     if err != nil {
        return 0
     }
-    return int16(binary.LittleEndian.Uint16(data))
+    return int16(binary.%[2]s.Uint16(data))
 }
-`
+`, self.PrototypeName(), byteOrder(self.BigEndian))
 }
 
 func (self Int16Parser) PrototypeName() string {
+	if self.BigEndian {
+		return "ParseInt16BE"
+	}
 	return "ParseInt16"
 }
 
 func (self Int16Parser) Compile(struct_name string, field_name string) string {
 	return fmt.Sprintf(`
 func (self *%[1]s) %[2]s() int16 {
-   return ParseInt16(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
+   return %[3]s(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
 }
-`, struct_name, field_name)
+`, struct_name, field_name, self.PrototypeName())
 }
 func (self Int16Parser) GoType() string {
 	return "int16"
@@ -337,11 +372,11 @@ func (self Int8Parser) Prototype() string {
 func ParseInt8(reader io.ReaderAt, offset int64) int8 {
 	var buf [1]byte
 	data := buf[:]
-    _, err := reader.ReadAt(result, offset)
+    _, err := reader.ReadAt(data, offset)
     if err != nil {
        return 0
     }
-    return int8(result[0])
+    return int8(data[0])
 }
 `
 }
@@ -394,7 +429,11 @@ func ParseArray_%[1]s(profile *%[2]s, reader io.ReaderAt, offset int64, count in
 
 func (self ArrayParser) PrototypeName() string {
 	parser := self.Target.GetParser()
-	return fmt.Sprintf("ParseArray_%s", parser.GoType())
+	name := fmt.Sprintf("ParseArray_%s", parser.GoType())
+	if strings.HasSuffix(parser.PrototypeName(), "BE") {
+		name += "BE"
+	}
+	return name
 }
 
 func (self ArrayParser) Compile(struct_name string, field_name string) string {
@@ -436,11 +475,6 @@ type StructParser struct {
 
 func (self StructParser) Prototype() string {
 	return ""
-	return fmt.Sprintf(`
-func Parse_%[1]s(profile %[2]s, reader io.ReaderAt, offset int) *%[3]s {
-   return profile.%[3]s(reader, offset)
-}
-`, self.Target, self.ProfileName(), self.Target)
 }
 
 func (self StructParser) PrototypeName() string {
@@ -470,6 +504,18 @@ func (self StructParser) Size(value string) string {
 type Pointer struct {
 	BaseParser
 	Target *FieldDefinition
+
+	// Pointers are 8 bytes unless this is 4.
+	PointerSize int  `json:"pointer_size,omitempty"`
+	BigEndian   bool `json:"big_endian,omitempty"`
+}
+
+// The parser used to read the address the pointer points to.
+func (self Pointer) addressParser() Parser {
+	if self.PointerSize == 4 {
+		return &Uint32Parser{BigEndian: self.BigEndian}
+	}
+	return &Uint64Parser{BigEndian: self.BigEndian}
 }
 
 func (self *Pointer) Prototype() string {
@@ -480,28 +526,46 @@ func (self Pointer) PrototypeName() string {
 	return ""
 }
 
+// Pointers to structs are dereferenced by their accessor. Pointers to
+// anything else (e.g. void pointers) just return the address.
+func (self Pointer) pointsToStruct() bool {
+	parser, ok := self.Target.GetParser().(*StructParser)
+	return ok && parser.Target != "Void"
+}
+
 func (self Pointer) Compile(struct_name string, field_name string) string {
 	parser := self.Target.GetParser()
 
+	if !self.pointsToStruct() {
+		return fmt.Sprintf(`
+func (self *%[1]s) %[2]s() uint64 {
+   return uint64(%[3]s(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset))
+}
+`, struct_name, field_name, self.addressParser().PrototypeName())
+	}
+
 	return fmt.Sprintf(`
 func (self *%[1]s) %[2]s() *%[3]s {
-   deref := ParseUint64(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
+   deref := %[4]s(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
    return self.Profile.%[3]s(self.Reader, int64(deref))
 }
-`, struct_name, field_name, parser.GoType())
+`, struct_name, field_name, parser.GoType(), self.addressParser().PrototypeName())
 }
 
 func (self Pointer) GoType() string {
+	if !self.pointsToStruct() {
+		return "uint64"
+	}
 	parser := self.Target.GetParser()
 	return "*" + parser.GoType()
 }
 
 func (self Pointer) Size(value string) string {
-	return "8"
+	return self.addressParser().Size(value)
 }
 
 func (self Pointer) Dependencies() []Parser {
-	return []Parser{&Uint64Parser{}}
+	return []Parser{self.addressParser()}
 }
 
 type BitField struct {
@@ -512,17 +576,7 @@ type BitField struct {
 }
 
 func (self BitField) getParser() Parser {
-	switch self.Target {
-	case "unsigned long long":
-		return &Uint64Parser{}
-	case "unsigned long":
-		return &Uint32Parser{}
-	case "unsigned short":
-		return &Uint16Parser{}
-	case "unsigned char":
-		return &Uint8Parser{}
-	}
-	return &Uint64Parser{}
+	return IntegerParser(self.Target)
 }
 
 func (self *BitField) Prototype() string {
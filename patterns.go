@@ -0,0 +1,214 @@
+package binparsergen
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+/* Struct and field names in the spec may be patterns:
+
+   - /regex/ matches names containing the regex (e.g. /^_MMVAD/).
+   - Globs (e.g. _CM_KEY_*) match the whole name.
+   - Anything else must match the name exactly.
+*/
+
+type namePattern struct {
+	text  string
+	regex *regexp.Regexp
+	glob  bool
+}
+
+func compilePattern(text string) (*namePattern, error) {
+	result := &namePattern{text: text}
+	if len(text) > 1 && strings.HasPrefix(text, "/") && strings.HasSuffix(text, "/") {
+		regex, err := regexp.Compile(text[1 : len(text)-1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %v: %w", text, err)
+		}
+		result.regex = regex
+
+	} else if strings.ContainsAny(text, "*?[") {
+		_, err := path.Match(text, "")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %v: %w", text, err)
+		}
+		result.glob = true
+	}
+
+	return result, nil
+}
+
+func (self *namePattern) Match(name string) bool {
+	if self.regex != nil {
+		return self.regex.MatchString(name)
+	}
+	if self.glob {
+		matched, _ := path.Match(self.text, name)
+		return matched
+	}
+	return self.text == name
+}
+
+func (self *namePattern) isLiteral() bool {
+	return self.regex == nil && !self.glob
+}
+
+// A list of patterns which remembers what each pattern matched.
+type patternList struct {
+	name     string
+	patterns []*namePattern
+	matches  map[string][]string
+
+	// Report literal names which matched nothing.
+	report_literals bool
+}
+
+func newPatternList(name string, patterns []string) (*patternList, error) {
+	result := &patternList{
+		name:    name,
+		matches: make(map[string][]string),
+	}
+	for _, text := range patterns {
+		pattern, err := compilePattern(text)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		result.patterns = append(result.patterns, pattern)
+	}
+	return result, nil
+}
+
+// Match the name against all the patterns. The name is recorded as
+// label in the summary (e.g. struct.field for fields).
+func (self *patternList) Match(name, label string) bool {
+	result := false
+	for _, pattern := range self.patterns {
+		if pattern.Match(name) {
+			if !InString(self.matches[pattern.text], label) {
+				self.matches[pattern.text] = append(self.matches[pattern.text], label)
+			}
+			result = true
+		}
+	}
+	return result
+}
+
+// Report what each glob or regex pattern matched. Literal names
+// which matched nothing are reported even if not verbose since they
+// are likely typos.
+func (self *patternList) Summary(verbose bool) {
+	for _, pattern := range self.patterns {
+		matches := self.matches[pattern.text]
+		if pattern.isLiteral() {
+			if len(matches) == 0 && self.report_literals {
+				Logger.Printf("%v: %v matched nothing", self.name, pattern.text)
+			}
+			continue
+		}
+
+		if !verbose {
+			continue
+		}
+
+		if len(matches) == 0 {
+			Logger.Printf("%v: %v matched nothing", self.name, pattern.text)
+			continue
+		}
+		Logger.Printf("%v: %v matched %v", self.name, pattern.text,
+			strings.Join(matches, ", "))
+	}
+}
+
+// FieldWhiteList and FieldBlackList map struct patterns to field
+// patterns.
+type fieldPatterns struct {
+	structs []*namePattern
+	fields  []*patternList
+}
+
+func newFieldPatterns(name string, lists map[string][]string) (*fieldPatterns, error) {
+	result := &fieldPatterns{}
+	for _, struct_pattern := range SortedKeys(lists) {
+		pattern, err := compilePattern(struct_pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+
+		fields, err := newPatternList(
+			fmt.Sprintf("%v[%v]", name, struct_pattern), lists[struct_pattern])
+		if err != nil {
+			return nil, err
+		}
+
+		result.structs = append(result.structs, pattern)
+		result.fields = append(result.fields, fields)
+	}
+	return result, nil
+}
+
+// Returns if any struct pattern matches the struct, and if so whether
+// one of its field patterns matches the field.
+func (self *fieldPatterns) Match(type_name, field_name string) (pres bool, matched bool) {
+	for idx, pattern := range self.structs {
+		if pattern.Match(type_name) {
+			pres = true
+			if self.fields[idx].Match(field_name, type_name+"."+field_name) {
+				matched = true
+			}
+		}
+	}
+	return pres, matched
+}
+
+func (self *fieldPatterns) Summary(verbose bool) {
+	for _, fields := range self.fields {
+		fields.Summary(verbose)
+	}
+}
+
+// The compiled patterns of a spec.
+type specPatterns struct {
+	structs         *patternList
+	exclude_structs *patternList
+	field_whitelist *fieldPatterns
+	field_blacklist *fieldPatterns
+}
+
+func newSpecPatterns(spec *ConversionSpec) (*specPatterns, error) {
+	structs, err := newPatternList("Structs", spec.Structs)
+	if err != nil {
+		return nil, err
+	}
+	structs.report_literals = true
+
+	exclude_structs, err := newPatternList("ExcludeStructs", spec.ExcludeStructs)
+	if err != nil {
+		return nil, err
+	}
+
+	field_whitelist, err := newFieldPatterns("FieldWhiteList", spec.FieldWhiteList)
+	if err != nil {
+		return nil, err
+	}
+
+	field_blacklist, err := newFieldPatterns("FieldBlackList", spec.FieldBlackList)
+	if err != nil {
+		return nil, err
+	}
+
+	return &specPatterns{
+		structs:         structs,
+		exclude_structs: exclude_structs,
+		field_whitelist: field_whitelist,
+		field_blacklist: field_blacklist,
+	}, nil
+}
+
+func (self *specPatterns) Summary(verbose bool) {
+	self.structs.Summary(verbose)
+	self.exclude_structs.Summary(verbose)
+	self.field_whitelist.Summary(verbose)
+	self.field_blacklist.Summary(verbose)
+}
@@ -0,0 +1,66 @@
+package binparsergen
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestPatterns(t *testing.T) {
+	output := &bytes.Buffer{}
+	defer func(logger *log.Logger) { Logger = logger }(Logger)
+	Logger = log.New(output, "", 0)
+
+	spec := &ConversionSpec{
+		Filename:       "testdata/vtypes.json",
+		Structs:        []string{"/^_G/", "_HEAD*", "_NOPE*", "_LITERAL"},
+		ExcludeStructs: []string{"/MISSING/"},
+		Verbose:        true,
+		FieldWhiteList: map[string][]string{
+			"/GUID/": {"Data[12]"},
+		},
+		FieldBlackList: map[string][]string{
+			"_H*": {"D*", "/^S/"},
+		},
+	}
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, SortedKeys(profile), []string{"_GUID", "_HEADER"})
+
+	guid := profile["_GUID"]
+	assert.DeepEqual(t, SortedKeys(guid.Fields), []string{"Data1", "Data2"})
+
+	header := profile["_HEADER"]
+	for _, field_name := range []string{"Dyn", "Signed", "Small", "SBig", "SLong"} {
+		assert.Assert(t, header.Fields[field_name] == nil, field_name)
+	}
+	assert.Assert(t, header.Fields["Id"] != nil)
+
+	assert.Equal(t, output.String(),
+		"Structs: /^_G/ matched _GUID\n"+
+			"Structs: _HEAD* matched _HEADER\n"+
+			"Structs: _NOPE* matched nothing\n"+
+			"Structs: _LITERAL matched nothing\n"+
+			"ExcludeStructs: /MISSING/ matched nothing\n"+
+			"FieldWhiteList[/GUID/]: Data[12] matched _GUID.Data1, _GUID.Data2\n"+
+			"FieldBlackList[_H*]: D* matched _HEADER.Dyn\n"+
+			"FieldBlackList[_H*]: /^S/ matched _HEADER.Signed, _HEADER.Small, "+
+			"_HEADER.SBig, _HEADER.SLong\n")
+
+	// Without Verbose only literal names which matched nothing are
+	// reported.
+	output.Reset()
+	spec.Verbose = false
+	_, err = ConvertSpec(spec)
+	assert.NilError(t, err)
+	assert.Equal(t, output.String(), "Structs: _LITERAL matched nothing\n")
+
+	_, err = ConvertSpec(&ConversionSpec{
+		Filename: "testdata/vtypes.json",
+		Structs:  []string{"/(/"},
+	})
+	assert.ErrorContains(t, err, "Structs: Invalid pattern /(/")
+}
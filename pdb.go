@@ -0,0 +1,895 @@
+package binparsergen
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+/* Windows debugging symbols are distributed as PDB files. A PDB is a
+   Multi-Stream File (MSF): a small file system made of fixed size
+   blocks, whose directory lists the blocks of each stream. Stream 2
+   is the Type Information (TPI) stream which contains a sequence of
+   CodeView type records, numbered from TypeIndexBegin (usually
+   0x1000). Type indexes below that refer to built in types.
+
+   We read the struct, class, union and enum records from the TPI
+   stream and convert them to vtypes. Anonymous structs are named
+   after the parent struct and field using them, or __unnamed_<index>
+   otherwise. Arrays of char become Strings. Streams larger than the
+   file and types which refer to themselves are errors.
+*/
+
+const (
+	PDB_MAGIC = "Microsoft C/C++ MSF 7.00\r\n\x1aDS\x00\x00\x00"
+
+	PDB_TPI_STREAM = 2
+
+	// CodeView leaf kinds
+	LF_MODIFIER  = 0x1001
+	LF_POINTER   = 0x1002
+	LF_PROCEDURE = 0x1008
+	LF_MFUNCTION = 0x1009
+	LF_FIELDLIST = 0x1203
+	LF_BITFIELD  = 0x1205
+	LF_BCLASS    = 0x1400
+	LF_VBCLASS   = 0x1401
+	LF_IVBCLASS  = 0x1402
+	LF_INDEX     = 0x1404
+	LF_VFUNCTAB  = 0x1409
+	LF_ENUMERATE = 0x1502
+	LF_ARRAY     = 0x1503
+	LF_CLASS     = 0x1504
+	LF_STRUCTURE = 0x1505
+	LF_UNION     = 0x1506
+	LF_ENUM      = 0x1507
+	LF_MEMBER    = 0x150d
+	LF_STMEMBER  = 0x150e
+	LF_METHOD    = 0x150f
+	LF_NESTTYPE  = 0x1510
+	LF_ONEMETHOD = 0x1511
+	LF_INTERFACE = 0x1519
+
+	// Numeric leaves
+	LF_CHAR      = 0x8000
+	LF_SHORT     = 0x8001
+	LF_USHORT    = 0x8002
+	LF_LONG      = 0x8003
+	LF_ULONG     = 0x8004
+	LF_QUADWORD  = 0x8009
+	LF_UQUADWORD = 0x800a
+
+	// Type properties
+	PDB_PROP_FORWARD_REF     = 0x80
+	PDB_PROP_HAS_UNIQUE_NAME = 0x200
+
+	// The deepest nesting of pointers and arrays which is converted.
+	PDB_MAX_DEPTH = 100
+)
+
+// Built in types: Size in bytes and whether they are signed
+// integers. Types with Integer false (void, floats) have no vtype
+// equivalent.
+type pdbSimpleType struct {
+	Size    int64
+	Signed  bool
+	Integer bool
+}
+
+var pdbSimpleTypes = map[uint32]pdbSimpleType{
+	0x03: {0, false, false}, // void
+	0x08: {4, true, true},   // HRESULT
+	0x10: {1, true, true},   // signed char
+	0x20: {1, false, true},  // unsigned char
+	0x68: {1, true, true},   // int8
+	0x69: {1, false, true},  // uint8
+	0x70: {1, true, true},   // char
+	0x71: {2, false, true},  // wchar_t
+	0x7a: {2, false, true},  // char16_t
+	0x7b: {4, false, true},  // char32_t
+	0x11: {2, true, true},   // short
+	0x21: {2, false, true},  // unsigned short
+	0x72: {2, true, true},   // int16
+	0x73: {2, false, true},  // uint16
+	0x12: {4, true, true},   // long
+	0x22: {4, false, true},  // unsigned long
+	0x74: {4, true, true},   // int32
+	0x75: {4, false, true},  // uint32
+	0x13: {8, true, true},   // long long
+	0x23: {8, false, true},  // unsigned long long
+	0x76: {8, true, true},   // int64
+	0x77: {8, false, true},  // uint64
+	0x30: {1, false, true},  // bool
+	0x31: {2, false, true},
+	0x32: {4, false, true},
+	0x33: {8, false, true},
+	0x46: {2, false, false}, // float16
+	0x40: {4, false, false}, // float
+	0x41: {8, false, false}, // double
+	0x42: {10, false, false},
+}
+
+const pdbSimpleChar = 0x70
+
+type pdbMember struct {
+	Name   string
+	Type   uint32
+	Offset int64
+}
+
+type pdbEnumerate struct {
+	Name  string
+	Value int64
+}
+
+// A decoded type record. Only the fields relevant to the Kind are
+// set.
+type pdbType struct {
+	Kind uint16
+
+	Name       string
+	UniqueName string
+	Property   uint16
+	Size       int64
+
+	// The field list of structs, unions and enums.
+	Field uint32
+
+	// The target of pointers, modifiers, arrays and bitfields, or
+	// the underlying type of enums.
+	Type uint32
+
+	PointerSize int64
+	BitLength   int64
+	BitPosition int64
+
+	// LF_FIELDLIST records. Long field lists are continued in
+	// another record.
+	Members      []pdbMember
+	Enumerates   []pdbEnumerate
+	Continuation uint32
+}
+
+func (self *pdbType) isRecord() bool {
+	switch self.Kind {
+	case LF_CLASS, LF_STRUCTURE, LF_UNION, LF_INTERFACE:
+		return true
+	}
+	return false
+}
+
+func (self *pdbType) key() string {
+	if self.UniqueName != "" {
+		return self.UniqueName
+	}
+	return self.Name
+}
+
+func pdbIsAnonymous(name string) bool {
+	return name == "" || name == "<anonymous-tag>" ||
+		strings.HasPrefix(name, "<unnamed-") ||
+		strings.HasPrefix(name, "__unnamed")
+}
+
+// A cursor over little endian record data. Reading past the end sets
+// err and returns zero values.
+type pdbCursor struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (self *pdbCursor) read(size int) []byte {
+	if self.err != nil || self.pos < 0 || self.pos+size > len(self.data) {
+		self.err = errors.New("record truncated")
+		return make([]byte, size)
+	}
+	result := self.data[self.pos : self.pos+size]
+	self.pos += size
+	return result
+}
+
+func (self *pdbCursor) u8() uint8 {
+	return self.read(1)[0]
+}
+
+func (self *pdbCursor) u16() uint16 {
+	return binary.LittleEndian.Uint16(self.read(2))
+}
+
+func (self *pdbCursor) u32() uint32 {
+	return binary.LittleEndian.Uint32(self.read(4))
+}
+
+func (self *pdbCursor) str() string {
+	if self.err == nil && (self.pos < 0 || self.pos > len(self.data)) {
+		self.err = errors.New("record truncated")
+	}
+	if self.err != nil {
+		return ""
+	}
+	end := self.pos
+	for end < len(self.data) && self.data[end] != 0 {
+		end++
+	}
+	result := string(self.data[self.pos:end])
+	self.pos = end + 1
+	return result
+}
+
+// Numeric leaves encode small values directly and larger values with
+// a type prefix.
+func (self *pdbCursor) numeric() int64 {
+	value := self.u16()
+	if value < LF_CHAR {
+		return int64(value)
+	}
+
+	switch value {
+	case LF_CHAR:
+		return int64(int8(self.u8()))
+	case LF_SHORT:
+		return int64(int16(self.u16()))
+	case LF_USHORT:
+		return int64(self.u16())
+	case LF_LONG:
+		return int64(int32(self.u32()))
+	case LF_ULONG:
+		return int64(self.u32())
+	case LF_QUADWORD, LF_UQUADWORD:
+		return int64(binary.LittleEndian.Uint64(self.read(8)))
+	}
+
+	if self.err == nil {
+		self.err = fmt.Errorf("unsupported numeric leaf %#x", value)
+	}
+	return 0
+}
+
+// Read all the streams from the MSF container.
+func pdbReadStreams(data []byte) ([][]byte, error) {
+	if len(data) < 56 || string(data[:32]) != PDB_MAGIC {
+		return nil, errors.New("Not a PDB file (MSF 7.00)")
+	}
+
+	block_size := int64(binary.LittleEndian.Uint32(data[32:]))
+	num_blocks := int64(binary.LittleEndian.Uint32(data[40:]))
+	directory_size := int64(binary.LittleEndian.Uint32(data[44:]))
+	block_map := int64(binary.LittleEndian.Uint32(data[52:]))
+	if block_size == 0 {
+		return nil, errors.New("Invalid PDB block size")
+	}
+
+	// Streams never hold more data than the file. Sizes are checked
+	// against this before allocating anything.
+	max_size := int64(len(data))
+	if num_blocks*block_size < max_size {
+		max_size = num_blocks * block_size
+	}
+	if directory_size > max_size {
+		return nil, fmt.Errorf("PDB directory size %d is too large", directory_size)
+	}
+
+	read_blocks := func(blocks []uint32, size int64) ([]byte, error) {
+		result := make([]byte, 0, size)
+		for _, block := range blocks {
+			start := int64(block) * block_size
+			if start+block_size > int64(len(data)) {
+				return nil, fmt.Errorf("PDB block %d out of range", block)
+			}
+			result = append(result, data[start:start+block_size]...)
+		}
+		if int64(len(result)) < size {
+			return nil, errors.New("PDB stream truncated")
+		}
+		return result[:size], nil
+	}
+
+	// The block map lists the blocks of the directory.
+	block_count := (directory_size + block_size - 1) / block_size
+	cursor := &pdbCursor{data: data, pos: int(block_map * block_size)}
+	directory_blocks := []uint32{}
+	for i := int64(0); i < block_count; i++ {
+		directory_blocks = append(directory_blocks, cursor.u32())
+	}
+	if cursor.err != nil {
+		return nil, errors.New("PDB block map out of range")
+	}
+
+	directory, err := read_blocks(directory_blocks, directory_size)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor = &pdbCursor{data: directory}
+	stream_count := int64(cursor.u32())
+	if 4*stream_count > int64(len(directory)) {
+		return nil, fmt.Errorf("PDB stream count %d is too large", stream_count)
+	}
+
+	sizes := make([]int64, stream_count)
+	total := int64(0)
+	for i := range sizes {
+		size := cursor.u32()
+		if size != 0xffffffff {
+			sizes[i] = int64(size)
+		}
+		total += sizes[i]
+		if sizes[i] > max_size || total > max_size {
+			return nil, fmt.Errorf("PDB stream %d size %d is too large", i, size)
+		}
+	}
+
+	result := [][]byte{}
+	for _, size := range sizes {
+		blocks := []uint32{}
+		for i := int64(0); i < (size+block_size-1)/block_size; i++ {
+			blocks = append(blocks, cursor.u32())
+		}
+		if cursor.err != nil {
+			return nil, errors.New("PDB directory truncated")
+		}
+
+		stream, err := read_blocks(blocks, size)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, stream)
+	}
+
+	return result, nil
+}
+
+type pdbConverter struct {
+	begin uint32
+	types map[uint32]*pdbType
+
+	pointer_size int64
+
+	// Full definitions of forward referenced records.
+	definitions map[string]uint32
+
+	// The vtype name of each converted record.
+	names map[uint32]string
+
+	// The types being converted, to detect cycles.
+	converting map[uint32]bool
+}
+
+func (self *pdbConverter) parseTPI(data []byte) error {
+	cursor := &pdbCursor{data: data}
+	cursor.u32() // Version
+	header_size := cursor.u32()
+	self.begin = cursor.u32()
+	end := cursor.u32()
+	record_bytes := cursor.u32()
+	if cursor.err != nil {
+		return errors.New("TPI stream truncated")
+	}
+
+	cursor.pos = int(header_size)
+	if int(header_size)+int(record_bytes) < len(data) {
+		data = data[:int(header_size)+int(record_bytes)]
+		cursor.data = data
+	}
+
+	for index := self.begin; index < end && cursor.pos < len(data); index++ {
+		length := int(cursor.u16())
+		record := &pdbCursor{data: cursor.read(length)}
+		if cursor.err != nil {
+			return fmt.Errorf("Type record %#x truncated", index)
+		}
+
+		parsed := self.parseRecord(record)
+		if record.err != nil {
+			return fmt.Errorf("Type record %#x: %v", index, record.err)
+		}
+		self.types[index] = parsed
+	}
+
+	return nil
+}
+
+func (self *pdbConverter) parseRecord(cursor *pdbCursor) *pdbType {
+	result := &pdbType{Kind: cursor.u16()}
+
+	switch result.Kind {
+	case LF_MODIFIER:
+		result.Type = cursor.u32()
+
+	case LF_POINTER:
+		result.Type = cursor.u32()
+		result.PointerSize = int64(cursor.u32()>>13) & 0x3f
+		if self.pointer_size == 0 {
+			self.pointer_size = result.PointerSize
+		}
+
+	case LF_ARRAY:
+		result.Type = cursor.u32()
+		cursor.u32() // Index type
+		result.Size = cursor.numeric()
+		result.Name = cursor.str()
+
+	case LF_CLASS, LF_STRUCTURE, LF_INTERFACE, LF_UNION:
+		cursor.u16() // Member count
+		result.Property = cursor.u16()
+		result.Field = cursor.u32()
+		if result.Kind != LF_UNION {
+			cursor.u32() // Derived from
+			cursor.u32() // VTable shape
+		}
+		result.Size = cursor.numeric()
+		result.Name = cursor.str()
+		if result.Property&PDB_PROP_HAS_UNIQUE_NAME != 0 {
+			result.UniqueName = cursor.str()
+		}
+
+	case LF_ENUM:
+		cursor.u16() // Member count
+		result.Property = cursor.u16()
+		result.Type = cursor.u32()
+		result.Field = cursor.u32()
+		result.Name = cursor.str()
+
+	case LF_BITFIELD:
+		result.Type = cursor.u32()
+		result.BitLength = int64(cursor.u8())
+		result.BitPosition = int64(cursor.u8())
+
+	case LF_FIELDLIST:
+		self.parseFieldList(cursor, result)
+	}
+
+	return result
+}
+
+func (self *pdbConverter) parseFieldList(cursor *pdbCursor, result *pdbType) {
+	for cursor.err == nil && cursor.pos < len(cursor.data) {
+		// Sub records are padded to 4 bytes with LF_PAD bytes
+		// which encode the number of bytes to skip.
+		if pad := cursor.data[cursor.pos]; pad >= 0xf0 {
+			cursor.pos += int(pad & 0x0f)
+			continue
+		}
+
+		switch cursor.u16() {
+		case LF_MEMBER:
+			cursor.u16() // Attributes
+			member := pdbMember{Type: cursor.u32()}
+			member.Offset = cursor.numeric()
+			member.Name = cursor.str()
+			result.Members = append(result.Members, member)
+
+		case LF_ENUMERATE:
+			cursor.u16()
+			value := cursor.numeric()
+			result.Enumerates = append(result.Enumerates, pdbEnumerate{
+				Value: value, Name: cursor.str()})
+
+		case LF_BCLASS:
+			cursor.u16()
+			cursor.u32()
+			cursor.numeric()
+
+		case LF_VBCLASS, LF_IVBCLASS:
+			cursor.u16()
+			cursor.u32()
+			cursor.u32()
+			cursor.numeric()
+			cursor.numeric()
+
+		case LF_INDEX:
+			cursor.u16()
+			result.Continuation = cursor.u32()
+
+		case LF_STMEMBER:
+			cursor.u16()
+			cursor.u32()
+			cursor.str()
+
+		case LF_METHOD:
+			cursor.u16()
+			cursor.u32()
+			cursor.str()
+
+		case LF_ONEMETHOD:
+			attributes := cursor.u16()
+			cursor.u32()
+
+			// Introducing virtual methods have a vtable offset.
+			if property := (attributes >> 2) & 7; property == 4 || property == 6 {
+				cursor.u32()
+			}
+			cursor.str()
+
+		case LF_NESTTYPE:
+			cursor.u16()
+			cursor.u32()
+			cursor.str()
+
+		case LF_VFUNCTAB:
+			cursor.u16()
+			cursor.u32()
+
+		default:
+			// We can not find the next sub record.
+			return
+		}
+	}
+}
+
+// Follow a field list and its continuations.
+func (self *pdbConverter) fieldList(index uint32) *pdbType {
+	result := &pdbType{}
+	seen := make(map[uint32]bool)
+	for index != 0 && !seen[index] {
+		seen[index] = true
+		field_list, pres := self.types[index]
+		if !pres || field_list.Kind != LF_FIELDLIST {
+			break
+		}
+		result.Members = append(result.Members, field_list.Members...)
+		result.Enumerates = append(result.Enumerates, field_list.Enumerates...)
+		index = field_list.Continuation
+	}
+	return result
+}
+
+// Strip modifiers and resolve forward references to the full
+// definition.
+func (self *pdbConverter) resolve(index uint32) (uint32, *pdbType, error) {
+	seen := make(map[uint32]bool)
+	for !seen[index] {
+		seen[index] = true
+		pdb_type, pres := self.types[index]
+		if !pres {
+			return index, nil, nil
+		}
+
+		switch {
+		case pdb_type.Kind == LF_MODIFIER:
+			index = pdb_type.Type
+			continue
+
+		case pdb_type.isRecord() && pdb_type.Property&PDB_PROP_FORWARD_REF != 0:
+			definition, pres := self.definitions[pdb_type.key()]
+			if pres {
+				return definition, self.types[definition], nil
+			}
+		}
+		return index, pdb_type, nil
+	}
+	return 0, nil, fmt.Errorf("Type %#x: modifier cycle", index)
+}
+
+func (self *pdbConverter) simpleType(index uint32) (pdbSimpleType, bool) {
+	simple, pres := pdbSimpleTypes[index&0xff]
+	return simple, pres
+}
+
+// Assign vtype names to all complete records.
+func (self *pdbConverter) assignNames(end uint32) ([]uint32, error) {
+	used := make(map[string]bool)
+	queue := []uint32{}
+
+	for index := self.begin; index < end; index++ {
+		pdb_type, pres := self.types[index]
+		if !pres || !pdb_type.isRecord() ||
+			pdb_type.Property&PDB_PROP_FORWARD_REF != 0 {
+			continue
+		}
+
+		key := pdb_type.key()
+		if _, pres := self.definitions[key]; !pres {
+			self.definitions[key] = index
+		}
+
+		if pdbIsAnonymous(pdb_type.Name) || used[pdb_type.Name] {
+			continue
+		}
+		used[pdb_type.Name] = true
+		self.names[index] = pdb_type.Name
+		queue = append(queue, index)
+	}
+
+	// Name anonymous records after the field they define.
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+
+		for _, member := range self.fieldList(self.types[parent].Field).Members {
+			index, member_type, err := self.resolve(member.Type)
+			if err != nil {
+				return nil, fmt.Errorf("%v.%v: %w",
+					self.names[parent], member.Name, err)
+			}
+			if member_type == nil || !member_type.isRecord() ||
+				!pdbIsAnonymous(member_type.Name) {
+				continue
+			}
+			if _, pres := self.names[index]; pres {
+				continue
+			}
+
+			self.names[index] = self.names[parent] + "_" + member.Name
+			queue = append(queue, index)
+		}
+	}
+
+	result := []uint32{}
+	for index := self.begin; index < end; index++ {
+		pdb_type, pres := self.types[index]
+		if !pres || !pdb_type.isRecord() ||
+			pdb_type.Property&PDB_PROP_FORWARD_REF != 0 {
+			continue
+		}
+
+		if _, pres := self.names[index]; !pres {
+			if !pdbIsAnonymous(pdb_type.Name) {
+				// A duplicate definition.
+				continue
+			}
+			self.names[index] = fmt.Sprintf("__unnamed_%x", index)
+		}
+		result = append(result, index)
+	}
+
+	return result, nil
+}
+
+func (self *pdbConverter) typeSize(index uint32) (int64, error) {
+	seen := make(map[uint32]bool)
+	for !seen[index] {
+		seen[index] = true
+		if index < self.begin {
+			if (index>>8)&0xf != 0 {
+				return self.simplePointerSize(index), nil
+			}
+			simple, _ := self.simpleType(index)
+			return simple.Size, nil
+		}
+
+		resolved, pdb_type, err := self.resolve(index)
+		if err != nil {
+			return 0, err
+		}
+		if resolved < self.begin {
+			index = resolved
+			continue
+		}
+		if pdb_type == nil {
+			return 0, nil
+		}
+
+		switch pdb_type.Kind {
+		case LF_POINTER:
+			if pdb_type.PointerSize == 0 {
+				return self.pointer_size, nil
+			}
+			return pdb_type.PointerSize, nil
+		case LF_ENUM:
+			index = pdb_type.Type
+			continue
+		}
+		return pdb_type.Size, nil
+	}
+	return 0, fmt.Errorf("Type %#x: enum cycle", index)
+}
+
+func (self *pdbConverter) simplePointerSize(index uint32) int64 {
+	switch (index >> 8) & 0xf {
+	case 1, 2, 3:
+		return 2
+	case 6:
+		return 8
+	}
+	return 4
+}
+
+func (self *pdbConverter) integerName(index uint32) (string, error) {
+	index, pdb_type, err := self.resolve(index)
+	if err != nil {
+		return "", err
+	}
+	if pdb_type != nil && pdb_type.Kind == LF_ENUM {
+		index = pdb_type.Type
+	}
+
+	simple, pres := self.simpleType(index)
+	if !pres || !simple.Integer || (index>>8)&0xf != 0 {
+		return "", nil
+	}
+	return vtypeIntegerName(simple.Size, simple.Signed, false), nil
+}
+
+func (self *pdbConverter) convertType(index uint32) ([]interface{}, error) {
+	if index < self.begin {
+		name, err := self.integerName(index)
+		if err != nil {
+			return nil, err
+		}
+		if (index>>8)&0xf != 0 {
+			target := vtypeType("Void", nil)
+			if name != "" {
+				target = vtypeType(name, nil)
+			}
+			return vtypeType("Pointer", vtypeTarget(
+				make(map[string]interface{}), target)), nil
+		}
+		if name == "" {
+			return nil, nil
+		}
+		return vtypeType(name, nil), nil
+	}
+
+	// Modifiers may refer to built in types.
+	index, pdb_type, err := self.resolve(index)
+	if err != nil {
+		return nil, err
+	}
+	if index < self.begin {
+		return self.convertType(index)
+	}
+	if pdb_type == nil {
+		return nil, fmt.Errorf("Unknown type index %#x", index)
+	}
+
+	if self.converting[index] {
+		return nil, fmt.Errorf("Type %#x refers to itself", index)
+	}
+	if len(self.converting) >= PDB_MAX_DEPTH {
+		return nil, fmt.Errorf("Type %#x is nested too deeply", index)
+	}
+	self.converting[index] = true
+	defer delete(self.converting, index)
+
+	switch pdb_type.Kind {
+	case LF_POINTER:
+		target, err := self.convertType(pdb_type.Type)
+		if err != nil {
+			return nil, err
+		}
+		if target == nil {
+			target = vtypeType("Void", nil)
+		}
+		return vtypeType("Pointer", vtypeTarget(
+			make(map[string]interface{}), target)), nil
+
+	case LF_ARRAY:
+		element_size, err := self.typeSize(pdb_type.Type)
+		if err != nil {
+			return nil, err
+		}
+		count := int64(0)
+		if element_size > 0 {
+			count = pdb_type.Size / element_size
+		}
+
+		if element, _, _ := self.resolve(pdb_type.Type); element == pdbSimpleChar {
+			return vtypeType("String", map[string]interface{}{
+				"length": count,
+			}), nil
+		}
+
+		target, err := self.convertType(pdb_type.Type)
+		if err != nil || target == nil {
+			return nil, err
+		}
+		return vtypeType("Array", vtypeTarget(map[string]interface{}{
+			"count": count,
+		}, target)), nil
+
+	case LF_CLASS, LF_STRUCTURE, LF_INTERFACE, LF_UNION:
+		name, pres := self.names[index]
+		if !pres {
+			name = pdb_type.Name
+		}
+		if pdbIsAnonymous(name) {
+			return nil, nil
+		}
+		return vtypeType(name, nil), nil
+
+	case LF_ENUM:
+		choices := make(map[string]interface{})
+		for _, enumerate := range self.fieldList(pdb_type.Field).Enumerates {
+			choices[fmt.Sprintf("%d", enumerate.Value)] = enumerate.Name
+		}
+		target, err := self.integerName(pdb_type.Type)
+		if err != nil {
+			return nil, err
+		}
+		return vtypeType("Enumeration", map[string]interface{}{
+			"target":  target,
+			"choices": choices,
+		}), nil
+	}
+
+	// Functions have no vtype equivalent.
+	return nil, nil
+}
+
+func (self *pdbConverter) convertFields(name string, pdb_type *pdbType) (
+	*ordereddict.Dict, error) {
+	fields := ordereddict.NewDict()
+	for _, member := range self.fieldList(pdb_type.Field).Members {
+		bitfield, pres := self.types[member.Type]
+		if pres && bitfield.Kind == LF_BITFIELD {
+			target, err := self.integerName(bitfield.Type)
+			if err != nil {
+				return nil, fmt.Errorf("%v.%v: %w", name, member.Name, err)
+			}
+			if target == "" {
+				continue
+			}
+			fields.Set(member.Name, vtypeField(member.Offset, vtypeType(
+				"BitField", map[string]interface{}{
+					"start_bit": bitfield.BitPosition,
+					"end_bit":   bitfield.BitPosition + bitfield.BitLength,
+					"target":    target,
+				})))
+			continue
+		}
+
+		definition, err := self.convertType(member.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%v.%v: %w", name, member.Name, err)
+		}
+
+		// Unsupported types (e.g. floats) are skipped.
+		if definition == nil {
+			continue
+		}
+		fields.Set(member.Name, vtypeField(member.Offset, definition))
+	}
+
+	return fields, nil
+}
+
+// Convert the type information in a PDB file into vtype definitions.
+func ConvertPDB(data []byte, spec *ConversionSpec) (*ordereddict.Dict, *ProfileInfo, error) {
+	streams, err := pdbReadStreams(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(streams) <= PDB_TPI_STREAM {
+		return nil, nil, errors.New("PDB has no TPI stream")
+	}
+
+	self := &pdbConverter{
+		types:       make(map[uint32]*pdbType),
+		definitions: make(map[string]uint32),
+		names:       make(map[uint32]string),
+		converting:  make(map[uint32]bool),
+	}
+
+	err = self.parseTPI(streams[PDB_TPI_STREAM])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if self.pointer_size == 0 {
+		self.pointer_size = 8
+	}
+
+	end := self.begin + uint32(len(self.types))
+	result := ordereddict.NewDict()
+	indexes, err := self.assignNames(end)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, index := range indexes {
+		name := self.names[index]
+		pdb_type := self.types[index]
+		fields, err := self.convertFields(name, pdb_type)
+		if err != nil {
+			return nil, nil, err
+		}
+		result.Set(name, vtypeStruct(pdb_type.Size, fields))
+	}
+
+	return result, &ProfileInfo{PointerSize: int(self.pointer_size)}, nil
+}
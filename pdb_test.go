@@ -0,0 +1,246 @@
+package binparsergen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+// Helpers to build a minimal PDB in memory.
+
+type testLeaf struct {
+	bytes.Buffer
+}
+
+func newTestLeaf(kind uint16) *testLeaf {
+	result := &testLeaf{}
+	return result.u16(kind)
+}
+
+func (self *testLeaf) u8(value uint8) *testLeaf {
+	self.WriteByte(value)
+	return self
+}
+
+func (self *testLeaf) u16(value uint16) *testLeaf {
+	binary.Write(self, binary.LittleEndian, value)
+	return self
+}
+
+func (self *testLeaf) u32(value uint32) *testLeaf {
+	binary.Write(self, binary.LittleEndian, value)
+	return self
+}
+
+func (self *testLeaf) str(value string) *testLeaf {
+	self.WriteString(value)
+	return self.u8(0)
+}
+
+func (self *testLeaf) numeric(value uint32) *testLeaf {
+	if value < LF_CHAR {
+		return self.u16(uint16(value))
+	}
+	return self.u16(LF_ULONG).u32(value)
+}
+
+// Field list sub records are padded with LF_PAD bytes.
+func (self *testLeaf) pad() *testLeaf {
+	for self.Len()%4 != 0 {
+		self.u8(0xf0 | uint8(4-self.Len()%4))
+	}
+	return self
+}
+
+func (self *testLeaf) member(name string, type_index uint32, offset uint32) *testLeaf {
+	return self.u16(LF_MEMBER).u16(3).u32(type_index).numeric(offset).str(name).pad()
+}
+
+func (self *testLeaf) enumerate(name string, value uint32) *testLeaf {
+	return self.u16(LF_ENUMERATE).u16(3).numeric(value).str(name).pad()
+}
+
+func writeTestPDB(records []*testLeaf) []byte {
+	block_size := 512
+
+	tpi := &testLeaf{}
+	for _, record := range records {
+		tpi.u16(uint16(record.Len())).Write(record.Bytes())
+	}
+	header := &testLeaf{}
+	header.u32(20040203).u32(56).u32(0x1000).
+		u32(0x1000 + uint32(len(records))).u32(uint32(tpi.Len()))
+	header.Write(make([]byte, 56-header.Len()))
+	tpi_stream := append(header.Bytes(), tpi.Bytes()...)
+
+	// Block 0 is the superblock and blocks 1 and 2 the free block
+	// maps.
+	blocks := [][]byte{nil, make([]byte, block_size), make([]byte, block_size)}
+	add := func(data []byte) []uint32 {
+		result := []uint32{}
+		for len(data) > 0 {
+			block := make([]byte, block_size)
+			data = data[copy(block, data):]
+			result = append(result, uint32(len(blocks)))
+			blocks = append(blocks, block)
+		}
+		return result
+	}
+
+	directory := &testLeaf{}
+	directory.u32(3).u32(0).u32(0).u32(uint32(len(tpi_stream)))
+	for _, block := range add(tpi_stream) {
+		directory.u32(block)
+	}
+
+	block_map := &testLeaf{}
+	for _, block := range add(directory.Bytes()) {
+		block_map.u32(block)
+	}
+	block_map_address := add(block_map.Bytes())[0]
+
+	superblock := &testLeaf{}
+	superblock.WriteString(PDB_MAGIC)
+	superblock.u32(uint32(block_size)).u32(1).u32(uint32(len(blocks))).
+		u32(uint32(directory.Len())).u32(0).u32(block_map_address)
+	blocks[0] = make([]byte, block_size)
+	copy(blocks[0], superblock.Bytes())
+
+	return bytes.Join(blocks, nil)
+}
+
+func TestConvertPDB(t *testing.T) {
+	records := []*testLeaf{
+		// 0x1000: enum STATE
+		newTestLeaf(LF_FIELDLIST).enumerate("STATE_IDLE", 0).
+			enumerate("STATE_RUNNING", 2).enumerate("STATE_BIG", 0x12345),
+		newTestLeaf(LF_ENUM).u16(3).u16(0).u32(0x74).u32(0x1000).str("STATE"),
+
+		// 0x1002: Forward reference to _LIST_ENTRY and pointer to it.
+		newTestLeaf(LF_STRUCTURE).u16(0).u16(PDB_PROP_FORWARD_REF | PDB_PROP_HAS_UNIQUE_NAME).
+			u32(0).u32(0).u32(0).numeric(0).str("_LIST_ENTRY").str(".?AU_LIST_ENTRY@@"),
+		newTestLeaf(LF_POINTER).u32(0x1002).u32(8<<13 | 0x0c),
+		newTestLeaf(LF_FIELDLIST).member("Flink", 0x1003, 0).member("Blink", 0x1003, 8),
+		newTestLeaf(LF_STRUCTURE).u16(2).u16(PDB_PROP_HAS_UNIQUE_NAME).
+			u32(0x1004).u32(0).u32(0).numeric(16).str("_LIST_ENTRY").str(".?AU_LIST_ENTRY@@"),
+
+		// 0x1006: Bitfields
+		newTestLeaf(LF_BITFIELD).u32(0x75).u8(3).u8(0),
+		newTestLeaf(LF_BITFIELD).u32(0x75).u8(7).u8(3),
+
+		// 0x1008: char[16] and const uint32[2]
+		newTestLeaf(LF_ARRAY).u32(0x70).u32(0x23).numeric(16).str(""),
+		newTestLeaf(LF_MODIFIER).u32(0x75).u16(1),
+		newTestLeaf(LF_ARRAY).u32(0x1009).u32(0x23).numeric(8).str(""),
+
+		// 0x100b: An anonymous struct.
+		newTestLeaf(LF_FIELDLIST).member("Count", 0x75, 0).member("Data", 0x603, 8),
+		newTestLeaf(LF_STRUCTURE).u16(2).u16(0).u32(0x100b).u32(0).u32(0).
+			numeric(16).str("<unnamed-tag>"),
+
+		// 0x100d: The continuation of _TASK's field list.
+		newTestLeaf(LF_FIELDLIST).member("Stats", 0x100c, 56).member("Load", 0x41, 72).
+			member("Handler", 0x100f, 80).member("Name", 0x670, 88).
+			member("Far", 0x77, 0x10000),
+		newTestLeaf(LF_PROCEDURE).u32(0x74).u8(0).u8(0).u16(0).u32(0),
+		newTestLeaf(LF_POINTER).u32(0x100e).u32(8<<13 | 0x0c),
+
+		// 0x1010: _TASK
+		newTestLeaf(LF_FIELDLIST).member("Pid", 0x74, 0).member("State", 0x1001, 4).
+			member("Flags", 0x1006, 8).member("Prio", 0x1007, 8).
+			member("Tasks", 0x1002, 16).member("Comm", 0x1008, 32).
+			member("Counts", 0x100a, 48).
+			u16(LF_ONEMETHOD).u16(4 << 2).u32(0x100e).u32(0).str("Run").pad().
+			u16(LF_INDEX).u16(0).u32(0x100d),
+		newTestLeaf(LF_STRUCTURE).u16(12).u16(0).u32(0x1010).u32(0).u32(0).
+			numeric(96).str("_TASK"),
+	}
+
+	filename := filepath.Join(t.TempDir(), "test.pdb")
+	err := ioutil.WriteFile(filename, writeTestPDB(records), 0644)
+	assert.NilError(t, err)
+
+	spec := &ConversionSpec{
+		Filename: filename,
+		Format:   FORMAT_PDB,
+		Structs:  []string{"_LIST_ENTRY", "_TASK", "_TASK_Stats"},
+	}
+
+	vtypes, _, err := ConvertPDB(writeTestPDB(records), &ConversionSpec{})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, vtypes.Keys(), []string{"_LIST_ENTRY", "_TASK_Stats", "_TASK"})
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+	definitions, err := LoadDefinitions(spec)
+	assert.NilError(t, err)
+	assert.Equal(t, definitions.PointerSize, 8)
+
+	task := profile["_TASK"]
+	assert.Equal(t, task.Size, uint32(96))
+	assert.DeepEqual(t, task.fields, []string{
+		"Pid", "State", "Flags", "Prio", "Tasks", "Comm", "Counts",
+		"Stats", "Handler", "Name", "Far"})
+
+	assert.Equal(t, task.Fields["State"].Enumeration.Target, "long")
+	assert.Equal(t, task.Fields["State"].Enumeration.Choices[0x12345], "STATE_BIG")
+	assert.Equal(t, task.Fields["Prio"].BitField.StartBit, uint64(3))
+	assert.Equal(t, task.Fields["Prio"].BitField.EndBit, uint64(10))
+	assert.Equal(t, task.Fields["Tasks"].StructParser.Target, "LIST_ENTRY")
+	assert.Equal(t, task.Fields["Comm"].StringParser.Length, uint64(16))
+	assert.Equal(t, task.Fields["Counts"].ArrayParser.Count, 2)
+	assert.Equal(t, task.Fields["Stats"].StructParser.Target, "TASK_Stats")
+	assert.Equal(t, task.Fields["Handler"].Pointer.pointsToStruct(), false)
+	assert.Equal(t, task.Fields["Far"].Offset, int64(0x10000))
+
+	list_entry := profile["_LIST_ENTRY"]
+	assert.Equal(t, list_entry.Fields["Blink"].Pointer.Target.StructParser.Target,
+		"LIST_ENTRY")
+}
+
+func TestConvertPDBCycles(t *testing.T) {
+	for _, test := range []struct {
+		record *testLeaf
+		err    string
+	}{
+		{newTestLeaf(LF_MODIFIER).u32(0x1000).u16(1), "_S.F: Type 0x1000: modifier cycle"},
+		{newTestLeaf(LF_POINTER).u32(0x1000).u32(8<<13 | 0x0c), "_S.F: Type 0x1000 refers to itself"},
+		{newTestLeaf(LF_ARRAY).u32(0x1000).u32(0x23).numeric(8).str(""), "_S.F: Type 0x1000 refers to itself"},
+	} {
+		records := []*testLeaf{
+			test.record,
+			newTestLeaf(LF_FIELDLIST).member("F", 0x1000, 0),
+			newTestLeaf(LF_STRUCTURE).u16(1).u16(0).u32(0x1001).u32(0).u32(0).
+				numeric(8).str("_S"),
+		}
+		_, _, err := ConvertPDB(writeTestPDB(records), &ConversionSpec{})
+		assert.ErrorContains(t, err, test.err)
+	}
+}
+
+func TestConvertPDBStreamSizes(t *testing.T) {
+	records := []*testLeaf{newTestLeaf(LF_MODIFIER).u32(0x75).u16(1)}
+	directory := func(data []byte) []byte {
+		block_map := binary.LittleEndian.Uint32(data[52:]) * 512
+		return data[binary.LittleEndian.Uint32(data[block_map:])*512:]
+	}
+
+	data := writeTestPDB(records)
+	binary.LittleEndian.PutUint32(data[44:], 0xfffffff0)
+	_, _, err := ConvertPDB(data, &ConversionSpec{})
+	assert.ErrorContains(t, err, "PDB directory size 4294967280 is too large")
+
+	data = writeTestPDB(records)
+	binary.LittleEndian.PutUint32(directory(data), 0x7fffffff)
+	_, _, err = ConvertPDB(data, &ConversionSpec{})
+	assert.ErrorContains(t, err, "PDB stream count 2147483647 is too large")
+
+	data = writeTestPDB(records)
+	binary.LittleEndian.PutUint32(directory(data)[12:], 0x7fffffff)
+	_, _, err = ConvertPDB(data, &ConversionSpec{})
+	assert.ErrorContains(t, err, "PDB stream 2 size 2147483647 is too large")
+}
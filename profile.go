@@ -2,6 +2,7 @@ package binparsergen
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -19,7 +20,9 @@ import (
 
    The profile is initialized with the offsets defined in the current
    profile json file but may be overridden if a more up to date file
-   is available.
+   is available. When the spec lists several Versions, the offsets and
+   struct sizes of each version are generated and selected with
+   New<Profile>ForVersion().
 
    Profiles also contain methods for all their structs. For example
    this method will be generated to parse a HCELL struct at the
@@ -36,12 +39,22 @@ import (
 func GenerateProfileCode(
 	profile_name string,
 	profile map[string]*StructDefinition) string {
+	return GenerateSpecProfileCode(&ConversionSpec{Profile: profile_name}, profile)
+}
+
+// Generates the profile with the spec's versions and constants.
+func GenerateSpecProfileCode(
+	spec *ConversionSpec,
+	profile map[string]*StructDefinition) string {
+	profile_name := spec.Profile
+	versions := profileVersions(spec, profile)
+
 	result := fmt.Sprintf("type %s struct {\n", profile_name)
-	init := []string{}
 	factories := ""
 	for _, struct_name := range SortedKeys(profile) {
 		struct_def := profile[struct_name]
-		struct_name = NormalizeName(struct_name)
+		normalized_name := NormalizeName(struct_name)
+		result += fmt.Sprintf("    Size_%s int64\n", normalized_name)
 		for _, field_name := range struct_def.fields {
 			field_def := struct_def.Fields[field_name]
 			if field_def == nil {
@@ -49,25 +62,118 @@ func GenerateProfileCode(
 			}
 
 			result += fmt.Sprintf("    Off_%s_%s int64\n",
-				struct_name, field_name)
-			init = append(init, fmt.Sprintf("%d", field_def.Offset))
+				normalized_name, field_name)
+
+			// Fields missing in some versions have a negative
+			// offset.
+			if field_def.optional {
+				factories += fmt.Sprintf(`
+func (self *%[1]s) Has_%[2]s() bool {
+    return self.Profile.Off_%[1]s_%[2]s >= 0
+}
+`, normalized_name, field_name)
+			}
 		}
 		factories += fmt.Sprintf(`
 func (self *%s) %s(reader io.ReaderAt, offset int64) *%s {
     return &%s{Reader: reader, Offset: offset, Profile: self}
 }
-`, profile_name, struct_name, struct_name, struct_name)
+`, profile_name, normalized_name, normalized_name, normalized_name)
 	}
 
-	result += fmt.Sprintf(`}
+	// Constants are symbol addresses (e.g. from Rekall profiles).
+	constant_names := []string{}
+	for _, version := range versions {
+		for name := range version.constants {
+			if !InString(constant_names, name) {
+				constant_names = append(constant_names, name)
+			}
+		}
+	}
+	sort.Strings(constant_names)
+	if len(constant_names) > 0 {
+		result += "    Constants map[string]uint64\n"
+	}
+	for _, name := range constant_names {
+		factories += fmt.Sprintf(`
+func (self *%s) %s() uint64 {
+    return self.Constants[%q]
+}
+`, profile_name, constantAccessorName(name), name)
+	}
+	result += "}\n"
 
+	if versions[0].name == "" {
+		return result + fmt.Sprintf(`
 func New%s() *%s {
     // Specific offsets can be tweaked to cater for slight version mismatches.
-    self := &%s{%s}
+    self := &%s{
+%s    }
     return self
 }
 %s
-`, profile_name, profile_name, profile_name, strings.Join(init, ","), factories)
+`, profile_name, profile_name, profile_name,
+			versions[0].init(profile, len(constant_names) > 0), factories)
+	}
+
+	names := []string{}
+	cases := ""
+	for _, version := range versions {
+		names = append(names, fmt.Sprintf("%q", version.name))
+		cases += fmt.Sprintf(`
+    case %q:
+        return &%s{
+%s        }, nil
+`, version.name, profile_name,
+			version.init(profile, len(constant_names) > 0))
+	}
+	sort.Strings(names)
+
+	return result + fmt.Sprintf(`
+// The versions New%[1]sForVersion() accepts.
+var %[1]sVersions = []string{%[2]s}
 
-	return result
+// Returns the profile for the default version (%[3]s).
+func New%[1]s() *%[1]s {
+    self, _ := New%[1]sForVersion(%[3]q)
+    return self
+}
+
+func New%[1]sForVersion(version string) (*%[1]s, error) {
+    // Specific offsets can be tweaked to cater for slight version mismatches.
+    switch version {
+%[4]s    }
+    return nil, fmt.Errorf("Unknown %[1]s version %%v", version)
+}
+%[5]s
+`, profile_name, strings.Join(names, ", "), versions[0].name, cases, factories)
+}
+
+// The initializers of the profile's fields for this version.
+func (self *profileVersion) init(profile map[string]*StructDefinition,
+	has_constants bool) string {
+	result := ""
+	for _, struct_name := range SortedKeys(profile) {
+		struct_def := profile[struct_name]
+		normalized_name := NormalizeName(struct_name)
+		result += fmt.Sprintf("    Size_%s: %d,\n",
+			normalized_name, self.sizes[struct_name])
+		for _, field_name := range struct_def.fields {
+			if struct_def.Fields[field_name] == nil {
+				continue
+			}
+			result += fmt.Sprintf("    Off_%s_%s: %d,\n", normalized_name,
+				field_name, self.offset(struct_name, field_name))
+		}
+	}
+
+	if !has_constants {
+		return result
+	}
+
+	result += "    Constants: map[string]uint64{\n"
+	for _, name := range SortedKeys(self.constants) {
+		result += fmt.Sprintf("        %q: %#x,\n", name, self.constants[name])
+	}
+	return result + "    },\n"
 }
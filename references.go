@@ -0,0 +1,83 @@
+package binparsergen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The struct a field refers to, either directly, as the element of an
+// array or through a pointer. Void pointers do not refer to a struct.
+func referencedStruct(field_def *FieldDefinition) (parser *StructParser, pointer bool) {
+	for field_def != nil {
+		switch {
+		case field_def.StructParser != nil:
+			if field_def.StructParser.Target == "Void" {
+				return nil, false
+			}
+			return field_def.StructParser, pointer
+
+		case field_def.Pointer != nil:
+			pointer = true
+			field_def = field_def.Pointer.Target
+
+		case field_def.ArrayParser != nil:
+			field_def = field_def.ArrayParser.Target
+
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// Generated code can only refer to structs in the profile. When
+// IncludeReferenced is set, pointers to other structs become void
+// pointers and other fields which refer to them are dropped (they
+// were excluded or are beyond IncludeDepth), and both are logged.
+// Otherwise they are an error.
+func resolveReferences(profile map[string]*StructDefinition, spec *ConversionSpec) error {
+	generated := make(map[string]bool)
+	for type_name := range profile {
+		generated[NormalizeName(type_name)] = true
+	}
+
+	missing := make(map[string][]string)
+	for _, type_name := range SortedKeys(profile) {
+		struct_def := profile[type_name]
+		for _, field_name := range struct_def.fields {
+			parser, pointer := referencedStruct(struct_def.Fields[field_name])
+			if parser == nil || generated[parser.Target] {
+				continue
+			}
+
+			if !spec.IncludeReferenced {
+				missing[parser.Target] = append(missing[parser.Target],
+					type_name+"."+field_name)
+
+			} else if pointer {
+				Logger.Printf("%v.%v: %v is not generated, using a void pointer",
+					type_name, field_name, parser.Target)
+				parser.Target = "Void"
+
+			} else {
+				Logger.Printf("%v.%v: %v is not generated, dropping the field",
+					type_name, field_name, parser.Target)
+				delete(struct_def.Fields, field_name)
+			}
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	names := SortedKeys(missing)
+	lines := []string{}
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("  %v (used by %v)", name,
+			strings.Join(missing[name], ", ")))
+	}
+
+	return fmt.Errorf("Referenced structs are not in Structs "+
+		"(add them or set IncludeReferenced):\n%v", strings.Join(lines, "\n"))
+}
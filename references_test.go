@@ -0,0 +1,75 @@
+package binparsergen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+const testReferences = `{
+ "_A": [16, {"B": [0, ["_B", {}]], "Next": [8, ["Pointer", {"target": "_A"}]]}],
+ "_B": [16, {"C": [0, ["_C", {}]], "D": [8, ["Pointer", {"target": "_D"}]]}],
+ "_C": [4, {"X": [0, ["unsigned long", {}]]}],
+ "_D": [4, {"Y": [0, ["unsigned long", {}]]}]
+}`
+
+func TestIncludeReferenced(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "vtypes.json")
+	err := ioutil.WriteFile(filename, []byte(testReferences), 0644)
+	assert.NilError(t, err)
+
+	// Without IncludeReferenced missing structs are an error.
+	_, err = ConvertSpec(&ConversionSpec{
+		Filename: filename,
+		Structs:  []string{"_A", "_B"},
+	})
+	assert.Error(t, err, "Referenced structs are not in Structs "+
+		"(add them or set IncludeReferenced):\n"+
+		"  C (used by _B.C)\n"+
+		"  D (used by _B.D)")
+
+	profile, err := ConvertSpec(&ConversionSpec{
+		Filename:          filename,
+		Structs:           []string{"_A"},
+		IncludeReferenced: true,
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, SortedKeys(profile), []string{"_A", "_B", "_C", "_D"})
+
+	output := &bytes.Buffer{}
+	defer func(logger *log.Logger) { Logger = logger }(Logger)
+	Logger = log.New(output, "", 0)
+
+	// Structs beyond the depth are not generated: Pointers to them
+	// become void pointers and other fields are dropped.
+	profile, err = ConvertSpec(&ConversionSpec{
+		Filename:          filename,
+		Structs:           []string{"_A"},
+		IncludeReferenced: true,
+		IncludeDepth:      1,
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, SortedKeys(profile), []string{"_A", "_B"})
+	assert.Assert(t, profile["_B"].Fields["C"] == nil)
+	assert.Equal(t, profile["_B"].Fields["D"].Pointer.pointsToStruct(), false)
+	assert.Equal(t, profile["_A"].Fields["Next"].Pointer.pointsToStruct(), true)
+	assert.Equal(t, output.String(),
+		"_B.C: C is not generated, dropping the field\n"+
+			"_B.D: D is not generated, using a void pointer\n")
+	output.Reset()
+
+	profile, err = ConvertSpec(&ConversionSpec{
+		Filename:          filename,
+		Structs:           []string{"_A"},
+		IncludeReferenced: true,
+		ExcludeStructs:    []string{"_B"},
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, SortedKeys(profile), []string{"_A"})
+	assert.Assert(t, profile["_A"].Fields["B"] == nil)
+	assert.Equal(t, output.String(), "_A.B: B is not generated, dropping the field\n")
+}
@@ -0,0 +1,119 @@
+package binparsergen
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+/* Rekall profile files wrap the vtypes in a container together with
+   information about the profile:
+
+   {
+     "$METADATA": {"ProfileClass": "Nt", "Type": "Profile", "arch": "AMD64"},
+     "$STRUCTS": {"_EPROCESS": [...], ...},
+     "$ENUMS": {"_POOL_TYPE": {"0": "NonPagedPool", "1": "PagedPool"}},
+     "$CONSTANTS": {"PsActiveProcessHead": 2954176, ...}
+   }
+
+   Enumeration fields may refer to a named enum in $ENUMS instead of
+   listing their choices:
+
+   "PoolType": [8, ["Enumeration", {"enum_name": "_POOL_TYPE", "target": "long"}]]
+
+   The $CONSTANTS are symbol addresses which are made available on
+   the generated profile. The pointer size follows from the arch in
+   the $METADATA, and "endian": "big" marks big endian pointers.
+*/
+
+type rekallProfile struct {
+	Metadata  map[string]interface{}    `json:"$METADATA"`
+	Structs   json.RawMessage           `json:"$STRUCTS"`
+	Enums     map[string]map[int]string `json:"$ENUMS"`
+	Constants map[string]uint64         `json:"$CONSTANTS"`
+}
+
+// Pointer sizes for the architectures in the Rekall metadata.
+var rekallPointerSizes = map[string]int{
+	"AMD64": 8,
+	"I386":  4,
+	"ARM":   4,
+	"MIPS":  4,
+}
+
+// The Rekall arch for a pointer size.
+func rekallArch(pointer_size int) string {
+	switch pointer_size {
+	case 8:
+		return "AMD64"
+	case 4:
+		return "I386"
+	}
+	return ""
+}
+
+// Returns true if the data is a Rekall profile container rather than
+// plain vtypes.
+func isRekallProfile(data []byte) bool {
+	var keys map[string]json.RawMessage
+	err := json.Unmarshal(data, &keys)
+	if err != nil {
+		return false
+	}
+
+	_, pres := keys["$STRUCTS"]
+	return pres
+}
+
+// Unpack a Rekall profile container into its vtype json, and its
+// pointer size, enums and constants.
+func ConvertRekall(data []byte, spec *ConversionSpec) ([]byte, *ProfileInfo, error) {
+	rekall := &rekallProfile{}
+	err := json.Unmarshal(data, rekall)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(rekall.Structs) == 0 {
+		return nil, nil, fmt.Errorf("Rekall profile has no $STRUCTS")
+	}
+
+	arch, _ := rekall.Metadata["arch"].(string)
+	endian, _ := rekall.Metadata["endian"].(string)
+	return rekall.Structs, &ProfileInfo{
+		PointerSize:      rekallPointerSizes[arch],
+		PointerBigEndian: endian == "big",
+		Enums:            rekall.Enums,
+		Constants:        rekall.Constants,
+	}, nil
+}
+
+// Every constant the spec names must be defined by one of the
+// loaded files (in any version).
+func checkConstants(spec *ConversionSpec, constants ...map[string]uint64) error {
+	for _, name := range spec.Constants {
+		pres := false
+		for _, defined := range constants {
+			if _, ok := defined[name]; ok {
+				pres = true
+				break
+			}
+		}
+		if !pres {
+			return fmt.Errorf("Constants: %v is not defined", name)
+		}
+	}
+	return nil
+}
+
+// The name of the generated accessor for a constant. Symbol names may
+// contain characters which are not valid in Go identifiers.
+func constantAccessorName(name string) string {
+	return "Const_" + strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,52 @@
+package binparsergen
+
+import (
+	"go/format"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestConvertRekall(t *testing.T) {
+	spec := &ConversionSpec{
+		Module:    "main",
+		Profile:   "TestProfile",
+		Filename:  "testdata/rekall.json",
+		Structs:   []string{"_POOL_HEADER"},
+		Constants: []string{"PsActiveProcessHead", "??_7Object@@6B@"},
+	}
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+
+	// Pointer size comes from the $METADATA arch.
+	definitions, err := LoadDefinitions(spec)
+	assert.NilError(t, err)
+	assert.Equal(t, definitions.PointerSize, 4)
+
+	pool_header := profile["_POOL_HEADER"]
+	assert.DeepEqual(t, pool_header.fields, []string{"PoolTag", "PoolType", "Next"})
+	assert.Equal(t, pool_header.Fields["PoolType"].Enumeration.Choices[1], "PagedPool")
+	assert.Equal(t, pool_header.Fields["Next"].Pointer.PointerSize, 4)
+
+	code := GenerateCode(spec, profile)
+	_, err = format.Source([]byte(code))
+	assert.NilError(t, err)
+
+	assert.Assert(t, strings.Contains(code, "    Off_POOL_HEADER_PoolType: 4,\n"))
+	assert.Assert(t, strings.Contains(code, `        "PsActiveProcessHead": 0x806c8000,`))
+	assert.Assert(t, strings.Contains(code,
+		"func (self *TestProfile) Const_PsActiveProcessHead() uint64 {"))
+	assert.Assert(t, strings.Contains(code,
+		"func (self *TestProfile) Const____7Object__6B_() uint64 {"))
+	assert.Assert(t, !strings.Contains(code, "KiServiceTable"))
+
+	// Constants which are not in $CONSTANTS are an error.
+	_, err = ConvertSpec(&ConversionSpec{
+		Filename:  "testdata/rekall.json",
+		Structs:   []string{"_POOL_HEADER"},
+		Constants: []string{"PsActiveProcessHead", "Missing"},
+	})
+	assert.ErrorContains(t, err, "Constants: Missing is not defined")
+}
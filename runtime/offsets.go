@@ -0,0 +1,161 @@
+package runtime
+
+/* Generated profiles with GenerateOffsetLoader set update their struct
+   sizes and field offsets from a newer vtype, Rekall or ISF json file
+   using the OffsetLoader:
+
+   types, err := runtime.ParseOffsetTypes(reader)
+   loader := &runtime.OffsetLoader{Types: types}
+   loader.Size(&profile.Size_GUID, "_GUID")
+   loader.Offset(&profile.Off_GUID_Data1, "_GUID", "Data1", "unsigned long")
+
+   Fields which are missing from the file (including all fields of
+   missing structs) get the offset -1 so the generated Has_<Field>()
+   methods report them as missing.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"www.velocidex.com/golang/binparsergen"
+)
+
+type OffsetField struct {
+	Offset int64
+	Type   string
+}
+
+type OffsetStruct struct {
+	Size   int64
+	Fields map[string]OffsetField
+}
+
+type OffsetLoader struct {
+	Types    map[string]*OffsetStruct
+	Warnings []string
+
+	// Missing structs are only reported once.
+	missing map[string]bool
+}
+
+// Set the target to the size of the struct. Missing structs keep
+// their size.
+func (self *OffsetLoader) Size(target *int64, struct_name string) {
+	definition, pres := self.Types[struct_name]
+	if !pres {
+		self.reportMissing(struct_name)
+		return
+	}
+	*target = definition.Size
+}
+
+// Set the target to the offset of the field or -1 if the field or
+// its struct is missing.
+func (self *OffsetLoader) Offset(target *int64,
+	struct_name, field_name, type_name string) {
+	definition, pres := self.Types[struct_name]
+	if !pres {
+		self.reportMissing(struct_name)
+		*target = -1
+		return
+	}
+
+	field, pres := definition.Fields[field_name]
+	if !pres {
+		self.Warnings = append(self.Warnings, fmt.Sprintf(
+			"%s.%s is missing", struct_name, field_name))
+		*target = -1
+		return
+	}
+
+	if field.Type != type_name {
+		self.Warnings = append(self.Warnings, fmt.Sprintf(
+			"%s.%s changed type from %s to %s", struct_name, field_name,
+			type_name, field.Type))
+	}
+	*target = field.Offset
+}
+
+func (self *OffsetLoader) reportMissing(struct_name string) {
+	if self.missing == nil {
+		self.missing = make(map[string]bool)
+	}
+	if self.missing[struct_name] {
+		return
+	}
+	self.missing[struct_name] = true
+	self.Warnings = append(self.Warnings, fmt.Sprintf(
+		"%s is missing", struct_name))
+}
+
+// Parse the struct sizes and field offsets from a vtype, Rekall or
+// ISF json file. Rekall and ISF files are converted to vtypes like
+// ConvertSpec does.
+func ParseOffsetTypes(reader io.Reader) (map[string]*OffsetStruct, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys map[string]json.RawMessage
+	err = json.Unmarshal(data, &keys)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &binparsergen.ConversionSpec{}
+	if _, pres := keys["$STRUCTS"]; pres {
+		data, _, err = binparsergen.ConvertRekall(data, spec)
+		if err != nil {
+			return nil, err
+		}
+
+	} else if _, pres := keys["user_types"]; pres {
+		vtypes, _, err := binparsergen.ConvertISF(data, spec)
+		if err != nil {
+			return nil, err
+		}
+		data, err = json.Marshal(vtypes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var types map[string]json.RawMessage
+	err = json.Unmarshal(data, &types)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*OffsetStruct)
+	for name, data := range types {
+		var definition []json.RawMessage
+		var fields map[string][]json.RawMessage
+		item := &OffsetStruct{Fields: make(map[string]OffsetField)}
+		if json.Unmarshal(data, &definition) != nil || len(definition) < 2 ||
+			json.Unmarshal(definition[0], &item.Size) != nil ||
+			json.Unmarshal(definition[1], &fields) != nil {
+			continue
+		}
+
+		for field_name, field := range fields {
+			var offset int64
+			var params []json.RawMessage
+			if len(field) < 2 || json.Unmarshal(field[0], &offset) != nil {
+				continue
+			}
+			_ = json.Unmarshal(field[1], &params)
+
+			item.Fields[field_name] = OffsetField{
+				Offset: offset,
+				Type:   binparsergen.VtypeTypeName(params),
+			}
+		}
+		result[name] = item
+	}
+
+	return result, nil
+}
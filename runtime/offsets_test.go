@@ -0,0 +1,84 @@
+package runtime
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func parseOffsetFile(t *testing.T, filename string) map[string]*OffsetStruct {
+	fd, err := os.Open(filename)
+	assert.NilError(t, err)
+	defer fd.Close()
+
+	types, err := ParseOffsetTypes(fd)
+	assert.NilError(t, err)
+	return types
+}
+
+func TestParseOffsetTypes(t *testing.T) {
+	types := parseOffsetFile(t, "../testdata/vtypes.json")
+	assert.Equal(t, types["_GUID"].Size, int64(16))
+	assert.Equal(t, types["_GUID"].Fields["Data4"],
+		OffsetField{Offset: 8, Type: "Array"})
+
+	// Rekall profiles wrap the vtypes.
+	types = parseOffsetFile(t, "../testdata/rekall.json")
+	assert.Equal(t, types["_POOL_HEADER"].Size, int64(12))
+	assert.Equal(t, types["_POOL_HEADER"].Fields["PoolType"],
+		OffsetField{Offset: 4, Type: "Enumeration"})
+
+	// ISF types get their vtype names and bit fields their bit range.
+	types = parseOffsetFile(t, "../testdata/isf.json")
+	fields := types["_OBJECT"].Fields
+	assert.Equal(t, types["_OBJECT"].Size, int64(40))
+	assert.Equal(t, fields["Magic"], OffsetField{Offset: 8, Type: "unsigned be long"})
+	assert.Equal(t, fields["Locked"], OffsetField{Offset: 4, Type: "BitField[1:3]"})
+	assert.Equal(t, fields["List"], OffsetField{Offset: 16, Type: "_LIST_ENTRY"})
+	assert.Equal(t, fields["Callback"], OffsetField{Offset: 32, Type: "Pointer"})
+}
+
+func TestOffsetLoader(t *testing.T) {
+	types, err := ParseOffsetTypes(strings.NewReader(`{
+  "_GUID": [20, {
+     "Data1": [4, ["unsigned int", {}]],
+     "Data2": [8, ["unsigned long", {}]],
+     "Bits": [12, ["BitField", {"start_bit": 3, "end_bit": 6, "target": "unsigned long"}]]
+  }]
+}`))
+	assert.NilError(t, err)
+
+	loader := &OffsetLoader{Types: types}
+	size, header_size := int64(16), int64(80)
+	data1, data2, data3, magic, version := int64(0), int64(4), int64(6), int64(0), int64(4)
+	bits := int64(12)
+
+	loader.Size(&size, "_GUID")
+	loader.Offset(&data1, "_GUID", "Data1", "unsigned long")
+	loader.Offset(&data2, "_GUID", "Data2", "unsigned short")
+	loader.Offset(&data3, "_GUID", "Data3", "unsigned short")
+	loader.Offset(&bits, "_GUID", "Bits", "BitField[2:5]")
+	loader.Size(&header_size, "_HEADER")
+	loader.Offset(&magic, "_HEADER", "Magic", "Signature")
+	loader.Offset(&version, "_HEADER", "Version", "unsigned short")
+
+	assert.Equal(t, size, int64(20))
+	assert.Equal(t, data1, int64(4))
+	assert.Equal(t, data2, int64(8))
+
+	// Missing fields and all fields of missing structs are -1. Missing
+	// structs keep their size.
+	assert.Equal(t, data3, int64(-1))
+	assert.Equal(t, magic, int64(-1))
+	assert.Equal(t, version, int64(-1))
+	assert.Equal(t, header_size, int64(80))
+
+	assert.DeepEqual(t, loader.Warnings, []string{
+		"_GUID.Data2 changed type from unsigned short to unsigned long",
+		"_GUID.Data3 is missing",
+		"_GUID.Bits changed type from BitField[2:5] to BitField[3:6]",
+		"_HEADER is missing",
+	})
+}
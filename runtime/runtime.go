@@ -0,0 +1,421 @@
+package runtime
+
+/* The runtime parser interprets the structs produced by ConvertSpec
+   directly. This allows inspecting files without generating and
+   compiling code:
+
+   profile, err := binparsergen.ConvertSpec(spec)
+   parser := runtime.NewParser(profile)
+   header, err := parser.Parse(reader, "_HEADER", 0)
+
+   Structs are parsed to a *Struct holding the field values in vtype
+   order. Values have the types returned by the generated accessors,
+   except that enumerations, flags and signatures are the types of
+   this package and nested structs are *Struct. Pointers to structs
+   are only followed up to PointerDepth levels.
+
+   Like the generated code, fields which can not be read have zero
+   values. Fields of malformed structs which contain themselves are
+   nil.
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/binparsergen"
+)
+
+// The largest array or string which will be read.
+const MAX_COUNT = 4000000
+
+type Parser struct {
+	profile map[string]*binparsergen.StructDefinition
+
+	// Structs are found by their vtype or generated name.
+	names map[string]string
+
+	// How many levels of pointers to structs to follow.
+	PointerDepth int
+}
+
+func NewParser(profile map[string]*binparsergen.StructDefinition) *Parser {
+	names := make(map[string]string)
+	for name := range profile {
+		names[binparsergen.NormalizeName(name)] = name
+	}
+	for name := range profile {
+		names[name] = name
+	}
+
+	return &Parser{profile: profile, names: names}
+}
+
+// The vtype name and definition of a struct.
+func (self *Parser) Struct(name string) (string, *binparsergen.StructDefinition, bool) {
+	name, pres := self.names[name]
+	if !pres {
+		return "", nil, false
+	}
+	return name, self.profile[name], true
+}
+
+// Parse the struct at the offset.
+func (self *Parser) Parse(reader io.ReaderAt, struct_name string,
+	offset int64) (*Struct, error) {
+	name, struct_def, pres := self.Struct(struct_name)
+	if !pres {
+		return nil, fmt.Errorf("Unknown struct %v", struct_name)
+	}
+
+	return self.parseStruct(reader, name, struct_def, offset,
+		self.PointerDepth, nil), nil
+}
+
+// Parse the struct at the offset. The embedding list holds the
+// structs which contain it directly or in arrays. A malformed struct
+// which contains itself would never end, so the contained copy is nil
+// instead.
+func (self *Parser) parseStruct(reader io.ReaderAt, name string,
+	struct_def *binparsergen.StructDefinition, offset int64, depth int,
+	embedding []string) *Struct {
+	embedding = append(embedding[:len(embedding):len(embedding)], name)
+	result := &Struct{
+		Name:   name,
+		Offset: offset,
+		Fields: ordereddict.NewDict(),
+	}
+
+	for _, field_name := range struct_def.FieldNames() {
+		field_def := struct_def.Fields[field_name]
+		if field_def == nil {
+			continue
+		}
+
+		result.Fields.Set(field_name, self.parseField(reader, struct_def, offset,
+			field_def.GetParser(), offset+field_def.Offset, depth, embedding))
+	}
+	return result
+}
+
+// Parse a field at the offset. Dynamic lengths and counts refer to
+// other fields of the struct at base.
+func (self *Parser) parseField(reader io.ReaderAt,
+	struct_def *binparsergen.StructDefinition, base int64,
+	parser binparsergen.Parser, offset int64, depth int,
+	embedding []string) interface{} {
+	value, _, ok := readInteger(reader, offset, parser)
+	if ok {
+		return value
+	}
+
+	switch t := parser.(type) {
+	case *binparsergen.BitField:
+		_, value, _ := readInteger(reader, offset, binparsergen.IntegerParser(t.Target))
+		return (value & ((1 << t.EndBit) - 1)) >> t.StartBit
+
+	case *binparsergen.Enumeration:
+		_, value, _ := readInteger(reader, offset, binparsergen.IntegerParser(t.Target))
+		name, pres := t.Choices[int(value)]
+		if !pres {
+			name = "Unknown"
+		}
+		return &Enumeration{Value: value, Name: name}
+
+	case *binparsergen.Flags:
+		_, value, _ := readInteger(reader, offset, binparsergen.IntegerParser(t.Target))
+		result := &Flags{Value: value, Names: []string{}}
+		for name, mask := range t.Maskmap {
+			if value&uint64(mask) != 0 {
+				result.Names = append(result.Names, name)
+			}
+		}
+		for name, bit := range t.Bitmap {
+			if value&(1<<uint64(bit)) != 0 {
+				result.Names = append(result.Names, name)
+			}
+		}
+		sort.Strings(result.Names)
+		return result
+
+	case *binparsergen.SignatureParser:
+		return &Signature{
+			Value:     string(readBytes(reader, offset, int64(len(t.Value)))),
+			Signature: t.Value,
+		}
+
+	case *binparsergen.StringParser:
+		if t.DynamicLength != "" {
+			length := self.fieldInteger(reader, struct_def, base, t.DynamicLength)
+			return string(readBytes(reader, offset, length))
+		}
+		if t.Length == 0 {
+			data := readBytes(reader, offset, 1024)
+			idx := bytes.IndexByte(data, 0)
+			if idx >= 0 {
+				data = data[:idx]
+			}
+			return string(data)
+		}
+		return string(readBytes(reader, offset, int64(t.Length)))
+
+	case *binparsergen.UTF16StringParser:
+		if t.DynamicLength != "" {
+			length := self.fieldInteger(reader, struct_def, base, t.DynamicLength)
+			return utf16BytesToUTF8(readBytes(reader, offset, length))
+		}
+		if t.Length == 0 {
+			data := readBytes(reader, offset, 1024)
+			idx := bytes.Index(data, []byte{0, 0})
+			if idx < 0 {
+				idx = len(data) - 1
+			}
+			if idx%2 != 0 {
+				idx += 1
+			}
+			if idx > len(data) {
+				idx = len(data)
+			}
+			return utf16BytesToUTF8(data[:idx])
+		}
+		return utf16BytesToUTF8(readBytes(reader, offset, int64(t.Length)))
+
+	case *binparsergen.WinFileTime:
+		_, value, _ := readInteger(reader, offset, &binparsergen.Uint64Parser{})
+		if value == 0 {
+			return time.Time{}
+		}
+		return time.Unix(0, (int64(value)-116444736000000000)*100).UTC()
+
+	case *binparsergen.UnixTimeStamp:
+		_, value, _ := readInteger(reader, offset, &binparsergen.Uint32Parser{})
+		if value == 0 {
+			return time.Time{}
+		}
+		return time.Unix(int64(value), 0).UTC()
+
+	case *binparsergen.StructParser:
+		name, struct_def, pres := self.Struct(t.Target)
+		if !pres || binparsergen.InString(embedding, name) {
+			return nil
+		}
+		return self.parseStruct(reader, name, struct_def, offset, depth, embedding)
+
+	case *binparsergen.Pointer:
+		_, address, _ := readInteger(reader, offset, pointerParser(t))
+
+		// Pointers to anything but structs are just addresses.
+		target, ok := t.Target.GetParser().(*binparsergen.StructParser)
+		if !ok {
+			return address
+		}
+		name, target_def, pres := self.Struct(target.Target)
+		if !pres {
+			return address
+		}
+
+		result := &Pointer{Address: address}
+		if depth > 0 && address != 0 {
+			result.Target = self.parseStruct(reader, name, target_def,
+				int64(address), depth-1, nil)
+		}
+		return result
+
+	case *binparsergen.ArrayParser:
+		count := int64(t.Count)
+		if t.DynamicCount != "" {
+			count = self.fieldInteger(reader, struct_def, base, t.DynamicCount)
+		}
+		if count < 0 {
+			count = 0
+		}
+		if count > MAX_COUNT {
+			count = MAX_COUNT
+		}
+
+		element := t.Target.GetParser()
+		size := self.size(element)
+		result := make([]interface{}, 0, count)
+		for i := int64(0); i < count; i++ {
+			result = append(result, self.parseField(reader, struct_def, base,
+				element, offset+i*size, depth, embedding))
+		}
+		return result
+	}
+
+	return nil
+}
+
+// The value of an integer field of the struct at base.
+func (self *Parser) fieldInteger(reader io.ReaderAt,
+	struct_def *binparsergen.StructDefinition, base int64, field_name string) int64 {
+	field_def := struct_def.Fields[field_name]
+	if field_def == nil {
+		return 0
+	}
+
+	value := self.parseField(reader, struct_def, base, field_def.GetParser(),
+		base+field_def.Offset, 0, nil)
+	switch t := value.(type) {
+	case uint64:
+		return int64(t)
+	case int64:
+		return t
+	case uint32:
+		return int64(t)
+	case int32:
+		return int64(t)
+	case uint16:
+		return int64(t)
+	case int16:
+		return int64(t)
+	case uint8:
+		return int64(t)
+	case int8:
+		return int64(t)
+	}
+	return 0
+}
+
+// The number of bytes a value occupies (e.g. in arrays).
+func (self *Parser) size(parser binparsergen.Parser) int64 {
+	switch t := parser.(type) {
+	case *binparsergen.Uint64Parser, *binparsergen.Int64Parser,
+		*binparsergen.WinFileTime:
+		return 8
+	case *binparsergen.Uint32Parser, *binparsergen.Int32Parser,
+		*binparsergen.UnixTimeStamp:
+		return 4
+	case *binparsergen.Uint16Parser, *binparsergen.Int16Parser:
+		return 2
+	case *binparsergen.Uint8Parser, *binparsergen.Int8Parser:
+		return 1
+	case *binparsergen.BitField:
+		return self.size(binparsergen.IntegerParser(t.Target))
+	case *binparsergen.Enumeration:
+		return self.size(binparsergen.IntegerParser(t.Target))
+	case *binparsergen.Flags:
+		return self.size(binparsergen.IntegerParser(t.Target))
+	case *binparsergen.Pointer:
+		return self.size(pointerParser(t))
+	case *binparsergen.SignatureParser:
+		return int64(len(t.Value))
+	case *binparsergen.StringParser:
+		return int64(t.Length)
+	case *binparsergen.UTF16StringParser:
+		return int64(t.Length)
+	case *binparsergen.StructParser:
+		_, struct_def, pres := self.Struct(t.Target)
+		if pres {
+			return int64(struct_def.Size)
+		}
+	case *binparsergen.ArrayParser:
+		return int64(t.Count) * self.size(t.Target.GetParser())
+	}
+	return 0
+}
+
+func pointerParser(pointer *binparsergen.Pointer) binparsergen.Parser {
+	if pointer.PointerSize == 4 {
+		return &binparsergen.Uint32Parser{BigEndian: pointer.BigEndian}
+	}
+	return &binparsergen.Uint64Parser{BigEndian: pointer.BigEndian}
+}
+
+func byteOrder(big_endian bool) binary.ByteOrder {
+	if big_endian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// Read an integer with the type of the parser. Also returns the value
+// as uint64 (sign extended) and whether the parser is an integer.
+func readInteger(reader io.ReaderAt, offset int64,
+	parser binparsergen.Parser) (interface{}, uint64, bool) {
+	read := func(size int) []byte {
+		data := make([]byte, size)
+		_, err := reader.ReadAt(data, offset)
+		if err != nil {
+			return make([]byte, size)
+		}
+		return data
+	}
+
+	switch t := parser.(type) {
+	case *binparsergen.Uint64Parser:
+		value := byteOrder(t.BigEndian).Uint64(read(8))
+		return value, value, true
+	case *binparsergen.Int64Parser:
+		value := int64(byteOrder(t.BigEndian).Uint64(read(8)))
+		return value, uint64(value), true
+	case *binparsergen.Uint32Parser:
+		value := byteOrder(t.BigEndian).Uint32(read(4))
+		return value, uint64(value), true
+	case *binparsergen.Int32Parser:
+		value := int32(byteOrder(t.BigEndian).Uint32(read(4)))
+		return value, uint64(value), true
+	case *binparsergen.Uint16Parser:
+		value := byteOrder(t.BigEndian).Uint16(read(2))
+		return value, uint64(value), true
+	case *binparsergen.Int16Parser:
+		value := int16(byteOrder(t.BigEndian).Uint16(read(2)))
+		return value, uint64(value), true
+	case *binparsergen.Uint8Parser:
+		value := read(1)[0]
+		return value, uint64(value), true
+	case *binparsergen.Int8Parser:
+		value := int8(read(1)[0])
+		return value, uint64(value), true
+	}
+	return nil, 0, false
+}
+
+// Read up to length bytes. Short reads return what is available.
+func readBytes(reader io.ReaderAt, offset int64, length int64) []byte {
+	if length <= 0 {
+		return nil
+	}
+	if length > MAX_COUNT {
+		length = MAX_COUNT
+	}
+
+	data := make([]byte, length)
+	n, err := reader.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil
+	}
+	return data[:n]
+}
+
+// Decode little endian UTF16 (unless there is a byte order mark).
+func utf16BytesToUTF8(b []byte) string {
+	if len(b) < 2 {
+		return ""
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if b[0] == 0xff && b[1] == 0xfe {
+		order = binary.BigEndian
+		b = b[2:]
+	} else if b[0] == 0xfe && b[1] == 0xff {
+		b = b[2:]
+	}
+
+	utf := make([]uint16, (len(b)+1)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		utf[i/2] = order.Uint16(b[i:])
+	}
+	if len(b)/2 < len(utf) {
+		utf[len(utf)-1] = utf8.RuneError
+	}
+
+	return string(utf16.Decode(utf))
+}
@@ -0,0 +1,102 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+	"www.velocidex.com/golang/binparsergen"
+)
+
+func TestParse(t *testing.T) {
+	profile, err := binparsergen.ConvertSpec(&binparsergen.ConversionSpec{
+		Filename:          "../testdata/vtypes.json",
+		Structs:           []string{"_HEADER"},
+		IncludeReferenced: true,
+	})
+	assert.NilError(t, err)
+
+	data := make([]byte, 160)
+	copy(data, "HDR1")
+	binary.LittleEndian.PutUint16(data[4:], 7)
+	binary.LittleEndian.PutUint16(data[6:], 2)
+	binary.LittleEndian.PutUint32(data[8:], 3)
+	binary.LittleEndian.PutUint32(data[12:], 0x1c)
+	data[16] = 2
+	binary.LittleEndian.PutUint32(data[20:], 0x11223344)
+	copy(data[36:], "name")
+	copy(data[44:], []byte{'w', 0, 'i', 0})
+	binary.LittleEndian.PutUint64(data[56:], 80)
+	copy(data[80:], "HDR2")
+
+	parser := NewParser(profile)
+	parser.PointerDepth = 1
+	header, err := parser.Parse(bytes.NewReader(data), "HEADER", 0)
+	assert.NilError(t, err)
+	assert.Equal(t, header.Name, "_HEADER")
+
+	get := func(value interface{}, name string) interface{} {
+		result, pres := value.(*Struct).Get(name)
+		assert.Assert(t, pres, name)
+		return result
+	}
+
+	assert.Assert(t, get(header, "Magic").(*Signature).IsValid())
+	assert.Equal(t, get(header, "Version"), uint16(7))
+	assert.Equal(t, get(header, "Type").(*Enumeration).Name, "TWO")
+	assert.DeepEqual(t, get(header, "Flags").(*Flags).Names, []string{"A", "B"})
+	assert.Equal(t, get(header, "Bits"), uint64(7))
+	assert.Equal(t, get(header, "Name"), "name\x00\x00\x00\x00")
+	assert.Equal(t, get(header, "Wide"), "wi\x00\x00")
+	assert.Equal(t, get(get(header, "Id"), "Data1"), uint32(0x11223344))
+	assert.DeepEqual(t, get(header, "Dyn"), []interface{}{uint8(80), uint8(0)})
+
+	// The pointer is followed once.
+	next := get(header, "Next").(*Pointer)
+	assert.Equal(t, next.Address, uint64(80))
+	assert.Equal(t, next.Target.Offset, int64(80))
+	assert.Assert(t, !get(next.Target, "Magic").(*Signature).IsValid())
+	assert.Assert(t, get(next.Target, "Next").(*Pointer).Target == nil)
+
+	serialized, err := json.Marshal(get(header, "Id"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(serialized), `{"Data1":287454020,"Data2":0,"Data3":0,"Data4":[0,0,0,0,0,0,0,0]}`)
+
+	_, err = parser.Parse(bytes.NewReader(data), "_MISSING", 0)
+	assert.ErrorContains(t, err, "Unknown struct _MISSING")
+}
+
+func TestParseSelfContainingStruct(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "vtypes.json")
+	assert.NilError(t, ioutil.WriteFile(filename, []byte(`{
+ "_A": [8, {
+    "X": [0, ["unsigned long", {}]],
+    "Self": [4, ["_A", {}]],
+    "Selves": [4, ["Array", {"count": 1, "target": "_B"}]]
+ }],
+ "_B": [4, {"A": [0, ["_A", {}]]}]
+}`), 0644))
+
+	profile, err := binparsergen.ConvertSpec(&binparsergen.ConversionSpec{
+		Filename: filename,
+		Structs:  []string{"_A", "_B"},
+	})
+	assert.NilError(t, err)
+
+	// The contained copies are nil instead of recursing forever.
+	a, err := NewParser(profile).Parse(bytes.NewReader([]byte{7, 0, 0, 0}), "A", 0)
+	assert.NilError(t, err)
+
+	value, _ := a.Get("X")
+	assert.Equal(t, value, uint32(7))
+	value, _ = a.Get("Self")
+	assert.Assert(t, value == nil)
+	value, _ = a.Get("Selves")
+	b := value.([]interface{})[0].(*Struct)
+	value, _ = b.Get("A")
+	assert.Assert(t, value == nil)
+}
@@ -0,0 +1,131 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+)
+
+// A parsed struct. The field values are kept in vtype order.
+type Struct struct {
+	Name   string
+	Offset int64
+	Fields *ordereddict.Dict
+}
+
+func (self *Struct) Get(field_name string) (interface{}, bool) {
+	return self.Fields.Get(field_name)
+}
+
+func (self *Struct) MarshalJSON() ([]byte, error) {
+	return json.Marshal(self.Fields)
+}
+
+type Enumeration struct {
+	Value uint64
+	Name  string
+}
+
+func (self Enumeration) String() string {
+	return fmt.Sprintf("%s (%d)", self.Name, self.Value)
+}
+
+// The names of the set flags are sorted.
+type Flags struct {
+	Value uint64
+	Names []string
+}
+
+func (self Flags) IsSet(flag string) bool {
+	for _, name := range self.Names {
+		if name == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func (self Flags) String() string {
+	return fmt.Sprintf("%d (%s)", self.Value, strings.Join(self.Names, ","))
+}
+
+type Signature struct {
+	Value     string
+	Signature string
+}
+
+func (self Signature) IsValid() bool {
+	return self.Value == self.Signature
+}
+
+func (self Signature) String() string {
+	if self.IsValid() {
+		return fmt.Sprintf("%q", self.Value)
+	}
+	return fmt.Sprintf("%q (expected %q)", self.Value, self.Signature)
+}
+
+// A pointer to a struct. The target is only parsed while the parser's
+// PointerDepth allows it and the address is not 0.
+type Pointer struct {
+	Address uint64
+	Target  *Struct
+}
+
+// A text representation like the generated DebugString() methods.
+func (self *Struct) DebugString() string {
+	result := fmt.Sprintf("struct %s @ %#x:\n", self.Name, self.Offset)
+	for _, field_name := range self.Fields.Keys() {
+		value, _ := self.Fields.Get(field_name)
+		result += fmt.Sprintf("  %s: %s\n", field_name,
+			strings.ReplaceAll(debugString(value), "\n", "\n  "))
+	}
+	return result
+}
+
+// Nested values are indented relative to their first line.
+func debugString(value interface{}) string {
+	switch t := value.(type) {
+	case *Struct:
+		if t == nil {
+			return "nil"
+		}
+		return fmt.Sprintf("{\n%s\n}", indent(t.DebugString()))
+
+	case *Pointer:
+		if t.Target == nil {
+			return fmt.Sprintf("%#x", t.Address)
+		}
+		return fmt.Sprintf("%#x -> {\n%s\n}", t.Address, indent(t.Target.DebugString()))
+
+	case []interface{}:
+		items := []string{}
+		for _, item := range t {
+			items = append(items, debugString(item))
+		}
+		if len(t) > 0 {
+			if _, ok := t[0].(*Struct); ok {
+				return fmt.Sprintf("[\n%s\n]", indent(strings.Join(items, ",\n")))
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+
+	case string:
+		return fmt.Sprintf("%q", t)
+
+	case uint64, int64, uint32, int32, uint16, int16, uint8, int8:
+		return fmt.Sprintf("%#x", t)
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+func indent(text string) string {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for idx, line := range lines {
+		lines[idx] = "  " + line
+	}
+	return strings.Join(lines, "\n")
+}
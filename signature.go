@@ -46,7 +46,7 @@ func (self *SignatureParser) Compile(struct_name string, field_name string) stri
 
 func (self *%[1]s) %[2]s() *Signature {
   value := ParseSignature(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset, %[3]v)
-  return &Signature{value: value, signature: "%v"}
+  return &Signature{value: value, signature: %[4]q}
 }
 `, struct_name, field_name, len(self.Value), self.Value)
 }
@@ -13,10 +13,92 @@ type ConversionSpec struct {
 	Module              string              `json:"Module"`
 	Profile             string              `json:"Profile"`
 	Filename            string              `json:"Filename"`
+	Format              string              `json:"Format"`
 	Structs             []string            `json:"Structs"`
 	FieldWhiteList      map[string][]string `json:"FieldWhiteList"`
 	FieldBlackList      map[string][]string `json:"FieldBlackList"`
 	GenerateDebugString bool                `json:"GenerateDebugString"`
+
+	// Also generate the structs referenced by Structs, up to
+	// IncludeDepth references away (default unlimited). Without
+	// IncludeReferenced any reference to a struct which is not
+	// generated is an error. ExcludeStructs are never generated.
+	// Struct and field names may be globs or /regex/ patterns.
+	// Verbose logs what each pattern matched.
+	IncludeReferenced bool     `json:"IncludeReferenced"`
+	IncludeDepth      int      `json:"IncludeDepth"`
+	ExcludeStructs    []string `json:"ExcludeStructs"`
+	Verbose           bool     `json:"Verbose"`
+
+	// Per struct maps of fields to their new names and to vtype
+	// type definitions replacing their types (e.g.
+	// [UnicodeString, {dynamic_length: Length}]).
+	FieldRenames       map[string]map[string]string        `json:"FieldRenames"`
+	FieldTypeOverrides map[string]map[string][]interface{} `json:"FieldTypeOverrides"`
+
+	// Vtype files for different versions of the profile (e.g. Win7:
+	// win7.json) used instead of Filename. New<Profile>ForVersion()
+	// selects the version at runtime and New<Profile>() uses the
+	// DefaultVersion (default the first version).
+	Versions       map[string]string `json:"Versions"`
+	DefaultVersion string            `json:"DefaultVersion"`
+
+	// Vtype files merged over Filename in order (e.g. overlays).
+	Filenames []string `json:"Filenames"`
+
+	// The size of pointers in bytes (4 or 8, default 8) and their
+	// byte order. By default they are taken from the definitions
+	// file.
+	PointerSize      int  `json:"PointerSize"`
+	PointerBigEndian bool `json:"PointerBigEndian"`
+
+	// The ABI used to lay out C headers (e.g. amd64, 386,
+	// windows_amd64, default amd64).
+	Arch string `json:"Arch"`
+
+	GenerateBuilder bool `json:"GenerateBuilder"`
+
+	// How many levels of nested structs Decode() follows (0 follows
+	// none, unset uses the default).
+	GenerateDecode bool `json:"GenerateDecode"`
+	DecodeDepth    *int `json:"DecodeDepth"`
+
+	// Likewise for nested structs and pointers in MarshalJSON().
+	GenerateJSON bool `json:"GenerateJSON"`
+	JSONDepth    *int `json:"JSONDepth"`
+
+	// Generate ToDict() methods. DictNestedStructs and DictPointers
+	// may be "inline", "lazy" or "omit" (pointers also "address").
+	// DictDepth works like DecodeDepth.
+	GenerateDict      bool   `json:"GenerateDict"`
+	DictDepth         *int   `json:"DictDepth"`
+	DictNestedStructs string `json:"DictNestedStructs"`
+	DictPointers      string `json:"DictPointers"`
+
+	// Generate <Field>E() accessors which report read errors.
+	GenerateErrorAccessors bool `json:"GenerateErrorAccessors"`
+
+	// Generate Types(), GetOffset() and SetOffset() on the profile.
+	GenerateTypes bool `json:"GenerateTypes"`
+
+	// Generate Detect<Profile>() to find the best matching version.
+	Detect *DetectSpec `json:"Detect"`
+
+	// Generate Load<Profile>Offsets() to update the offsets from a
+	// vtype or ISF file at runtime.
+	GenerateOffsetLoader bool `json:"GenerateOffsetLoader"`
+
+	// Constants (symbol addresses) to generate accessors for.
+	Constants []string `json:"Constants"`
+
+	// Named enums and constants of the input files (e.g. in Rekall
+	// profile files). ConvertSpec keeps the constants for generating
+	// the profile.
+	enums     map[string]map[int]string
+	constants map[string]uint64
+
+	// The offsets of each version when Versions are used.
+	versions []*profileVersion
 }
 
 func LoadSpecFile(filename string) (*ConversionSpec, error) {
@@ -38,3 +120,11 @@ func LoadSpecFile(filename string) (*ConversionSpec, error) {
 
 	return result, nil
 }
+
+// The depth the spec sets, or the default if it is not set.
+func specDepth(depth *int, default_depth int) int {
+	if depth == nil {
+		return default_depth
+	}
+	return *depth
+}
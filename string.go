@@ -5,6 +5,9 @@ import "fmt"
 type StringParser struct {
 	BaseParser
 	Length uint64 `json:"length,omitempty"`
+
+	// The name of a previous field holding the length in bytes.
+	DynamicLength string `json:"dynamic_length,omitempty"`
 }
 
 func (self StringParser) Prototype() string {
@@ -47,6 +50,15 @@ func (self StringParser) PrototypeName() string {
 }
 
 func (self *StringParser) Compile(struct_name string, field_name string) string {
+	if self.DynamicLength != "" {
+		return fmt.Sprintf(`
+
+func (self *%[1]s) %[2]s() string {
+  return ParseString(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset, int64(self.%[3]s()))
+}
+`, struct_name, field_name, self.DynamicLength)
+	}
+
 	if self.Length == 0 {
 		return fmt.Sprintf(`
 
@@ -75,6 +87,9 @@ func (self StringParser) Size(value string) string {
 type UTF16StringParser struct {
 	BaseParser
 	Length uint64 `json:"length,omitempty"`
+
+	// The name of a previous field holding the length in bytes.
+	DynamicLength string `json:"dynamic_length,omitempty"`
 }
 
 func (self UTF16StringParser) Prototype() string {
@@ -146,6 +161,15 @@ func (self UTF16StringParser) PrototypeName() string {
 }
 
 func (self *UTF16StringParser) Compile(struct_name string, field_name string) string {
+	if self.DynamicLength != "" {
+		return fmt.Sprintf(`
+
+func (self *%[1]s) %[2]s() string {
+  return ParseUTF16String(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset, int64(self.%[3]s()))
+}
+`, struct_name, field_name, self.DynamicLength)
+	}
+
 	if self.Length == 0 {
 		return fmt.Sprintf(`
 
@@ -23,10 +23,10 @@ type %[1]s struct {
 }
 
 func (self *%[1]s) Size() int {
-    return %[3]d
+    return int(self.Profile.Size_%[1]s)
 }
 `,
-		name, profile_name, definition.Size)
+		name, profile_name)
 
 	for _, field_name := range definition.fields {
 		field_def := definition.Fields[field_name]
@@ -34,12 +34,68 @@ func (self *%[1]s) Size() int {
 			continue
 		}
 
-		result += field_def.GetParser().Compile(name, field_name)
+		code := field_def.GetParser().Compile(name, field_name)
+		if field_def.optional {
+			code = guardMethod(code, name, field_name, func(result_type string) string {
+				return fmt.Sprintf(`
+    if self.Profile.Off_%s_%s < 0 {
+        var zero %s
+        return zero
+    }`, name, field_name, result_type)
+			})
+		}
+		result += code
 	}
 
 	return result
 }
 
+// Fields missing in some versions of the profile have a negative
+// offset. Their methods are guarded so they return early instead of
+// accessing the bytes before the struct. The guard is inserted at the
+// start of the method and receives the method's result type.
+func guardMethod(code, receiver, method string,
+	guard func(result_type string) string) string {
+	signature := fmt.Sprintf("func (self *%s) %s(", receiver, method)
+	start := strings.Index(code, signature)
+	if start < 0 {
+		return code
+	}
+
+	end := strings.Index(code[start:], "{\n")
+	if end < 0 {
+		return code
+	}
+	end += start
+
+	// The declaration is "func (self *T) Method(args) result {".
+	declaration := code[start:end]
+	result_type := strings.TrimSpace(
+		declaration[strings.LastIndex(declaration, ")")+1:])
+
+	return code[:end+1] + guard(result_type) + code[end+1:]
+}
+
+// Code serializing a field which is missing in some versions only
+// runs when the field is present.
+func guardField(name, field_name string, field_def *FieldDefinition, code string) string {
+	if !field_def.optional || code == "" {
+		return code
+	}
+	return fmt.Sprintf("    if self.Profile.Off_%s_%s >= 0 {\n%s    }\n",
+		name, field_name, indentCode(code))
+}
+
+func indentCode(code string) string {
+	lines := strings.SplitAfter(code, "\n")
+	for idx, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[idx] = "    " + line
+		}
+	}
+	return strings.Join(lines, "")
+}
+
 func GenerateDebugString(name string, profile_name string, definition *StructDefinition) string {
 	result := fmt.Sprintf(
 		"func (self *%s) DebugString() string {\n    result := fmt.Sprintf("+
@@ -50,12 +106,19 @@ func GenerateDebugString(name string, profile_name string, definition *StructDef
 			continue
 		}
 
+		code := ""
 		if field_def.StringParser != nil ||
 			field_def.UTF16StringParser != nil {
-			result += fmt.Sprintf(
+			code = fmt.Sprintf(
 				"    result += fmt.Sprintf(\"  %[1]s: %%v\\n\", string(self.%[1]s()))\n",
 				field_name)
 
+		} else if field_def.WinFileTime != nil ||
+			field_def.UnixTimeStamp != nil {
+			code = fmt.Sprintf(
+				"    result += fmt.Sprintf(\"  %[1]s: %%v\\n\", self.%[1]s())\n",
+				field_name)
+
 		} else if field_def.Uint64Parser != nil ||
 			field_def.Int64Parser != nil ||
 			field_def.BitField != nil ||
@@ -65,19 +128,20 @@ func GenerateDebugString(name string, profile_name string, definition *StructDef
 			field_def.Int32Parser != nil ||
 			field_def.Uint8Parser != nil ||
 			field_def.Int8Parser != nil {
-			result += fmt.Sprintf(
+			code = fmt.Sprintf(
 				"    result += fmt.Sprintf(\"  %[1]s: %%#0x\\n\", self.%[1]s())\n",
 				field_name)
 		} else if field_def.Enumeration != nil || field_def.Flags != nil {
-			result += fmt.Sprintf(
+			code = fmt.Sprintf(
 				"    result += fmt.Sprintf(\"  %[1]s: %%v\\n\", self.%[1]s().DebugString())\n",
 				field_name)
 
 		} else if field_def.StructParser != nil {
-			result += fmt.Sprintf(
+			code = fmt.Sprintf(
 				"    result += fmt.Sprintf(\"  %[1]s: {\\n%%v}\\n\", indent(self.%[1]s().DebugString()))\n",
 				field_name)
 		}
+		result += guardField(name, field_name, field_def, code)
 	}
 
 	result += "    return result\n}\n"
@@ -0,0 +1,44 @@
+/* Compiled into dwarf.o and btf.o with:
+   gcc -g -gdwarf-4 -fno-eliminate-unused-debug-types -c -o dwarf.o dwarf.c
+   gcc -gbtf -fno-eliminate-unused-debug-types -c -o btf.o dwarf.c
+*/
+#include <stdint.h>
+
+enum state {
+    STATE_IDLE = 0,
+    STATE_RUNNING = 2,
+    STATE_DEAD = -1,
+};
+
+struct list_head {
+    struct list_head *next, *prev;
+};
+
+typedef struct {
+    uint16_t major;
+    uint16_t minor;
+} version_t;
+
+struct task {
+    int32_t pid;
+    enum state state;
+    unsigned int flags : 3;
+    unsigned int prio : 7;
+    struct list_head tasks;
+    char comm[16];
+    version_t version;
+    union {
+        uint64_t value;
+        uint8_t bytes[8];
+    };
+    struct {
+        uint32_t count;
+        void *data;
+    } stats;
+    double load;
+    const struct task *parent;
+    int (*handler)(void);
+    uint8_t payload[];
+};
+
+struct task init_task;
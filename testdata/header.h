@@ -0,0 +1,44 @@
+/* Test header for the C loader. */
+#include <stdint.h>
+
+#define NAME_LENGTH 8
+#define FLAG_COUNT (1 << 2)
+
+typedef enum _RECORD_TYPE {
+    RECORD_FILE = 1,
+    RECORD_DIRECTORY,
+    RECORD_LINK = 0x10,
+} RECORD_TYPE;
+
+struct _LIST_ENTRY {
+    struct _LIST_ENTRY *Flink;
+    struct _LIST_ENTRY *Blink;
+};
+
+typedef struct _RECORD {
+    uint8_t Version;
+    struct _LIST_ENTRY List;
+    RECORD_TYPE Type;
+    unsigned short Flags : 4;
+    unsigned short Kind : 12;
+    long Length;
+    char Name[NAME_LENGTH];
+    union {
+        uint32_t Value;
+        uint16_t Words[2];
+    };
+    struct {
+        int32_t X, Y;
+    } Point;
+    double Weight;
+    uint32_t Counts[FLAG_COUNT];
+    void (*Callback)(int, char *);
+} RECORD, *PRECORD;
+
+#pragma pack(push, 1)
+struct _PACKED {
+    uint8_t Tag;
+    uint32_t Length;
+    uint64_t Value;
+};
+#pragma pack(pop)
@@ -0,0 +1,85 @@
+package binparsergen
+
+import "fmt"
+
+// A 64 bit windows FILETIME: 100ns intervals since 1601-01-01.
+type WinFileTime struct {
+	BaseParser
+}
+
+func (self WinFileTime) Prototype() string {
+	return `
+func ParseWinFileTime(reader io.ReaderAt, offset int64) time.Time {
+    value := ParseUint64(reader, offset)
+    if value == 0 {
+       return time.Time{}
+    }
+    return time.Unix(0, (int64(value) - 116444736000000000) * 100).UTC()
+}
+`
+}
+
+func (self WinFileTime) PrototypeName() string {
+	return "ParseWinFileTime"
+}
+
+func (self WinFileTime) Compile(struct_name string, field_name string) string {
+	return fmt.Sprintf(`
+func (self *%[1]s) %[2]s() time.Time {
+   return ParseWinFileTime(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
+}
+`, struct_name, field_name)
+}
+
+func (self WinFileTime) GoType() string {
+	return "time.Time"
+}
+
+func (self WinFileTime) Size(value string) string {
+	return "8"
+}
+
+func (self WinFileTime) Dependencies() []Parser {
+	return []Parser{&Uint64Parser{}}
+}
+
+// A 32 bit unix epoch timestamp in seconds.
+type UnixTimeStamp struct {
+	BaseParser
+}
+
+func (self UnixTimeStamp) Prototype() string {
+	return `
+func ParseUnixTimeStamp(reader io.ReaderAt, offset int64) time.Time {
+    value := ParseUint32(reader, offset)
+    if value == 0 {
+       return time.Time{}
+    }
+    return time.Unix(int64(value), 0).UTC()
+}
+`
+}
+
+func (self UnixTimeStamp) PrototypeName() string {
+	return "ParseUnixTimeStamp"
+}
+
+func (self UnixTimeStamp) Compile(struct_name string, field_name string) string {
+	return fmt.Sprintf(`
+func (self *%[1]s) %[2]s() time.Time {
+   return ParseUnixTimeStamp(self.Reader, self.Profile.Off_%[1]s_%[2]s + self.Offset)
+}
+`, struct_name, field_name)
+}
+
+func (self UnixTimeStamp) GoType() string {
+	return "time.Time"
+}
+
+func (self UnixTimeStamp) Size(value string) string {
+	return "4"
+}
+
+func (self UnixTimeStamp) Dependencies() []Parser {
+	return []Parser{&Uint32Parser{}}
+}
@@ -0,0 +1,241 @@
+package binparsergen
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* When the spec sets GenerateTypes, the profile describes its own
+   structs at runtime. This allows generic tools (e.g. layout printers
+   or dynamic field access) to work with any generated profile:
+
+   for _, struct_info := range profile.Types() {
+       for _, field := range struct_info.Fields {
+           fmt.Println(struct_info.Name, field.Name, field.Offset)
+       }
+   }
+
+   offset, err := profile.GetOffset("GUID.Data1")
+   err = profile.SetOffset("GUID.Data1", 4)
+
+   The sizes and offsets are read from the profile so they reflect its
+   version and any offsets changed since.
+*/
+
+// The vtype names of the integer types.
+var typeInfoIntegerNames = map[string]string{
+	"uint64": "unsigned long long",
+	"int64":  "long long",
+	"uint32": "unsigned long",
+	"int32":  "long",
+	"uint16": "unsigned short",
+	"int16":  "short",
+	"byte":   "unsigned char",
+	"int8":   "char",
+}
+
+// The kind of the field, named like the vtype type (including the
+// byte order of big endian integers).
+func typeInfoKind(parser Parser) string {
+	switch t := parser.(type) {
+	case *Uint64Parser, *Int64Parser, *Uint32Parser, *Int32Parser,
+		*Uint16Parser, *Int16Parser, *Uint8Parser, *Int8Parser:
+		name := typeInfoIntegerNames[t.GoType()]
+		if strings.HasSuffix(t.PrototypeName(), "BE") {
+			if strings.HasPrefix(name, "unsigned ") {
+				return "unsigned be " + strings.TrimPrefix(name, "unsigned ")
+			}
+			return "be " + name
+		}
+		return name
+	case *StructParser:
+		return "Struct"
+	case *ArrayParser:
+		return "Array"
+	case *Pointer:
+		return "Pointer"
+	case *BitField:
+		return "BitField"
+	case *Enumeration:
+		return "Enumeration"
+	case *Flags:
+		return "Flags"
+	case *StringParser:
+		return "String"
+	case *UTF16StringParser:
+		return "UnicodeString"
+	case *SignatureParser:
+		return "Signature"
+	case *WinFileTime:
+		return "WinFileTime"
+	case *UnixTimeStamp:
+		return "UnixTimeStamp"
+	}
+	return ""
+}
+
+// The struct name for structs, otherwise the kind.
+func typeInfoTypeName(parser Parser) string {
+	if t, ok := parser.(*StructParser); ok {
+		return t.Target
+	}
+	return typeInfoKind(parser)
+}
+
+// The type the field refers to (e.g. the type of array elements).
+func typeInfoTarget(parser Parser) string {
+	switch t := parser.(type) {
+	case *StructParser:
+		return t.Target
+	case *ArrayParser:
+		return typeInfoTypeName(t.Target.GetParser())
+	case *Pointer:
+		return typeInfoTypeName(t.Target.GetParser())
+	case *BitField:
+		return typeInfoKind(t.getParser())
+	case *Enumeration:
+		return typeInfoKind(t.getParser())
+	case *Flags:
+		return typeInfoKind(t.getParser())
+	}
+	return ""
+}
+
+// Returns a Go expression for the size of the field within the
+// profile, or 0 if it depends on the data.
+func typeInfoSize(parser Parser, struct_names []string) string {
+	switch t := parser.(type) {
+	case *BitField:
+		return t.getParser().Size("")
+	case *Enumeration:
+		return t.getParser().Size("")
+	case *Flags:
+		return t.getParser().Size("")
+	case *Pointer:
+		return t.addressParser().Size("")
+	case *StringParser:
+		if t.DynamicLength != "" {
+			return "0"
+		}
+		return fmt.Sprintf("%d", t.Length)
+	case *UTF16StringParser:
+		if t.DynamicLength != "" {
+			return "0"
+		}
+		return fmt.Sprintf("%d", t.Length)
+	case *StructParser:
+		if !InString(struct_names, t.Target) {
+			return "0"
+		}
+		return fmt.Sprintf("self.Size_%s", t.Target)
+	case *ArrayParser:
+		element := typeInfoSize(t.Target.GetParser(), struct_names)
+		if t.DynamicCount != "" || element == "0" {
+			return "0"
+		}
+		return fmt.Sprintf("%d * %s", t.Count, element)
+	}
+
+	switch size := parser.Size(""); size {
+	case "1", "2", "4", "8":
+		return size
+	}
+	return "0"
+}
+
+func GenerateTypes(profile_name string,
+	profile map[string]*StructDefinition) string {
+	struct_names := []string{}
+	for struct_name := range profile {
+		struct_names = append(struct_names, NormalizeName(struct_name))
+	}
+
+	types := ""
+	cases := ""
+	for _, struct_name := range SortedKeys(profile) {
+		struct_def := profile[struct_name]
+		normalized_name := NormalizeName(struct_name)
+		types += fmt.Sprintf(
+			"        {Name: %q, Size: self.Size_%s, Fields: []FieldInfo{\n",
+			normalized_name, normalized_name)
+
+		for _, field_name := range struct_def.fields {
+			field_def := struct_def.Fields[field_name]
+			if field_def == nil {
+				continue
+			}
+
+			parser := field_def.GetParser()
+			types += fmt.Sprintf("            {Name: %q, Kind: %q, "+
+				"Offset: self.Off_%s_%s, Size: %s, Target: %q},\n",
+				field_name, typeInfoKind(parser), normalized_name, field_name,
+				typeInfoSize(parser, struct_names), typeInfoTarget(parser))
+
+			// Fields may also be named by the original struct name.
+			names := []string{fmt.Sprintf("%q", normalized_name+"."+field_name)}
+			if struct_name != normalized_name {
+				names = append(names, fmt.Sprintf("%q", struct_name+"."+field_name))
+			}
+			cases += fmt.Sprintf("    case %s:\n        return &self.Off_%s_%s\n",
+				strings.Join(names, ", "), normalized_name, field_name)
+		}
+		types += "        }},\n"
+	}
+
+	return fmt.Sprintf(`
+// The structs of the profile with their sizes and field layouts.
+func (self *%[1]s) Types() []*StructInfo {
+    return []*StructInfo{
+%[2]s    }
+}
+
+func (self *%[1]s) offsetField(name string) *int64 {
+    switch name {
+%[3]s    }
+    return nil
+}
+
+// The offset of a field by its name (e.g. "Struct.Field").
+func (self *%[1]s) GetOffset(name string) (int64, error) {
+    offset := self.offsetField(name)
+    if offset == nil {
+        return 0, fmt.Errorf("%[1]s: Unknown field %%v", name)
+    }
+    return *offset, nil
+}
+
+// Change the offset of a field by its name (e.g. "Struct.Field").
+func (self *%[1]s) SetOffset(name string, offset int64) error {
+    target := self.offsetField(name)
+    if target == nil {
+        return fmt.Errorf("%[1]s: Unknown field %%v", name)
+    }
+    *target = offset
+    return nil
+}
+`, profile_name, types, cases)
+}
+
+func GenerateTypesPrototypes() string {
+	return `
+// The layout of a struct in the profile.
+type StructInfo struct {
+    Name   string
+    Size   int64
+    Fields []FieldInfo
+}
+
+// The layout of a field. Kind is the vtype type of the field (e.g.
+// "unsigned long", "Pointer" or "Struct") and Target the type it
+// refers to (e.g. the array elements). Fields missing in the
+// profile's version have an offset of -1. The size is 0 when it
+// depends on the data.
+type FieldInfo struct {
+    Name   string
+    Kind   string
+    Offset int64
+    Size   int64
+    Target string
+}
+`
+}
@@ -0,0 +1,41 @@
+package binparsergen
+
+import (
+	"go/format"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestTypes(t *testing.T) {
+	spec := &ConversionSpec{
+		Module:        "main",
+		Profile:       "TestProfile",
+		Filename:      "testdata/vtypes.json",
+		Structs:       []string{"_GUID", "_HEADER"},
+		GenerateTypes: true,
+	}
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+
+	code := GenerateCode(spec, profile)
+	_, err = format.Source([]byte(code))
+	assert.NilError(t, err)
+
+	for _, expected := range []string{
+		"func (self *TestProfile) Types() []*StructInfo {",
+		"        {Name: \"GUID\", Size: self.Size_GUID, Fields: []FieldInfo{\n" +
+			"            {Name: \"Data1\", Kind: \"unsigned long\", Offset: self.Off_GUID_Data1, Size: 4, Target: \"\"},\n",
+		"{Name: \"Data4\", Kind: \"Array\", Offset: self.Off_GUID_Data4, Size: 8 * 1, Target: \"unsigned char\"},",
+		"{Name: \"Id\", Kind: \"Struct\", Offset: self.Off_HEADER_Id, Size: self.Size_GUID, Target: \"GUID\"},",
+		"{Name: \"Next\", Kind: \"Pointer\", Offset: self.Off_HEADER_Next, Size: 8, Target: \"HEADER\"},",
+		"{Name: \"Dyn\", Kind: \"Array\", Offset: self.Off_HEADER_Dyn, Size: 0, Target: \"unsigned char\"},",
+		"    case \"GUID.Data1\", \"_GUID.Data1\":\n        return &self.Off_GUID_Data1\n",
+		"func (self *TestProfile) SetOffset(name string, offset int64) error {",
+		"type FieldInfo struct {",
+	} {
+		assert.Assert(t, strings.Contains(code, expected), expected)
+	}
+}
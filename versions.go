@@ -0,0 +1,225 @@
+package binparsergen
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+/* A spec may list the vtype files of several versions of the same
+   profile (e.g. different Windows builds). All versions share the one
+   generated API but each has its own offsets, struct sizes and
+   constants, selected when the profile is created:
+
+   profile, err := NewNtProfileForVersion("Win10_1809")
+
+   Fields which are missing in some versions get a Has_<Field>()
+   method to check for them at runtime. Where they are missing their
+   accessors return zero values, setters do nothing and they are left
+   out of DebugString(), Decode(), MarshalJSON() and ToDict().
+*/
+
+// The offsets, struct sizes and constants of one version.
+type profileVersion struct {
+	name      string
+	offsets   map[string]map[string]int64
+	sizes     map[string]uint32
+	constants map[string]uint64
+
+	// Only used to check that all versions use the same pointers.
+	pointer_size int
+}
+
+// The offset of the field in this version or -1 if it is missing.
+func (self *profileVersion) offset(struct_name, field_name string) int64 {
+	offset, pres := self.offsets[struct_name][field_name]
+	if !pres {
+		return -1
+	}
+	return offset
+}
+
+// The version names with the default version first.
+func versionNames(spec *ConversionSpec) []string {
+	names := SortedKeys(spec.Versions)
+	default_version := spec.DefaultVersion
+	if default_version == "" {
+		return names
+	}
+
+	sort.SliceStable(names, func(i, j int) bool {
+		return names[i] == default_version && names[j] != default_version
+	})
+	return names
+}
+
+// Convert each version and merge their structs. The generated
+// accessors are shared so fields must have the same type and
+// pointers the same size in all versions.
+func convertVersions(spec *ConversionSpec) (map[string]*StructDefinition, error) {
+	_, pres := spec.Versions[spec.DefaultVersion]
+	if spec.DefaultVersion != "" && !pres {
+		return nil, fmt.Errorf("DefaultVersion %v is not in Versions",
+			spec.DefaultVersion)
+	}
+
+	profile := make(map[string]*StructDefinition)
+	spec.versions = nil
+
+	// The version which first had each field.
+	field_versions := make(map[string]string)
+	for _, name := range versionNames(spec) {
+		version_spec := *spec
+		version_spec.Filename = spec.Versions[name]
+		version_spec.Versions = nil
+		version_spec.Detect = nil
+
+		// Constants only need to be defined by one version.
+		version_spec.Constants = nil
+
+		version_profile, info, err := convertSpec(&version_spec)
+		if err != nil {
+			return nil, fmt.Errorf("Version %v: %w", name, err)
+		}
+
+		// Pointers are 8 bytes unless they are 4.
+		pointer_size := spec.PointerSize
+		if pointer_size == 0 {
+			pointer_size = info.PointerSize
+		}
+		if pointer_size != 4 {
+			pointer_size = 8
+		}
+
+		version := &profileVersion{
+			name:         name,
+			offsets:      make(map[string]map[string]int64),
+			sizes:        make(map[string]uint32),
+			constants:    info.Constants,
+			pointer_size: pointer_size,
+		}
+		if len(spec.versions) > 0 &&
+			spec.versions[0].pointer_size != pointer_size {
+			return nil, fmt.Errorf("Version %v: pointers are %d bytes "+
+				"but %d bytes in version %v", name, pointer_size,
+				spec.versions[0].pointer_size, spec.versions[0].name)
+		}
+
+		for _, struct_name := range SortedKeys(version_profile) {
+			struct_def := version_profile[struct_name]
+			version.sizes[struct_name] = struct_def.Size
+			version.offsets[struct_name] = make(map[string]int64)
+
+			merged, pres := profile[struct_name]
+			if !pres {
+				merged = &StructDefinition{
+					Size:   struct_def.Size,
+					Fields: make(map[string]*FieldDefinition),
+				}
+				profile[struct_name] = merged
+			}
+
+			for _, field_name := range struct_def.fields {
+				field_def := struct_def.Fields[field_name]
+				if field_def == nil {
+					continue
+				}
+				version.offsets[struct_name][field_name] = field_def.Offset
+
+				path := struct_name + "." + field_name
+				existing, pres := merged.Fields[field_name]
+				if !pres {
+					merged.fields = append(merged.fields, field_name)
+					merged.Fields[field_name] = field_def
+					field_versions[path] = name
+
+				} else if !sameFieldType(existing, field_def) {
+					return nil, fmt.Errorf("Version %v: %v has a different "+
+						"type than in version %v", name, path,
+						field_versions[path])
+				}
+			}
+		}
+
+		spec.versions = append(spec.versions, version)
+	}
+
+	for struct_name, struct_def := range profile {
+		for field_name, field_def := range struct_def.Fields {
+			for _, version := range spec.versions {
+				if field_def != nil && version.offset(struct_name, field_name) < 0 {
+					field_def.optional = true
+				}
+			}
+		}
+	}
+
+	constants := []map[string]uint64{}
+	for _, version := range spec.versions {
+		constants = append(constants, version.constants)
+	}
+	err := checkConstants(spec, constants...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkDetectSpec(spec, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+func sameFieldType(a, b *FieldDefinition) bool {
+	a_copy, b_copy := *a, *b
+	a_copy.Offset, b_copy.Offset = 0, 0
+
+	a_serialized, _ := json.Marshal(a_copy)
+	b_serialized, _ := json.Marshal(b_copy)
+	return string(a_serialized) == string(b_serialized)
+}
+
+// The versions to generate. Without Versions this is a single
+// unnamed version from the profile itself. Only the constants named
+// in the spec are generated.
+func profileVersions(spec *ConversionSpec,
+	profile map[string]*StructDefinition) []*profileVersion {
+	versions := spec.versions
+	if len(versions) == 0 {
+		version := &profileVersion{
+			offsets:   make(map[string]map[string]int64),
+			sizes:     make(map[string]uint32),
+			constants: spec.constants,
+		}
+		for struct_name, struct_def := range profile {
+			version.sizes[struct_name] = struct_def.Size
+			version.offsets[struct_name] = make(map[string]int64)
+			for field_name, field_def := range struct_def.Fields {
+				if field_def != nil {
+					version.offsets[struct_name][field_name] = field_def.Offset
+				}
+			}
+		}
+		versions = []*profileVersion{version}
+	}
+
+	result := []*profileVersion{}
+	for _, version := range versions {
+		constants := make(map[string]uint64)
+		for _, name := range spec.Constants {
+			value, pres := version.constants[name]
+			if pres {
+				constants[name] = value
+			}
+		}
+
+		result = append(result, &profileVersion{
+			name:      version.name,
+			offsets:   version.offsets,
+			sizes:     version.sizes,
+			constants: constants,
+		})
+	}
+	return result
+}
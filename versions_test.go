@@ -0,0 +1,208 @@
+package binparsergen
+
+import (
+	"encoding/json"
+	"go/format"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	"gotest.tools/assert"
+)
+
+func TestVersions(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/vtypes.json")
+	assert.NilError(t, err)
+
+	// The new version moves Data2 and drops Data3.
+	vtypes := ordereddict.NewDict()
+	assert.NilError(t, json.Unmarshal(data, vtypes))
+	value, _ := vtypes.Get("_GUID")
+	guid := value.([]interface{})
+	guid[0] = 24
+	fields := guid[1].(*ordereddict.Dict)
+	value, _ = fields.Get("Data2")
+	value.([]interface{})[0] = 16
+	fields.Delete("Data3")
+
+	data, err = json.Marshal(vtypes)
+	assert.NilError(t, err)
+	filename := filepath.Join(t.TempDir(), "v2.json")
+	assert.NilError(t, ioutil.WriteFile(filename, data, 0644))
+
+	spec := &ConversionSpec{
+		Module:  "main",
+		Profile: "TestProfile",
+		Structs: []string{"_GUID", "_HEADER"},
+		Versions: map[string]string{
+			"V1": "testdata/vtypes.json",
+			"V2": filename,
+		},
+		DefaultVersion: "V2",
+	}
+
+	profile, err := ConvertSpec(spec)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, profile["_GUID"].fields, []string{
+		"Data1", "Data2", "Data4", "Data3"})
+
+	code := GenerateCode(spec, profile)
+	_, err = format.Source([]byte(code))
+	assert.NilError(t, err)
+
+	for _, expected := range []string{
+		"var TestProfileVersions = []string{\"V1\", \"V2\"}",
+		"    self, _ := NewTestProfileForVersion(\"V2\")",
+		"    case \"V2\":\n        return &TestProfile{\n    Size_GUID: 24,\n",
+		"    Off_GUID_Data2: 16,\n",
+		"    Off_GUID_Data3: -1,\n",
+		"    case \"V1\":\n        return &TestProfile{\n    Size_GUID: 16,\n",
+		"func (self *GUID) Has_Data3() bool {",
+		"    return int(self.Profile.Size_GUID)",
+	} {
+		assert.Assert(t, strings.Contains(code, expected), expected)
+	}
+	assert.Assert(t, !strings.Contains(code, "Has_Data2"))
+}
+
+func TestVersionsMissingFields(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/vtypes.json")
+	assert.NilError(t, err)
+
+	// The new version drops fields of several kinds.
+	vtypes := ordereddict.NewDict()
+	assert.NilError(t, json.Unmarshal(data, vtypes))
+	value, _ := vtypes.Get("_GUID")
+	value.([]interface{})[1].(*ordereddict.Dict).Delete("Data3")
+	value, _ = vtypes.Get("_HEADER")
+	header := value.([]interface{})[1].(*ordereddict.Dict)
+	for _, field_name := range []string{"Magic", "Type", "Id", "Items", "Next"} {
+		header.Delete(field_name)
+	}
+
+	data, err = json.Marshal(vtypes)
+	assert.NilError(t, err)
+	filename := filepath.Join(t.TempDir(), "v2.json")
+	assert.NilError(t, ioutil.WriteFile(filename, data, 0644))
+
+	// The structs are preceded by 0xff bytes which are read if the
+	// missing fields' offsets (-1) are used.
+	output := runGeneratedCode(t, &ConversionSpec{
+		Structs: []string{"_GUID", "_HEADER"},
+		Versions: map[string]string{
+			"V1": "testdata/vtypes.json",
+			"V2": filename,
+		},
+		GenerateDebugString:    true,
+		GenerateBuilder:        true,
+		GenerateDecode:         true,
+		GenerateJSON:           true,
+		GenerateDict:           true,
+		DictPointers:           DICT_LAZY,
+		GenerateErrorAccessors: true,
+	}, `package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func main() {
+	profile, _ := NewTestProfileForVersion("V2")
+	guid_data := profile.NewGUIDBuilder().SetData1(1).SetData3(2).Bytes()
+	header_data := profile.NewHEADERBuilder().SetVersion(3).SetType(2).
+		SetItems([]uint16{7, 8}).Bytes()
+
+	reader := bytes.NewReader(append(bytes.Repeat([]byte{0xff}, 16), guid_data...))
+	guid := profile.GUID(reader, 16)
+	fmt.Println(guid.Has_Data3(), guid.Data3())
+
+	_, err := guid.Data3E()
+	fmt.Println(errors.Is(err, ErrMissingField))
+
+	serialized, _ := json.Marshal(guid)
+	fmt.Println(string(serialized))
+	fmt.Printf("%+v\n", *guid.Decode())
+	fmt.Println(guid.ToDict().Keys())
+	fmt.Println(strings.Contains(guid.DebugString(), "Data3"))
+
+	reader = bytes.NewReader(append(bytes.Repeat([]byte{0xff}, 16), header_data...))
+	header := profile.HEADER(reader, 16)
+	fmt.Println(header.Magic() == nil, header.Type() == nil, header.Id() == nil,
+		header.Items() == nil, header.Next() == nil)
+
+	serialized, _ = json.Marshal(header)
+	fmt.Println(strings.Contains(string(serialized), "Type"),
+		strings.Contains(header.DebugString(), "Id"))
+}
+`)
+
+	assert.Equal(t, output, "false 0\n"+
+		"true\n"+
+		`{"Data1":1,"Data2":0,"Data4":[0,0,0,0,0,0,0,0]}`+"\n"+
+		"{Offset:16 Data1:1 Data2:0 Data3:0 Data4:[0 0 0 0 0 0 0 0]}\n"+
+		"[Data1 Data2 Data4]\n"+
+		"false\n"+
+		"true true true true true\n"+
+		"false false\n")
+}
+
+// Versions share the accessors so their types and pointers must match.
+func TestVersionsConflicts(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/vtypes.json")
+	assert.NilError(t, err)
+
+	vtypes := ordereddict.NewDict()
+	assert.NilError(t, json.Unmarshal(data, vtypes))
+	rekall := ordereddict.NewDict().
+		Set("$METADATA", map[string]string{"Type": "Profile", "arch": "I386"}).
+		Set("$STRUCTS", vtypes)
+	data, err = json.Marshal(rekall)
+	assert.NilError(t, err)
+	i386_filename := filepath.Join(t.TempDir(), "i386.json")
+	assert.NilError(t, ioutil.WriteFile(i386_filename, data, 0644))
+
+	value, _ := vtypes.Get("_GUID")
+	fields := value.([]interface{})[1].(*ordereddict.Dict)
+	fields.Set("Data2", []interface{}{4, []interface{}{"unsigned long", map[string]interface{}{}}})
+	data, err = json.Marshal(vtypes)
+	assert.NilError(t, err)
+	retyped_filename := filepath.Join(t.TempDir(), "retyped.json")
+	assert.NilError(t, ioutil.WriteFile(retyped_filename, data, 0644))
+
+	_, err = ConvertSpec(&ConversionSpec{
+		Structs: []string{"_GUID"},
+		Versions: map[string]string{
+			"V1": "testdata/vtypes.json",
+			"V2": retyped_filename,
+		},
+	})
+	assert.Error(t, err, "Version V2: _GUID.Data2 has a different type "+
+		"than in version V1")
+
+	_, err = ConvertSpec(&ConversionSpec{
+		Structs: []string{"_GUID"},
+		Versions: map[string]string{
+			"V1": "testdata/vtypes.json",
+			"V2": i386_filename,
+		},
+	})
+	assert.Error(t, err, "Version V2: pointers are 4 bytes but 8 bytes "+
+		"in version V1")
+
+	// Unless the spec sets the size of all versions' pointers.
+	_, err = ConvertSpec(&ConversionSpec{
+		Structs:     []string{"_GUID"},
+		PointerSize: 4,
+		Versions: map[string]string{
+			"V1": "testdata/vtypes.json",
+			"V2": i386_filename,
+		},
+	})
+	assert.NilError(t, err)
+}
@@ -3,12 +3,16 @@ package binparsergen
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"log"
 	"os"
 
 	"github.com/Velocidex/ordereddict"
 )
 
+// Warnings during conversion are reported here. The generated code
+// goes to stdout so this defaults to stderr.
+var Logger = log.New(os.Stderr, "", 0)
+
 func FatalIfError(err error, format string, args ...interface{}) {
 	if err != nil {
 		fmt.Printf(format, args...)
@@ -18,70 +22,195 @@ func FatalIfError(err error, format string, args ...interface{}) {
 }
 
 func ConvertSpec(spec *ConversionSpec) (map[string]*StructDefinition, error) {
-	fd, err := os.Open(spec.Filename)
+	err := checkDictSpec(spec)
 	if err != nil {
 		return nil, err
 	}
 
-	definitions, err := ioutil.ReadAll(fd)
+	if len(spec.Versions) > 0 {
+		return convertVersions(spec)
+	}
+
+	profile, _, err := convertSpec(spec)
+	return profile, err
+}
+
+// Convert the spec's definitions. Also returns what the loaded files
+// define about the profile.
+func convertSpec(spec *ConversionSpec) (
+	map[string]*StructDefinition, *ProfileInfo, error) {
+	definitions, err := LoadDefinitions(spec)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	// The constants are kept for generating the profile. The rest of
+	// the conversion uses what the files define unless the spec sets
+	// it.
+	spec.constants = definitions.Constants
+	spec = definitions.apply(spec)
+
 	var types map[string][]*json.RawMessage
 
-	err = json.Unmarshal(definitions, &types)
+	err = json.Unmarshal(definitions.VTypes, &types)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	patterns, err := newSpecPatterns(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = checkFieldOptions(types, spec)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	profile := make(map[string]*StructDefinition)
 
+	// The structs referenced by the selected structs are found by
+	// their normalized names.
+	type_names := make(map[string]string)
+	for type_name := range types {
+		type_names[NormalizeName(type_name)] = type_name
+	}
+
+	queue := []string{}
+	depths := make(map[string]int)
 	for _, type_name := range SortedKeys(types) {
-		definition_list := types[type_name]
-		if !InString(spec.Structs, type_name) {
-			continue
+		if patterns.structs.Match(type_name, type_name) &&
+			!patterns.exclude_structs.Match(type_name, type_name) {
+			queue = append(queue, type_name)
+			depths[type_name] = 0
 		}
+	}
 
-		struct_def := &StructDefinition{
-			Fields: make(map[string]*FieldDefinition),
-		}
-		err := json.Unmarshal(*definition_list[0], &struct_def.Size)
-		if err != nil {
-			return nil, err
-		}
+	for len(queue) > 0 {
+		type_name := queue[0]
+		queue = queue[1:]
 
-		fields := make(map[string][]*json.RawMessage)
-		err = json.Unmarshal(*definition_list[1], &fields)
+		struct_def, err := parseStructDef(
+			type_name, types[type_name], spec, patterns)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		profile[type_name] = struct_def
 
-		ordered_fields := ordereddict.NewDict()
-		err = json.Unmarshal(*definition_list[1], &ordered_fields)
-		if err != nil {
-			return nil, err
+		depth := depths[type_name]
+		if !spec.IncludeReferenced ||
+			(spec.IncludeDepth > 0 && depth >= spec.IncludeDepth) {
+			continue
 		}
 
-		// Preserve the order of the fields.
-		struct_def.fields = ordered_fields.Keys()
 		for _, field_name := range struct_def.fields {
-			field_def := fields[field_name]
-			if InString(spec.FieldBlackList[type_name], field_name) {
+			parser, _ := referencedStruct(struct_def.Fields[field_name])
+			if parser == nil {
 				continue
 			}
 
-			allowed_fields, pres := spec.FieldWhiteList[type_name]
-			if pres && !InString(allowed_fields, field_name) {
+			referenced, pres := type_names[parser.Target]
+			if !pres || patterns.exclude_structs.Match(referenced, referenced) {
 				continue
 			}
-			struct_def.Fields[field_name] = ParseFieldDef(field_def, spec)
+
+			_, pres = depths[referenced]
+			if !pres {
+				depths[referenced] = depth + 1
+				queue = append(queue, referenced)
+			}
 		}
+	}
 
-		profile[type_name] = struct_def
+	err = resolveReferences(profile, spec)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return profile, nil
+	err = checkConstants(spec, spec.constants)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	patterns.Summary(spec.Verbose)
+	markLoadableFields(spec, profile)
+
+	err = checkDetectSpec(spec, profile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return profile, &definitions.ProfileInfo, nil
+}
+
+func parseStructDef(type_name string, definition_list []*json.RawMessage,
+	spec *ConversionSpec, patterns *specPatterns) (*StructDefinition, error) {
+	struct_def := &StructDefinition{
+		Fields: make(map[string]*FieldDefinition),
+	}
+	err := json.Unmarshal(*definition_list[0], &struct_def.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string][]*json.RawMessage)
+	err = json.Unmarshal(*definition_list[1], &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	ordered_fields := ordereddict.NewDict()
+	err = json.Unmarshal(*definition_list[1], &ordered_fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// Preserve the order of the fields.
+	struct_def.fields = ordered_fields.Keys()
+	for _, field_name := range struct_def.fields {
+		field_def := fields[field_name]
+		_, blacklisted := patterns.field_blacklist.Match(type_name, field_name)
+		if blacklisted {
+			continue
+		}
+
+		pres, allowed := patterns.field_whitelist.Match(type_name, field_name)
+		if pres && !allowed {
+			continue
+		}
+
+		vtype_type := vtypeTypeName(field_def)
+		override, pres := spec.FieldTypeOverrides[type_name][field_name]
+		if pres {
+			field_def, err = overrideFieldDef(field_def, override)
+			if err != nil {
+				return nil, fmt.Errorf("FieldTypeOverrides: %v.%v: %w",
+					type_name, field_name, err)
+			}
+		}
+		new_field_def := ParseFieldDef(field_def, spec)
+		new_field_def.vtype_name = field_name
+		new_field_def.vtype_type = vtype_type
+		struct_def.Fields[field_name] = new_field_def
+	}
+
+	err = renameFields(type_name, struct_def, spec.FieldRenames[type_name])
+	if err != nil {
+		return nil, err
+	}
+
+	return struct_def, nil
+}
+
+// The type name of a vtype field definition as compared by the offset
+// loader.
+func vtypeTypeName(field_def []*json.RawMessage) string {
+	var params []json.RawMessage
+	if len(field_def) < 2 || field_def[1] == nil ||
+		json.Unmarshal(*field_def[1], &params) != nil {
+		return ""
+	}
+	return VtypeTypeName(params)
 }
 
 func ParseFieldDef(field_def []*json.RawMessage, spec *ConversionSpec) *FieldDefinition {
@@ -108,30 +237,29 @@ func _ParseParams(params []json.RawMessage, spec *ConversionSpec) *FieldDefiniti
 	err := json.Unmarshal(params[0], &parser_name)
 	FatalIfError(err, "Decoding parser name")
 
-	switch parser_name {
-	case "unsigned long long", "uint64":
-		new_field_def.Uint64Parser = &Uint64Parser{BaseParser: base_parser}
-	case "long long", "int64":
-		new_field_def.Int64Parser = &Int64Parser{BaseParser: base_parser}
-
-	case "unsigned long", "uint32":
-		new_field_def.Uint32Parser = &Uint32Parser{BaseParser: base_parser}
-
-	case "long":
-		new_field_def.Int32Parser = &Int32Parser{BaseParser: base_parser}
-
-	case "unsigned short":
-		new_field_def.Uint16Parser = &Uint16Parser{BaseParser: base_parser}
-
-	case "short":
-		new_field_def.Int16Parser = &Int16Parser{BaseParser: base_parser}
-
-	case "unsigned char":
-		new_field_def.Uint8Parser = &Uint8Parser{BaseParser: base_parser}
-
-	case "char":
-		new_field_def.Int8Parser = &Int8Parser{BaseParser: base_parser}
+	if parser := newPrimitiveParser(parser_name, base_parser); parser != nil {
+		switch t := parser.(type) {
+		case *Uint64Parser:
+			new_field_def.Uint64Parser = t
+		case *Int64Parser:
+			new_field_def.Int64Parser = t
+		case *Uint32Parser:
+			new_field_def.Uint32Parser = t
+		case *Int32Parser:
+			new_field_def.Int32Parser = t
+		case *Uint16Parser:
+			new_field_def.Uint16Parser = t
+		case *Int16Parser:
+			new_field_def.Int16Parser = t
+		case *Uint8Parser:
+			new_field_def.Uint8Parser = t
+		case *Int8Parser:
+			new_field_def.Int8Parser = t
+		}
+		return new_field_def
+	}
 
+	switch parser_name {
 	case "Pointer":
 		vtype_array := &VtypeArray{}
 		err = json.Unmarshal(params[1], &vtype_array)
@@ -141,8 +269,10 @@ func _ParseParams(params []json.RawMessage, spec *ConversionSpec) *FieldDefiniti
 			vtype_array.Target, vtype_array.TargetArgs}, spec)
 
 		new_field_def.Pointer = &Pointer{
-			BaseParser: base_parser,
-			Target:     target_field_def,
+			BaseParser:  base_parser,
+			Target:      target_field_def,
+			PointerSize: spec.PointerSize,
+			BigEndian:   spec.PointerBigEndian,
 		}
 
 	case "Enumeration":
@@ -150,6 +280,9 @@ func _ParseParams(params []json.RawMessage, spec *ConversionSpec) *FieldDefiniti
 		err = json.Unmarshal(params[1], &enumeration)
 		FatalIfError(err, "Decoding")
 
+		if enumeration.Choices == nil && enumeration.EnumName != "" {
+			enumeration.Choices = spec.enums[enumeration.EnumName]
+		}
 		new_field_def.Enumeration = enumeration
 
 	case "Signature":
@@ -191,6 +324,12 @@ func _ParseParams(params []json.RawMessage, spec *ConversionSpec) *FieldDefiniti
 
 		new_field_def.UTF16StringParser = string_parser
 
+	case "WinFileTime":
+		new_field_def.WinFileTime = &WinFileTime{BaseParser: base_parser}
+
+	case "UnixTimeStamp":
+		new_field_def.UnixTimeStamp = &UnixTimeStamp{BaseParser: base_parser}
+
 	case "Array":
 		vtype_array := &VtypeArray{}
 		err = json.Unmarshal(params[1], &vtype_array)
@@ -207,11 +346,9 @@ func _ParseParams(params []json.RawMessage, spec *ConversionSpec) *FieldDefiniti
 		}
 
 	default:
-		// This must be a reference to a custom type the user
-		// may implement themselves.
-		if !InString(spec.Structs, parser_name) {
-			//log.Warn("Reference to undefined struct ", parser_name)
-		}
+		// This must be a reference to another struct. References
+		// to structs which are not generated are resolved in
+		// ConvertSpec.
 		new_field_def.StructParser = &StructParser{
 			BaseParser: base_parser,
 			Target:     NormalizeName(parser_name),
@@ -220,9 +357,84 @@ func _ParseParams(params []json.RawMessage, spec *ConversionSpec) *FieldDefiniti
 	return new_field_def
 }
 
+// Returns a parser for the primitive type name or nil if the name
+// is not a primitive.
+func newPrimitiveParser(name string, base_parser BaseParser) Parser {
+	switch name {
+	case "unsigned long long", "uint64":
+		return &Uint64Parser{BaseParser: base_parser}
+	case "unsigned be long long":
+		return &Uint64Parser{BaseParser: base_parser, BigEndian: true}
+
+	case "long long", "int64":
+		return &Int64Parser{BaseParser: base_parser}
+	case "be long long":
+		return &Int64Parser{BaseParser: base_parser, BigEndian: true}
+
+	case "unsigned long", "unsigned int", "uint32":
+		return &Uint32Parser{BaseParser: base_parser}
+	case "unsigned be long", "unsigned be int":
+		return &Uint32Parser{BaseParser: base_parser, BigEndian: true}
+
+	case "long", "int", "int32":
+		return &Int32Parser{BaseParser: base_parser}
+	case "be long", "be int":
+		return &Int32Parser{BaseParser: base_parser, BigEndian: true}
+
+	case "unsigned short", "uint16":
+		return &Uint16Parser{BaseParser: base_parser}
+	case "unsigned be short":
+		return &Uint16Parser{BaseParser: base_parser, BigEndian: true}
+
+	case "short", "int16":
+		return &Int16Parser{BaseParser: base_parser}
+	case "be short":
+		return &Int16Parser{BaseParser: base_parser, BigEndian: true}
+
+	case "unsigned char", "uint8":
+		return &Uint8Parser{BaseParser: base_parser}
+
+	case "char", "int8":
+		return &Int8Parser{BaseParser: base_parser}
+	}
+
+	return nil
+}
+
+// The parser of an integer type by its vtype name (e.g. the target of
+// an Enumeration). Unknown types are read as 64 bit integers.
+func IntegerParser(name string) Parser {
+	parser := newPrimitiveParser(name, BaseParser{})
+	if parser == nil {
+		return &Uint64Parser{}
+	}
+	return parser
+}
+
 type VtypeArray struct {
 	Target       json.RawMessage
-	TargetArgs   json.RawMessage
+	TargetArgs   json.RawMessage `json:"target_args"`
 	Count        int
 	DynamicCount string `json:"dynamic_count,omitempty"`
 }
+
+// Older vtype files spell the target args like the field name
+// (e.g. "TargetArgs") so accept both.
+func (self *VtypeArray) UnmarshalJSON(data []byte) error {
+	type vtypeArray VtypeArray
+	item := struct {
+		vtypeArray
+		OldTargetArgs json.RawMessage `json:"TargetArgs"`
+	}{}
+
+	err := json.Unmarshal(data, &item)
+	if err != nil {
+		return err
+	}
+
+	*self = VtypeArray(item.vtypeArray)
+	if len(self.TargetArgs) == 0 {
+		self.TargetArgs = item.OldTargetArgs
+	}
+	return nil
+}
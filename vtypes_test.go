@@ -0,0 +1,22 @@
+package binparsergen
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestVtypeArrayTargetArgs(t *testing.T) {
+	for _, data := range []string{
+		`{"target": "Enumeration", "target_args": {"target": "long"}, "count": 2}`,
+		`{"Target": "Enumeration", "TargetArgs": {"target": "long"}, "Count": 2}`,
+		`{"target": "Enumeration", "targetargs": {"target": "long"}, "count": 2}`,
+	} {
+		vtype_array := &VtypeArray{}
+		assert.NilError(t, json.Unmarshal([]byte(data), vtype_array))
+		assert.Equal(t, string(vtype_array.Target), `"Enumeration"`, data)
+		assert.Equal(t, string(vtype_array.TargetArgs), `{"target": "long"}`, data)
+		assert.Equal(t, vtype_array.Count, 2, data)
+	}
+}